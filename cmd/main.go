@@ -40,7 +40,14 @@
 //    - Analyzes traffic patterns
 //    Output: Security insights and anomaly detection
 //
-// 3. Interactive Analysis via Web GUI:
+// 3. Batch Analysis from Scripts and CI:
+//    Input: ./deeppacketai -i capture.pcap -p "Why did the call fail?" -llm Ollama -m llama3
+//    Analysis:
+//    - Decodes the given captures without starting the browser
+//    - Sends the prompt to the selected AI provider
+//    Output: AI answer on stdout, non-zero exit status on failure
+//
+// 4. Interactive Analysis via Web GUI:
 //    - Upload pcap files through web interface
 //    - Select specific protocols for analysis
 //    - Real-time chat with AI for insights
@@ -56,6 +63,13 @@ package main
 
 import (
 	chatgpt_api "DeepPacketAI/internal/ai-client/chatgpt-client"
+	decode "DeepPacketAI/internal/analyzer"
+	database "DeepPacketAI/internal/storage"
+	"DeepPacketAI/pkg/config"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
 )
 
 // main initializes and orchestrates the DeepPacketAI analysis pipeline
@@ -90,8 +104,129 @@ import (
 //   - Interact with AI for analysis
 //
 //     2. Command Line:
-//     ./deeppacketai
+//     ./deeppacketai -i a.pcap,b.pcap -p "Summarise the SIP errors" -llm ChatGPT -m gpt-4o
+//     ./deeppacketai -d ./captures -start-time 10:15 -end-time 10:17 -p "What happened?"
+//     ./deeppacketai -db deeppacketai.db -i a.pcap -p "List the calls"
+//     ./deeppacketai -live eth0 -bpf "udp port 5060" -duration 60s -p "Any failed calls?"
 func main() {
-	// Initialize web interface and AI chat functionality
-	chatgpt_api.HandleWebPage()
+	// Parse command line options
+	config.HandleUserInput()
+
+	// Select where decoded captures are stored
+	if err := database.Open(config.Input.Database); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	defer database.Default.Close()
+
+	// Live capture from an interface (or a replayed file)
+	if config.Input.Live != "" || config.Input.Replay != "" {
+		if err := runLive(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			database.Default.Close() // os.Exit skips deferred calls
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Without input captures start the interactive web interface
+	if len(config.Input.Files) == 0 {
+		// Initialize web interface and AI chat functionality
+		chatgpt_api.HandleWebPage()
+		return
+	}
+
+	// Run headless analysis and report failures through the exit status
+	if err := runBatch(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		database.Default.Close() // os.Exit skips deferred calls
+		os.Exit(1)
+	}
+}
+
+// runBatch decodes the configured captures and asks the AI the -p prompt
+// The answer is the only output written to stdout
+func runBatch() error {
+	if config.Input.Prompt == "" {
+		return fmt.Errorf("a prompt (-p) is required when analysing files with -i or -d")
+	}
+
+	// Decode all captures
+	messages, err := decode.Process(config.Input.Files)
+	if err != nil {
+		return err
+	}
+
+	// Query the selected provider once
+	answer, err := chatgpt_api.Analyze(messages, config.Input.LLM, config.Input.Model, config.Input.Prompt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(answer)
+	return nil
+}
+
+// runLive captures traffic into a rolling window of decoded messages
+// With -p the AI is asked once when the capture stops (duration, packet
+// limit, end of replay or Ctrl-C); otherwise the web interface is started
+// and sessions can analyse the window while the capture runs
+func runLive() error {
+	opts := decode.LiveOptions{
+		Interface:   config.Input.Live,
+		Filter:      config.Input.BPF,
+		Snaplen:     int32(config.Input.Snaplen),
+		Promiscuous: config.Input.Promiscuous,
+		Duration:    config.Input.Duration,
+		PacketLimit: config.Input.PacketLimit,
+	}
+
+	// Open the interface or the replayed file
+	var source decode.PacketSource
+	var closeSource func()
+	var err error
+	if config.Input.Replay != "" {
+		source, closeSource, err = decode.OpenReplay(config.Input.Replay)
+	} else {
+		source, closeSource, err = decode.OpenLive(opts)
+	}
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	window := database.NewWindow(config.Input.WindowSize, config.Input.WindowAge)
+
+	// Ctrl-C stops the capture
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Interactive: keep capturing while the web interface serves the window
+	if config.Input.Prompt == "" {
+		go func() {
+			if err := decode.Capture(ctx, source, opts, window); err != nil {
+				fmt.Fprintln(os.Stderr, "Live capture stopped:", err)
+			}
+		}()
+		chatgpt_api.SetLiveWindow(window)
+		chatgpt_api.HandleWebPage()
+		return nil
+	}
+
+	// Headless: capture until a limit is reached, then ask once
+	if err := decode.Capture(ctx, source, opts, window); err != nil {
+		return err
+	}
+	messages := window.Messages()
+	if len(messages) == 0 {
+		return fmt.Errorf("no messages decoded from the live capture")
+	}
+
+	answer, err := chatgpt_api.Analyze(messages, config.Input.LLM, config.Input.Model, config.Input.Prompt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(answer)
+	return nil
 }
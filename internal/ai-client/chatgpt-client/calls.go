@@ -0,0 +1,166 @@
+// calls.go
+// This file serves the calls of a session and the audio of their media streams to the web page.
+// Core functionalities:
+// - Summarises each SIP call with the quality of its RTP streams, one per direction
+// - Plays out an RTP stream through a simulated jitter buffer and returns it as WAV
+//
+// Example scenarios:
+// 1. Call summary after an upload:
+//    GET /calls returns {"audio": true, "calls": [{"call_id": "a84b4c76e66710",
+//    "call": {"disposition": "answered", ...}, "streams": [{"id": 41, "stream":
+//    {"codec": "PCMA/8000", "mos": "4.39", ...}}, ...]}]}
+//
+// 2. Listening to the callee:
+//    GET /audio?session=...&stream=41&buffer=40 returns the WAV file of stream 41
+//    as the callee's phone would have played it with a 40 ms jitter buffer
+//
+// Streams decoded from a live capture have no audio, the packets are not kept
+
+package chatgpt_api
+
+import (
+	decode "DeepPacketAI/internal/analyzer"          // Audio extraction from capture files
+	decode_rtp "DeepPacketAI/internal/protocols/rtp" // Default jitter buffer
+	database "DeepPacketAI/internal/storage"         // Decoded message types
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// maxJitterBuffer bounds the buffer parameter of /audio
+const maxJitterBuffer = time.Second
+
+// callSummary is one call of the /calls response
+type callSummary struct {
+	CallID  string            `json:"call_id"`        // Empty for streams without signaling
+	Call    map[string]string `json:"call,omitempty"` // "sip_call" record, absent when not captured
+	Streams []streamSummary   `json:"streams"`
+}
+
+// streamSummary is one RTP stream of a call
+type streamSummary struct {
+	ID     int               `json:"id"`     // Position of the record in the session, for /audio
+	Stream map[string]string `json:"stream"` // "rtp_stream" record
+}
+
+// summariseCalls groups the stream records of a capture under their calls
+// Calls keep the order of their records, streams without a call come last
+func summariseCalls(messages []database.ProcessedMessage) []callSummary {
+	var calls []callSummary
+	byID := make(map[string]int)
+	group := func(callID string) int {
+		i, ok := byID[callID]
+		if !ok {
+			i = len(calls)
+			byID[callID] = i
+			calls = append(calls, callSummary{CallID: callID, Streams: []streamSummary{}})
+		}
+		return i
+	}
+
+	for _, m := range messages {
+		if m.Protocol == "sip_call" {
+			i := group(m.Message["Call-ID"])
+			if calls[i].Call == nil {
+				calls[i].Call = m.Message // The first record of a reused Call-ID
+			}
+		}
+	}
+	var unsignaled []streamSummary
+	for id, m := range messages {
+		if m.Protocol != "rtp_stream" {
+			continue
+		}
+		stream := streamSummary{ID: id, Stream: m.Message}
+		if callID := m.Message["Call-ID"]; callID != "" {
+			i := group(callID)
+			calls[i].Streams = append(calls[i].Streams, stream)
+		} else {
+			unsignaled = append(unsignaled, stream)
+		}
+	}
+	if len(unsignaled) > 0 {
+		calls = append(calls, callSummary{Streams: unsignaled})
+	}
+	return calls
+}
+
+// callsHandler returns the calls of the session with their streams
+// Response: {"audio": true when streams can be played, "calls": [...]}
+func callsHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
+	files, messages := s.Capture()
+	calls := summariseCalls(messages)
+	if calls == nil {
+		calls = []callSummary{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"audio": len(files) > 0, "calls": calls})
+}
+
+// audioHandler returns the audio of one RTP stream as a WAV file
+// Query parameters: stream, the id from /calls; buffer, the jitter buffer in
+// milliseconds, decode_rtp.DefaultJitterBuffer when absent
+func audioHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
+	files, messages := s.Capture()
+	if len(files) == 0 {
+		http.Error(w, "Audio needs uploaded capture files, live captures keep no packets", http.StatusConflict)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("stream"))
+	if err != nil || id < 0 || id >= len(messages) || messages[id].Protocol != "rtp_stream" {
+		http.Error(w, "Unknown stream, reload the call list", http.StatusNotFound)
+		return
+	}
+	depth := decode_rtp.DefaultJitterBuffer
+	if value := r.URL.Query().Get("buffer"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms <= 0 || time.Duration(ms)*time.Millisecond > maxJitterBuffer {
+			http.Error(w, "Invalid jitter buffer, 1 to 1000 ms", http.StatusBadRequest)
+			return
+		}
+		depth = time.Duration(ms) * time.Millisecond
+	}
+
+	var wav bytes.Buffer
+	record := messages[id]
+	playback, err := decode.ExtractAudio(files, record, depth, &wav)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	fmt.Printf("Session %s audio of %s -> %s: %d of %d packets played, %d late, %d underruns\n",
+		s.ID, record.Message["source"], record.Message["destination"],
+		playback.Played, playback.Packets, playback.Late, playback.Underruns)
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", audioFileName(record.Message)))
+	w.Header().Set("Content-Length", strconv.Itoa(wav.Len()))
+	wav.WriteTo(w)
+}
+
+// unsafeFileName matches the characters replaced in download file names
+var unsafeFileName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// audioFileName names the WAV file of a stream after its call and direction
+// Example: "a84b4c76e66710_10.0.0.1-4000_to_10.0.0.2-5000.wav"
+func audioFileName(stream map[string]string) string {
+	name := stream["source"] + "_to_" + stream["destination"]
+	if callID := stream["Call-ID"]; callID != "" {
+		name = callID + "_" + name
+	}
+	return unsafeFileName.ReplaceAllString(name, "-") + ".wav"
+}
@@ -14,283 +14,66 @@ import (
 	decode "DeepPacketAI/internal/analyzer"  // Protocol decoder functionality
 	database "DeepPacketAI/internal/storage" // Data persistence layer
 	"DeepPacketAI/pkg/config"                // Application configuration
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strings"
-
-	"DeepPacketAI/internal/ai-client/generativeai" // Custom Gemini client
-
-	"github.com/google/generative-ai-go/genai"
-	"github.com/ollama/ollama/api"
-	"github.com/sashabaranov/go-openai" // ChatGPT
-	"google.golang.org/api/option"
 )
 
-var messages []openai.ChatCompletionMessage
-var ollamaMessages []api.Message
-var client *openai.Client
-var ollamaClient *api.Client
-var geminiClient *generativeai.Client
-var ctx context.Context
-var gemini_client *genai.Client
-var cs *genai.ChatSession
-
 // AIProvider represents the selected AI provider and model
 type AIProvider struct {
 	LLM   string `json:"llm"`
 	Model string `json:"model"`
 }
 
-var currentAIProvider AIProvider
-
-// prettyString formats JSON data with indentation
-func prettyString(str string) (string, error) {
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, []byte(str), "", "    "); err != nil {
-		return "", err
-	}
-	return prettyJSON.String(), nil
-}
-
-// Chatgpt_ai_process initializes the AI analysis pipeline based on the selected provider
-func Chatgpt_ai_process() error {
-	fmt.Println("currentAIProvider.LLM:", currentAIProvider.LLM)
-
-	jsonData, err := json.Marshal(database.AI_Input)
-	if err != nil {
-		return fmt.Errorf("Error marshalling data: %v", err)
-	}
-
-	res, err := prettyString(string(jsonData))
-	if err != nil {
-		return fmt.Errorf("Error formatting JSON: %v", err)
-	}
-
-	prompt := fmt.Sprintf("For the below data:\n%s\nAnswer the queries asked below.", string(res))
-
-	switch currentAIProvider.LLM {
-	case "ChatGPT":
-		// Fetch ChatGPT API key from environment variable
-		chatGPTAPIKey := os.Getenv("CHATGPT_API_KEY")
-		if chatGPTAPIKey == "" {
-			return fmt.Errorf("CHATGPT_API_KEY environment variable is not set")
-		}
-		client = openai.NewClient(chatGPTAPIKey)
-	case "Ollama":
-		url, _ := url.Parse("http://localhost:11434")
-		ollamaClient = api.NewClient(url, http.DefaultClient) // Default Ollama API endpoint
-		fmt.Println("Initializing Ollama with model:", currentAIProvider.Model)
-	case "Gemini":
-		// Fetch Gemini API key from environment variable
-		// geminiAPIKey := os.Getenv("GEMINI_API_KEY")
-		// if geminiAPIKey == "" {
-		// 	fmt.Println("GEMINI_API_KEY environment variable is not set")
-		// 	return fmt.Errorf("GEMINI_API_KEY environment variable is not set")
-		// }
-		// geminiClient = generativeai.NewClient(geminiAPIKey)
-		// fmt.Println("Initializing Gemini with model:", currentAIProvider.Model)
-		// Setup Gemini AI client with authentication from env
-		// Initialize context for API request lifecycle
-		ctx = context.Background()
-		geminiAPIKey := os.Getenv("GEMINI_API_KEY")
-		fmt.Println("geminiAPIKey:", geminiAPIKey)
-		gemini_client, _ := genai.NewClient(ctx, option.WithAPIKey(geminiAPIKey))
-		// Configure AI model for network traffic analysis
-		// Model: gemini-1.5-flash optimized for pattern recognition
-		model := gemini_client.GenerativeModel("gemini-1.5-flash")
-		// Initialize the chat
-		cs = model.StartChat()
-		cs.History = []*genai.Content{
-			{
-				Parts: []genai.Part{
-					genai.Text(prompt),
-				},
-				Role: "user",
-			},
-			{
-				Parts: []genai.Part{
-					genai.Text("Great to meet you. What would you like to know?"),
-				},
-				Role: "model",
-			},
-		}
-
-	default:
-		log.Fatal("Unsupported LLM selected")
-	}
-
-	if currentAIProvider.LLM == "Gemini" {
-
-	} else if currentAIProvider.LLM == "Ollama" {
-		ollamaMessages = []api.Message{
-			api.Message{
-				Role:    "system",
-				Content: prompt,
-			},
-		}
-
-	} else {
-		messages = []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: prompt,
-			},
-		}
-
-		if len(config.Input.Prompt) != 0 {
-			message := openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleUser,
-				Content: config.Input.Prompt,
-			}
-			messages = append(messages, message)
-			fmt.Println("-> ", config.Input.Prompt)
-		}
-	}
-	return nil
-}
-
-// queryAI handles user queries and generates responses based on the selected AI provider
-func queryAI(prompt string) string {
-	text := strings.Replace(prompt, "\n", "", -1)
-	if text == "quit" {
-		return ""
-	}
-
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: text,
-	})
-
-	var response string
-	switch currentAIProvider.LLM {
-	case "ChatGPT":
-		resp, err := client.CreateChatCompletion(
-			context.Background(),
-			openai.ChatCompletionRequest{
-				Model:    currentAIProvider.Model,
-				Messages: messages,
-			},
-		)
-		if err != nil {
-			fmt.Printf("ChatCompletion error: %v\n", err)
-			return ""
-		}
-		response = resp.Choices[0].Message.Content
-	case "Ollama":
-	case "Gemini":
-		// resp, err := geminiClient.GenerateText(context.Background(), generativeai.GenerateTextRequest{
-		// 	Model:  currentAIProvider.Model,
-		// 	Prompt: text,
-		// })
-		// if err != nil {
-		// 	fmt.Printf("Gemini error: %v\n", err)
-		// 	return ""
-		// }
-		// response = resp.Text
-		p := prompt
-		// convert CRLF to LF
-		p = strings.Replace(p, "\n", "", -1)
-		// if p == "quit" {
-		// 	return
-		// }
-		// fmt.Println(prompt)
-
-	default:
-		response = "Unsupported LLM selected"
-	}
-
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleAssistant,
-		Content: response,
-	})
-
-	return response
-}
-
-func queryOllama(prompt string) string {
-	p := prompt
-	// convert CRLF to LF
-	p = strings.Replace(p, "\n", "", -1)
-	ollamaMessages = append(ollamaMessages, api.Message{
-		Role:    "user",
-		Content: p,
-	})
-
-	ctx := context.Background()
-	req := &api.ChatRequest{
-		Model:    currentAIProvider.Model,
-		Messages: ollamaMessages,
-	}
-
-	var response string
-
-	respFunc := func(resp api.ChatResponse) error {
-		// fmt.Print(resp.Message.Content)
-		response += resp.Message.Content
-		// fmt.Println("response=", response)
-		return nil
+// Analyze runs a single non-interactive analysis of the decoded packets
+// Used by the command line batch mode instead of the web chat
+// Parameters:
+//   - messages: Decoded messages of the captures
+//   - llm: AI provider (ChatGPT, Ollama or Gemini)
+//   - model: Model name, provider default when empty
+//   - prompt: Question asked about the capture
+//
+// Returns the AI answer or an error if any step fails
+func Analyze(messages []database.ProcessedMessage, llm, model, prompt string) (string, error) {
+	if len(messages) == 0 {
+		return "", fmt.Errorf("no supported messages found in the capture")
 	}
 
-	err := ollamaClient.Chat(ctx, req, respFunc)
+	s, err := newSession(false)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	// fmt.Println("response=", response)
-	return response
-}
+	defer s.Close()
 
-func queryGemini(prompt string) genai.Part {
-	p := prompt
-	// convert CRLF to LF
-	p = strings.Replace(p, "\n", "", -1)
-	// if p == "quit" {
-	// 	return
-	// }
-	// fmt.Println(prompt)
-	resp, err := cs.SendMessage(ctx, genai.Text(p))
-	if err != nil {
-		log.Fatal(err)
-	}
-	// // Process AI analysis results
-	var text genai.Part
-	if resp != nil {
-		// Extract analysis candidates from response
-		candidates := resp.Candidates
-
-		// Process each analysis perspective
-		// Example output: "High frequency of failed authentication attempts"
-		for _, candidate := range candidates {
-			// Similarly, you can access other fields of the Candidate
-			content := candidate.Content
-			if content != nil {
-				// Access field of the Content struct
-				// For example, if Content has Text field
-				text = content.Parts[0]
-				// Use the text variable
-				// log.Println("Gemini AI Response:", text)
-			}
-		}
+	s.selection = AIProvider{LLM: llm, Model: model}
+	if err := s.Load(config.Input.Files, messages); err != nil {
+		return "", err
 	}
-	return text
+	return s.Ask(context.Background(), prompt)
 }
 
 // HandleWebPage initializes the web interface and routes
 func HandleWebPage() {
 	http.HandleFunc("/", handler)
+	http.HandleFunc("/session", sessionHandler)
 	http.HandleFunc("/chat", chatHandler)
+	http.HandleFunc("/chat/stream", chatStreamHandler)
 	http.HandleFunc("/upload", uploadHandler)
 	http.HandleFunc("/upload-directory", uploadDirectoryHandler)
 	http.HandleFunc("/analyze", analyzeHandler)
+	http.HandleFunc("/live", liveHandler)
+	http.HandleFunc("/calls", callsHandler)
+	http.HandleFunc("/audio", audioHandler)
+
+	// Remove sessions of analysts that went away
+	go expireSessions()
 
 	port := "8080"
 	url := "http://localhost:" + port
@@ -301,6 +84,11 @@ func HandleWebPage() {
 
 // analyzeHandler handles the selection of LLM and Model
 func analyzeHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
 	var reqData struct {
 		LLM   string `json:"llm"`
 		Model string `json:"model"`
@@ -312,8 +100,11 @@ func analyzeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentAIProvider = AIProvider{LLM: reqData.LLM, Model: reqData.Model}
-	fmt.Printf("Selected LLM: %s, Model: %s\n", reqData.LLM, reqData.Model)
+	if err := s.SelectProvider(reqData.LLM, reqData.Model); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Session %s selected LLM: %s, Model: %s\n", s.ID, reqData.LLM, reqData.Model)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
@@ -326,6 +117,11 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
 	var reqData struct {
 		Query string `json:"query"`
 	}
@@ -336,17 +132,121 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if currentAIProvider.LLM == "Gemini" {
-		response := queryGemini(reqData.Query)
-		json.NewEncoder(w).Encode(map[string]genai.Part{"response": response})
-	} else if currentAIProvider.LLM == "Ollama" {
-		response := queryOllama(reqData.Query)
-		json.NewEncoder(w).Encode(map[string]string{"response": response})
+	response, err := s.Ask(r.Context(), reqData.Query)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"response": response})
+}
+
+// chatStreamHandler answers a chat query as Server-Sent Events
+// Request: POST {"query": "..."}
+// Events:
+//   - token: {"token": "..."} for every chunk of the answer
+//   - done: {"response": "..."} with the complete answer
+//   - error: {"error": "..."} if the AI query fails
+func chatStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
+	var reqData struct {
+		Query string `json:"query"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&reqData)
+	if err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	response, err := s.AskStream(r.Context(), reqData.Query, func(token string) error {
+		if err := writeEvent(w, "token", map[string]string{"token": token}); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		writeEvent(w, "error", map[string]string{"error": err.Error()})
 	} else {
-		response := queryAI(reqData.Query)
-		json.NewEncoder(w).Encode(map[string]string{"response": response})
+		writeEvent(w, "done", map[string]string{"response": response})
 	}
+	flusher.Flush()
+}
 
+// writeEvent writes one Server-Sent Event with a JSON payload
+// JSON keeps newlines of the answer inside a single data line
+func writeEvent(w io.Writer, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// liveWindow holds the messages of a running live capture, nil without one
+var liveWindow *database.Window
+
+// SetLiveWindow makes a live capture available to web sessions
+// Must be called before HandleWebPage
+func SetLiveWindow(w *database.Window) {
+	liveWindow = w
+}
+
+// liveHandler loads the current live capture window into the session
+// Each call takes a fresh snapshot of the window
+// Response: {"messages": N}
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
+	if liveWindow == nil {
+		http.Error(w, "No live capture running, start the server with -live", http.StatusNotFound)
+		return
+	}
+
+	messages := liveWindow.Messages()
+	if len(messages) == 0 {
+		http.Error(w, "No messages decoded from the live capture yet", http.StatusConflict)
+		return
+	}
+
+	if err := s.Load(nil, messages); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]int{"messages": len(messages)})
 }
 
 // uploadHandler processes file uploads
@@ -356,6 +256,11 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
 	r.ParseMultipartForm(10 << 20) // 10MB max memory
 
 	file, handler, err := r.FormFile("file")
@@ -366,34 +271,25 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	savePath := filepath.Join(os.TempDir(), handler.Filename)
-	dst, err := os.Create(savePath)
+	// Each session uploads into its own directory
+	uploadDir, err := s.UploadDir()
 	if err != nil {
-		http.Error(w, "Error creating temporary file", http.StatusInternalServerError)
-		fmt.Println("Error creating temporary file")
+		http.Error(w, "Error creating upload directory", http.StatusInternalServerError)
+		fmt.Println("Error creating upload directory:", err)
 		return
 	}
-	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
+	savePath := filepath.Join(uploadDir, filepath.Base(handler.Filename))
+	if err := saveUpload(savePath, file); err != nil {
 		http.Error(w, "Error saving uploaded file", http.StatusInternalServerError)
-		fmt.Println("Error saving uploaded file")
+		fmt.Println("Error saving uploaded file:", err)
+		s.DiscardUpload(uploadDir)
 		return
 	}
 
 	fmt.Println("File saved at:", savePath)
-	config.Input.Files = []string{savePath}
-	decode.Process()
-
-	if len(database.AI_Input) == 0 {
-		http.Error(w, "Error reading file - No HTTP and SIP messages", http.StatusInternalServerError)
-		fmt.Println("Error reading file - No HTTP and SIP messages")
-		return
-	}
-
-	if err := Chatgpt_ai_process(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		fmt.Println("Error processing file")
+	if !loadCaptures(w, r, s, []string{savePath}) {
+		s.DiscardUpload(uploadDir)
 		return
 	}
 
@@ -403,50 +299,48 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 
 // uploadDirectoryHandler processes directory uploads
 func uploadDirectoryHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
 	err := r.ParseMultipartForm(32 << 20) // 32MB max memory
 	if err != nil {
 		http.Error(w, "Unable to parse form", http.StatusBadRequest)
 		return
 	}
 
-	files := r.MultipartForm.File["files"]
-	uploadDir := "./uploads"
-	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+	// Each session uploads into its own directory
+	uploadDir, err := s.UploadDir()
+	if err != nil {
 		http.Error(w, "Unable to create upload directory", http.StatusInternalServerError)
 		return
 	}
 
-	for _, fileHeader := range files {
+	for _, fileHeader := range r.MultipartForm.File["files"] {
 		file, err := fileHeader.Open()
 		if err != nil {
 			http.Error(w, "Unable to open file", http.StatusInternalServerError)
+			s.DiscardUpload(uploadDir)
 			return
 		}
-		defer file.Close()
-
-		dst, err := os.Create(filepath.Join(uploadDir, fileHeader.Filename))
+		err = saveUpload(filepath.Join(uploadDir, filepath.Base(fileHeader.Filename)), file)
+		file.Close()
 		if err != nil {
-			http.Error(w, "Unable to create file", http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
-
-		if _, err := io.Copy(dst, file); err != nil {
 			http.Error(w, "Unable to save file", http.StatusInternalServerError)
+			s.DiscardUpload(uploadDir)
 			return
 		}
 	}
 
-	config.SaveDirectoryFiles(&uploadDir)
-	decode.Process()
-
-	if len(database.AI_Input) == 0 {
-		http.Error(w, "Error reading file - No HTTP and SIP messages", http.StatusInternalServerError)
+	files, err := config.ListPcapFiles(uploadDir)
+	if err != nil {
+		http.Error(w, "Unable to read upload directory", http.StatusInternalServerError)
+		s.DiscardUpload(uploadDir)
 		return
 	}
-
-	if err := Chatgpt_ai_process(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !loadCaptures(w, r, s, files) {
+		s.DiscardUpload(uploadDir)
 		return
 	}
 
@@ -454,6 +348,48 @@ func uploadDirectoryHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Directory uploaded and processed successfully"))
 }
 
+// saveUpload copies an uploaded file to path
+func saveUpload(path string, src io.Reader) error {
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// loadCaptures decodes the capture set and starts the session's AI conversation
+// Writes an error response and returns false on failure
+func loadCaptures(w http.ResponseWriter, r *http.Request, s *Session, files []string) bool {
+	messages, err := decode.Process(files)
+	if errors.Is(err, decode.ErrLiveCapture) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		fmt.Println(err)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		fmt.Println(err)
+		return false
+	}
+
+	if len(messages) == 0 {
+		http.Error(w, "Error reading file - No HTTP and SIP messages", http.StatusInternalServerError)
+		fmt.Println("Error reading file - No HTTP and SIP messages")
+		return false
+	}
+
+	if err := s.Load(files, messages); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		fmt.Println("Error processing file:", err)
+		return false
+	}
+	return true
+}
+
 // openBrowser launches the default web browser
 func openBrowser(url string) {
 	var cmd string
@@ -697,6 +633,33 @@ func handler(w http.ResponseWriter, r *http.Request) {
         .model-selection select:hover {
             background-color: #EFF4FB;
         }
+
+        #callsPanel {
+            width: 80%;
+            max-width: 1000px;
+            background: #ffffff;
+            border-radius: 14px;
+            box-shadow: 14px 17px 40px 4px rgba(112, 144, 176, 0.08);
+            padding: 20px;
+        }
+
+        #callsPanel table {
+            width: 100%;
+            border-collapse: collapse;
+            margin-bottom: 15px;
+            font-size: 14px;
+        }
+
+        #callsPanel th, #callsPanel td {
+            text-align: left;
+            padding: 6px;
+            border-bottom: 1px solid #E2E8F0;
+        }
+
+        #callsPanel audio {
+            height: 32px;
+            vertical-align: middle;
+        }
     </style>
 </head>
 
@@ -743,6 +706,24 @@ func handler(w http.ResponseWriter, r *http.Request) {
         <label id="directoryLabel" for="directoryInput">Choose Directory</label>
         <button type="button" onclick="uploadDirectory()">Upload Directory</button>
     </form>
+    <form id="liveForm">
+        <button type="button" onclick="analyzeLive()">Analyze Live Capture</button>
+    </form>
+
+    <!-- Call Summary with the audio of each direction -->
+    <div id="callsPanel" hidden>
+        <h3>Calls</h3>
+        <label>Jitter buffer
+            <select id="jitterBuffer" onchange="loadCalls()">
+                <option value="20">20 ms</option>
+                <option value="40">40 ms</option>
+                <option value="60" selected>60 ms</option>
+                <option value="100">100 ms</option>
+                <option value="200">200 ms</option>
+            </select>
+        </label>
+        <div id="callList"></div>
+    </div>
 
     <!-- Chat Container -->
     <div id="chatContainer">
@@ -754,6 +735,20 @@ func handler(w http.ResponseWriter, r *http.Request) {
     </div>
 
     <script>
+        // Session Logic
+        // Every tab gets its own analysis session from the server
+        const sessionReady = fetch("/session", { method: "POST" })
+            .then(response => response.json())
+            .then(data => data.session_id);
+
+        // sessionFetch sends a request tagged with this tab's session ID
+        function sessionFetch(url, options) {
+            return sessionReady.then(id => {
+                options.headers = Object.assign({}, options.headers, { "X-Session-ID": id });
+                return fetch(url, options);
+            });
+        }
+
         // Model Selection Logic
         function filterOptions() {
             const category = document.getElementById('category').value;
@@ -780,7 +775,7 @@ func handler(w http.ResponseWriter, r *http.Request) {
                 return;
             }
 
-            fetch("/analyze", {
+            sessionFetch("/analyze", {
                 method: "POST",
                 headers: { "Content-Type": "application/json" },
                 body: JSON.stringify({ llm: category, model: model })
@@ -819,13 +814,14 @@ func handler(w http.ResponseWriter, r *http.Request) {
                 formData.append("files", file);
             }
 
-            fetch("/upload-directory", {
+            sessionFetch("/upload-directory", {
                 method: "POST",
                 body: formData
             })
                 .then(response => {
                     if (response.ok) {
                         alert("Directory uploaded successfully!");
+                        loadCalls();
                     } else {
                         alert("Error uploading directory.");
                     }
@@ -848,7 +844,7 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
             alert(formData.get("file").name);
 
-            fetch("/upload", {
+            sessionFetch("/upload", {
                 method: "POST",
                 body: formData
             })
@@ -856,6 +852,7 @@ func handler(w http.ResponseWriter, r *http.Request) {
                     alert(response.statusText);
                     if (response.ok) {
                         alert("File uploaded successfully!");
+                        loadCalls();
 
                         // Keep the filename visible
                         let fileName = fileInput.files[0].name;
@@ -871,6 +868,21 @@ func handler(w http.ResponseWriter, r *http.Request) {
                 });
         }
 
+        // Live Capture Logic
+        // Loads a snapshot of the server's rolling capture window
+        function analyzeLive() {
+            sessionFetch("/live", { method: "POST" })
+                .then(response => response.ok
+                    ? response.json().then(data => {
+                        alert("Loaded " + data.messages + " live messages.");
+                        loadCalls();
+                    })
+                    : response.text().then(text => alert("Error: " + text)))
+                .catch(error => {
+                    alert("Error: " + error);
+                });
+        }
+
         function showFileName() {
             let fileInput = document.getElementById("fileInput");
             let label = document.getElementById("fileLabel");
@@ -879,6 +891,64 @@ func handler(w http.ResponseWriter, r *http.Request) {
             }
         }
 
+        // Call Summary Logic
+        // Lists the calls of the session with one row and one audio player per direction
+        function loadCalls() {
+            Promise.all([sessionReady, sessionFetch("/calls", { method: "GET" }).then(response => response.json())])
+                .then(([id, data]) => {
+                    const panel = document.getElementById("callsPanel");
+                    const list = document.getElementById("callList");
+                    const buffer = document.getElementById("jitterBuffer").value;
+                    list.innerHTML = "";
+                    panel.hidden = data.calls.length === 0;
+
+                    for (const call of data.calls) {
+                        const title = document.createElement("p");
+                        const c = call.call || {};
+                        title.textContent = call.call_id
+                            ? [call.call_id, (c.from || "") + " -> " + (c.to || ""), c.disposition, c.duration_s ? c.duration_s + " s" : ""]
+                                .filter(Boolean).join(" | ")
+                            : "Media without signaling";
+                        list.appendChild(title);
+
+                        const table = document.createElement("table");
+                        const head = table.insertRow();
+                        for (const name of ["Direction", "Codec", "Packets", "Lost", "Jitter (ms)", "MOS", "Audio"]) {
+                            const th = document.createElement("th");
+                            th.textContent = name;
+                            head.appendChild(th);
+                        }
+                        for (const s of call.streams) {
+                            const st = s.stream;
+                            const row = table.insertRow();
+                            const cells = [st.source + " -> " + st.destination, st.codec, st.packets,
+                                st.lost + " (" + st.loss_percent + "%)", st.jitter_ms || "", st.mos || ""];
+                            for (const text of cells) {
+                                row.insertCell().textContent = text;
+                            }
+                            const audio = row.insertCell();
+                            if (!data.audio) {
+                                audio.textContent = "Not kept for live captures";
+                                continue;
+                            }
+                            const url = "/audio?session=" + id + "&stream=" + s.id + "&buffer=" + buffer;
+                            const player = document.createElement("audio");
+                            player.controls = true;
+                            player.preload = "none";
+                            player.src = url;
+                            const link = document.createElement("a");
+                            link.href = url;
+                            link.textContent = "WAV";
+                            audio.append(player, " ", link);
+                        }
+                        list.appendChild(table);
+                    }
+                })
+                .catch(error => {
+                    console.error("Error loading calls:", error);
+                });
+        }
+
         // Chat Logic
         function sendMessage() {
             const inputField = document.getElementById("userInput");
@@ -894,22 +964,71 @@ func handler(w http.ResponseWriter, r *http.Request) {
             inputField.value = "";
             autoExpand(inputField);
 
+            // Display bot response as it streams in
+            let botDiv = document.createElement("div");
+            botDiv.className = "message bot";
+            botDiv.textContent = "...";
+            chatbox.appendChild(botDiv);
+            chatbox.scrollTop = chatbox.scrollHeight;
+
             // Send message to backend
-            fetch("/chat", {
+            let answer = "";
+            sessionFetch("/chat/stream", {
                 method: "POST",
                 headers: { "Content-Type": "application/json" },
                 body: JSON.stringify({ query: userMessage })
             })
-                .then(response => response.json())
-                .then(data => {
-                    // Display bot response
-                    let botDiv = document.createElement("div");
-                    botDiv.className = "message bot";
-                    botDiv.textContent = data.response;
-                    chatbox.appendChild(botDiv);
-                    chatbox.scrollTop = chatbox.scrollHeight;
+                .then(response => {
+                    if (!response.ok) {
+                        return response.text().then(text => { botDiv.textContent = "Error: " + text; });
+                    }
+                    return readEvents(response, (event, data) => {
+                        if (event === "token") {
+                            answer += data.token;
+                            botDiv.textContent = answer;
+                        } else if (event === "done" && !answer) {
+                            botDiv.textContent = data.response;
+                        } else if (event === "error") {
+                            botDiv.textContent = (answer ? answer + "\n\n" : "") + "Error: " + data.error;
+                        }
+                        chatbox.scrollTop = chatbox.scrollHeight;
+                    });
                 })
-                .catch(err => console.error("Error:", err));
+                .catch(err => {
+                    botDiv.textContent = "Error: " + err;
+                    console.error("Error:", err);
+                });
+        }
+
+        // readEvents parses a Server-Sent Events response body
+        // onEvent receives the event name and its decoded JSON data
+        function readEvents(response, onEvent) {
+            const reader = response.body.getReader();
+            const decoder = new TextDecoder();
+            let buffer = "";
+
+            function pump() {
+                return reader.read().then(({ done, value }) => {
+                    if (done) return;
+                    buffer += decoder.decode(value, { stream: true });
+
+                    // Events are separated by a blank line
+                    let end;
+                    while ((end = buffer.indexOf("\n\n")) >= 0) {
+                        const block = buffer.slice(0, end);
+                        buffer = buffer.slice(end + 2);
+
+                        let event = "message", data = "";
+                        for (const line of block.split("\n")) {
+                            if (line.startsWith("event: ")) event = line.slice(7);
+                            else if (line.startsWith("data: ")) data += line.slice(6);
+                        }
+                        if (data) onEvent(event, JSON.parse(data));
+                    }
+                    return pump();
+                });
+            }
+            return pump();
         }
 
         function autoExpand(textarea) {
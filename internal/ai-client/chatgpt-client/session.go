@@ -0,0 +1,536 @@
+// session.go
+// This file manages analysis sessions so several analysts can share one DeepPacketAI server.
+// Each session owns:
+// - The capture set uploaded from one browser tab or API client
+// - The messages decoded from those captures
+// - The selected AI provider and its chat history
+//
+// Example scenarios:
+// 1. Two browser tabs:
+//    Each tab obtains its own ID from POST /session and sends it as the
+//    X-Session-ID header, so uploads and chats never overwrite each other
+//
+// 2. Idle cleanup:
+//    Sessions unused for sessionIdleTimeout are closed and their uploads removed
+
+package chatgpt_api
+
+import (
+	"DeepPacketAI/internal/ai-client/contextbuilder" // Token-budgeted capture context
+	"DeepPacketAI/internal/ai-client/provider"       // Pluggable AI backends
+	"DeepPacketAI/internal/ai-client/retrieval"      // Question-relevant message search
+	"DeepPacketAI/internal/ai-client/tools"          // Capture queries callable by the AI
+	database "DeepPacketAI/internal/storage"         // Decoded message types
+	"DeepPacketAI/pkg/config"                        // Application configuration
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sessionIdleTimeout is how long an unused session is kept
+const sessionIdleTimeout = 2 * time.Hour
+
+// maxToolRounds is the number of tool call rounds per question
+const maxToolRounds = 6
+
+// maxRetrieved is the number of messages matched per question
+// Messages of the same SIP dialog are added on top
+const maxRetrieved = 200
+
+// Session holds the state of one analysis
+// All methods are safe for concurrent use
+type Session struct {
+	ID string // Random identifier sent by the client
+
+	mu        sync.Mutex
+	dir       string                      // Parent of the upload directories, empty for batch sessions
+	files     []string                    // Capture set
+	messages  []database.ProcessedMessage // Decoded messages of the capture set
+	selection AIProvider                  // Selected LLM and model
+	ai        *analysis                   // Conversation about the capture set, nil before the first one
+	lastUsed  atomic.Int64                // Unix nanoseconds, for idle expiry
+}
+
+// analysis is the conversation about one capture set with one provider
+// Loading a capture or selecting a provider replaces it as a whole, so a
+// running query keeps using the one it started with
+type analysis struct {
+	session  string                      // Session ID, for logging
+	provider provider.Provider           // Initialised backend
+	history  []provider.Message          // Conversation sent on every turn, guarded by Session.mu
+	messages []database.ProcessedMessage // Decoded messages the conversation is about
+	budget   contextbuilder.Budget       // Token budget of the selected model
+	index    *retrieval.Index            // Message search, nil when the capture fits the context
+	toolbox  *tools.Toolbox              // Capture queries for providers with function calling
+	useTools atomic.Bool                 // Cleared when the model rejects tool definitions
+}
+
+// newSession creates a session with a random ID
+// Parameters:
+//   - withDir: create an upload directory for web sessions
+func newSession(withDir bool) (*Session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("error generating session ID: %v", err)
+	}
+	s := &Session{ID: hex.EncodeToString(id)}
+	s.touch()
+	if withDir {
+		s.dir = filepath.Join(os.TempDir(), "deeppacketai", s.ID)
+	}
+	return s, nil
+}
+
+// SelectProvider sets the LLM and model used by the session
+// Restarts the conversation when a capture is already loaded; if the
+// provider cannot be started the previous selection is kept
+func (s *Session) SelectProvider(llm, model string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+
+	selection := AIProvider{LLM: llm, Model: model}
+	if len(s.messages) == 0 {
+		s.selection = selection
+		return nil
+	}
+	a, err := s.startAI(selection, s.messages)
+	if err != nil {
+		return err
+	}
+	s.selection = selection
+	s.replace(a)
+	return nil
+}
+
+// Load replaces the capture set and starts a new conversation about it
+// The session is left unchanged when the provider cannot be started
+// Parameters:
+//   - files: Capture files the messages were decoded from
+//   - messages: Decoded messages
+func (s *Session) Load(files []string, messages []database.ProcessedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+
+	a, err := s.startAI(s.selection, messages)
+	if err != nil {
+		return err
+	}
+
+	// The uploads of the replaced capture are no longer needed
+	if previous := s.uploadOf(s.files); previous != "" && previous != s.uploadOf(files) {
+		os.RemoveAll(previous)
+	}
+	s.files = files
+	s.messages = messages
+	s.replace(a)
+	return nil
+}
+
+// replace makes a the session's conversation and closes the previous provider
+// Must be called with s.mu held
+func (s *Session) replace(a *analysis) {
+	if s.ai != nil {
+		s.ai.provider.Close()
+	}
+	s.ai = a
+}
+
+// Ask sends a user query to the session's AI provider
+// The query and the answer are appended to the conversation history
+// For captures larger than the context window the messages relevant to
+// the query are attached to it; they are not kept in the history
+func (s *Session) Ask(ctx context.Context, prompt string) (string, error) {
+	return s.ask(ctx, prompt, nil)
+}
+
+// AskStream is Ask with the answer forwarded to onToken as it is generated
+// An error returned by onToken (e.g. client gone) aborts the query
+func (s *Session) AskStream(ctx context.Context, prompt string, onToken func(string) error) (string, error) {
+	return s.ask(ctx, prompt, onToken)
+}
+
+// ask sends a user query, streaming the answer when onToken is set
+// s.mu is only held to read and extend the history, so uploads, calls and
+// audio of the session stay available while the AI answers
+func (s *Session) ask(ctx context.Context, prompt string, onToken func(string) error) (string, error) {
+	s.mu.Lock()
+	s.touch()
+	a := s.ai
+	var history []provider.Message
+	if a != nil {
+		history = slices.Clip(a.history)
+	}
+	s.mu.Unlock()
+
+	if a == nil {
+		return "", fmt.Errorf("no capture has been analysed yet")
+	}
+
+	content := prompt
+	if a.index != nil {
+		content = a.relevantMessages(ctx, history, prompt)
+	}
+
+	turn := append(history, provider.Message{Role: provider.RoleUser, Content: content})
+	response, err := a.chat(ctx, turn, onToken)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+
+	// A capture or provider loaded in the meantime started a new conversation
+	if s.ai == a {
+		a.history = append(a.history,
+			provider.Message{Role: provider.RoleUser, Content: prompt},
+			provider.Message{Role: provider.RoleAssistant, Content: response},
+		)
+	}
+	return response, nil
+}
+
+// chat sends a conversation ending with a user turn and returns the answer
+// Tool calls requested by the model are run against the loaded capture and
+// their results sent back until the model answers; they are not kept in the history
+// With onToken set every reply is streamed, including any text the model
+// writes before calling a tool
+func (a *analysis) chat(ctx context.Context, turn []provider.Message, onToken func(string) error) (string, error) {
+	caller, ok := a.provider.(provider.ToolCaller)
+	if !ok || !a.useTools.Load() {
+		return a.send(ctx, turn, onToken)
+	}
+
+	for round := 0; round < maxToolRounds; round++ {
+		// Last round: ask for an answer from what the tools returned so far
+		if round == maxToolRounds-1 {
+			turn = append(turn, provider.Message{Role: provider.RoleUser, Content: "Answer the question now using the tool results above."})
+		}
+
+		var reply provider.Message
+		var err error
+		if onToken != nil {
+			reply, err = caller.StreamTools(ctx, turn, tools.Definitions, onToken)
+		} else {
+			reply, err = caller.ChatTools(ctx, turn, tools.Definitions)
+		}
+		if round == 0 && errors.Is(err, provider.ErrToolsUnsupported) {
+			// Models without function calling reject the tool definitions
+			log.Println("Session", a.session, "tools unavailable, continuing without:", err)
+			a.useTools.Store(false)
+			return a.send(ctx, turn, onToken)
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(reply.ToolCalls) == 0 {
+			return reply.Content, nil
+		}
+
+		turn = append(turn, reply)
+		for _, call := range reply.ToolCalls {
+			log.Println("Session", a.session, "tool call:", call.Name, call.Arguments)
+			turn = append(turn, provider.Message{
+				Role:       provider.RoleTool,
+				Content:    a.toolbox.Call(call),
+				ToolCallID: call.ID,
+			})
+		}
+	}
+	return "", fmt.Errorf("no answer after %d rounds of tool calls", maxToolRounds)
+}
+
+// send sends a conversation without tools
+func (a *analysis) send(ctx context.Context, turn []provider.Message, onToken func(string) error) (string, error) {
+	if onToken != nil {
+		return a.provider.Stream(ctx, turn, onToken)
+	}
+	return a.provider.Chat(ctx, turn)
+}
+
+// relevantMessages attaches the messages matching a query to it
+// Uses the tokens left over by the conversation so far
+func (a *analysis) relevantMessages(ctx context.Context, history []provider.Message, prompt string) string {
+	tokens := a.budget.Available() - a.budget.Tokens(prompt)
+	for _, m := range history {
+		tokens -= a.budget.Tokens(m.Content)
+	}
+	// Long conversations still get some messages, the provider trims old turns
+	if tokens < a.budget.Available()/4 {
+		tokens = a.budget.Available() / 4
+	}
+
+	ranked := a.index.Search(ctx, prompt, maxRetrieved)
+	if len(ranked) == 0 {
+		// Nothing matched, fall back to signaling first
+		ranked = contextbuilder.Prioritized(a.messages)
+	}
+	list, report := contextbuilder.Select(a.messages, ranked, a.budget, tokens)
+	log.Println("Session", a.session, "retrieved:", report)
+
+	return fmt.Sprintf("Decoded messages relevant to the question:\n%s\nQuestion: %s", list, prompt)
+}
+
+// UploadDir returns a new empty directory for the session's next capture set
+// The loaded capture keeps its own directory until Load replaces it, so its
+// calls can still be played while the next upload is saved and decoded
+func (s *Session) UploadDir() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(s.dir, "upload-")
+}
+
+// DiscardUpload removes an upload directory whose captures could not be loaded
+// The directory of the loaded capture is kept
+func (s *Session) DiscardUpload(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dir != s.uploadOf(s.files) {
+		os.RemoveAll(dir)
+	}
+}
+
+// uploadOf returns the upload directory holding a capture set, empty for
+// captures not uploaded to this session (batch files, live captures)
+// Must be called with s.mu held
+func (s *Session) uploadOf(files []string) string {
+	if s.dir == "" || len(files) == 0 || filepath.Dir(filepath.Dir(files[0])) != s.dir {
+		return ""
+	}
+	return filepath.Dir(files[0])
+}
+
+// Capture returns the capture files and decoded messages of the session
+// Files are nil for live captures, whose packets are not kept
+func (s *Session) Capture() ([]string, []database.ProcessedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+
+	return s.files, s.messages
+}
+
+// Close releases the provider and removes uploaded files
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ai != nil {
+		s.ai.provider.Close()
+		s.ai = nil
+	}
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// startAI initializes the AI analysis pipeline for a capture with a provider
+// Nothing of the session is changed, the caller swaps in the returned analysis
+// Must be called with s.mu held
+// Parameters:
+//   - selection: LLM and model to start
+//   - messages: Decoded messages of the capture set
+func (s *Session) startAI(selection AIProvider, messages []database.ProcessedMessage) (*analysis, error) {
+	log.Println("Session", s.ID, "LLM:", selection.LLM)
+
+	// Create the backend registered under the selected name
+	p, err := provider.New(selection.LLM)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fit the capture into the model's context window
+	a := &analysis{session: s.ID, provider: p, messages: messages}
+	a.budget = contextbuilder.ForModel(selection.LLM, selection.Model, config.Input.Window)
+	data, report := contextbuilder.Build(messages, a.budget)
+	log.Println("Session", s.ID, "context:", report)
+
+	// Each endpoint flag applies to its own provider only
+	url := ""
+	switch selection.LLM {
+	case "Ollama":
+		url = config.Input.Url
+	case "ChatGPT":
+		url = config.Input.OpenAIUrl
+	}
+
+	err = p.Init(context.Background(), provider.Config{
+		Model:  selection.Model,
+		URL:    url,
+		Window: a.budget.Window,
+	})
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	log.Println("Initializing", selection.LLM, "with model:", selection.Model)
+
+	// Captures larger than the window are searched per question instead
+	if report.Included < report.Messages {
+		data = contextbuilder.Summary(messages) +
+			"\nThe capture is too large to list every message. " +
+			"The decoded messages relevant to each question are attached to it.\n"
+		a.index = s.buildIndex(selection, messages)
+	}
+
+	// Providers with function calling can query the whole capture
+	a.toolbox = tools.New(messages)
+	_, useTools := p.(provider.ToolCaller)
+	a.useTools.Store(useTools)
+	if useTools {
+		data += "\nUse the provided tools to look up messages, flows and response codes of the full capture.\n"
+	}
+
+	// Capture data is sent once as the system turn
+	prompt := fmt.Sprintf("For the below data:\n%s\nAnswer the queries asked below.", data)
+	a.history = []provider.Message{
+		{Role: provider.RoleSystem, Content: prompt},
+	}
+	return a, nil
+}
+
+// buildIndex indexes messages for per-question retrieval
+// Embeddings are added when -embed-model is set and the server answers
+func (s *Session) buildIndex(selection AIProvider, messages []database.ProcessedMessage) *retrieval.Index {
+	index := retrieval.New(messages)
+	if config.Input.Embed == "" {
+		return index
+	}
+
+	// Reuse the -u endpoint when it points at Ollama
+	url := ""
+	if selection.LLM == "Ollama" {
+		url = config.Input.Url
+	}
+	embedder, err := retrieval.NewOllamaEmbedder(url, config.Input.Embed)
+	if err == nil {
+		err = index.Embed(context.Background(), embedder)
+	}
+	if err != nil {
+		log.Println("Session", s.ID, "embeddings unavailable, using keyword search:", err)
+	}
+	return index
+}
+
+// touch records activity for idle expiry
+func (s *Session) touch() {
+	s.lastUsed.Store(time.Now().UnixNano())
+}
+
+// idleSince returns when the session was last used
+// Does not take s.mu so expiry never waits for a running AI query
+func (s *Session) idleSince() time.Time {
+	return time.Unix(0, s.lastUsed.Load())
+}
+
+// sessionStore maps session IDs to sessions of the web interface
+type sessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// sessions holds every live web session
+var sessions = &sessionStore{sessions: make(map[string]*Session)}
+
+// create registers a new web session
+func (st *sessionStore) create() (*Session, error) {
+	s, err := newSession(true)
+	if err != nil {
+		return nil, err
+	}
+	st.mu.Lock()
+	st.sessions[s.ID] = s
+	st.mu.Unlock()
+	return s, nil
+}
+
+// get returns the session with the given ID
+func (st *sessionStore) get(id string) (*Session, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	s, ok := st.sessions[id]
+	return s, ok
+}
+
+// expire closes sessions idle for longer than maxIdle
+func (st *sessionStore) expire(maxIdle time.Duration) {
+	st.mu.Lock()
+	var idle []*Session
+	for id, s := range st.sessions {
+		if time.Since(s.idleSince()) > maxIdle {
+			idle = append(idle, s)
+			delete(st.sessions, id)
+		}
+	}
+	st.mu.Unlock()
+
+	for _, s := range idle {
+		log.Println("Session", s.ID, "expired")
+		s.Close()
+	}
+}
+
+// expireSessions periodically removes idle sessions
+func expireSessions() {
+	for range time.Tick(time.Minute) {
+		sessions.expire(sessionIdleTimeout)
+	}
+}
+
+// sessionHandler creates a session for a browser tab or API client
+// Response: {"session_id": "..."}
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s, err := sessions.create()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"session_id": s.ID})
+}
+
+// requestSession returns the session named by the X-Session-ID header
+// or the session query parameter, writing an error response if there is none
+func requestSession(w http.ResponseWriter, r *http.Request) (*Session, bool) {
+	id := r.Header.Get("X-Session-ID")
+	if id == "" {
+		id = r.URL.Query().Get("session")
+	}
+	if id == "" {
+		http.Error(w, "Missing session ID, create one with POST /session", http.StatusBadRequest)
+		return nil, false
+	}
+
+	s, ok := sessions.get(id)
+	if !ok {
+		http.Error(w, "Unknown or expired session, reload the page", http.StatusNotFound)
+		return nil, false
+	}
+	return s, true
+}
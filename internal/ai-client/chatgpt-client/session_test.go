@@ -0,0 +1,213 @@
+package chatgpt_api
+
+import (
+	"DeepPacketAI/internal/ai-client/provider"
+	"DeepPacketAI/internal/ai-client/tools"
+	database "DeepPacketAI/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeToolCaller fails every request with tools with err and answers plain chats
+type fakeToolCaller struct {
+	err   error // Returned by ChatTools and StreamTools
+	chats int   // Requests sent without tools
+}
+
+func (f *fakeToolCaller) Init(ctx context.Context, cfg provider.Config) error { return nil }
+func (f *fakeToolCaller) Close() error                                        { return nil }
+
+func (f *fakeToolCaller) Chat(ctx context.Context, history []provider.Message) (string, error) {
+	f.chats++
+	return "486 Busy Here", nil
+}
+
+func (f *fakeToolCaller) Stream(ctx context.Context, history []provider.Message, onToken func(string) error) (string, error) {
+	f.chats++
+	return "486 Busy Here", onToken("486 Busy Here")
+}
+
+func (f *fakeToolCaller) ChatTools(ctx context.Context, history []provider.Message, t []provider.Tool) (provider.Message, error) {
+	return provider.Message{}, f.err
+}
+
+func (f *fakeToolCaller) StreamTools(ctx context.Context, history []provider.Message, t []provider.Tool, onToken func(string) error) (provider.Message, error) {
+	return provider.Message{}, f.err
+}
+
+// fakeProvider answers every question with "486 Busy Here"
+// Init fails with initErr, Chat runs onChat before answering
+type fakeProvider struct {
+	initErr error
+	onChat  func()
+}
+
+func init() {
+	provider.Register("Test", func() provider.Provider { return &fakeProvider{} })
+	provider.Register("Unavailable", func() provider.Provider { return &fakeProvider{initErr: errors.New("missing API key")} })
+}
+
+func (f *fakeProvider) Init(ctx context.Context, cfg provider.Config) error { return f.initErr }
+func (f *fakeProvider) Close() error                                        { return nil }
+
+func (f *fakeProvider) Chat(ctx context.Context, history []provider.Message) (string, error) {
+	if f.onChat != nil {
+		f.onChat()
+	}
+	return "486 Busy Here", nil
+}
+
+func (f *fakeProvider) Stream(ctx context.Context, history []provider.Message, onToken func(string) error) (string, error) {
+	reply, _ := f.Chat(ctx, history)
+	return reply, onToken(reply)
+}
+
+// TestChatToolsFallback checks that only a refusal of the tools disables them
+func TestChatToolsFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantErr   bool
+		wantTools bool
+	}{
+		{"cancelled", context.Canceled, true, true},
+		{"timeout", context.DeadlineExceeded, true, true},
+		{"rate limited", errors.New("ChatCompletion error: error, status code: 429, message: Rate limit reached"), true, true},
+		{"tools unsupported", fmt.Errorf("Ollama chat error: %w: llama2 does not support tools", provider.ErrToolsUnsupported), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeToolCaller{err: tt.err}
+			a := &analysis{session: "test", provider: fake, toolbox: tools.New(nil)}
+			a.useTools.Store(true)
+			turn := []provider.Message{{Role: provider.RoleUser, Content: "Why did the call fail?"}}
+
+			reply, err := a.chat(context.Background(), turn, nil)
+			if tt.wantErr {
+				if !errors.Is(err, tt.err) || fake.chats != 0 {
+					t.Errorf("err = %v after %d chats without tools, want %v unchanged", err, fake.chats, tt.err)
+				}
+			} else if err != nil || reply != "486 Busy Here" {
+				t.Errorf("reply = %q, err = %v", reply, err)
+			}
+			if a.useTools.Load() != tt.wantTools {
+				t.Errorf("useTools = %v, want %v", a.useTools.Load(), tt.wantTools)
+			}
+		})
+	}
+}
+
+// TestUploadDir checks that the files of the loaded capture stay in place until
+// the next upload is loaded, and that a failed upload leaves them alone
+func TestUploadDir(t *testing.T) {
+	s := &Session{ID: "test", dir: filepath.Join(t.TempDir(), "test"), selection: AIProvider{LLM: "Test"}}
+	upload := func() string {
+		dir, err := s.UploadDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, "call.pcap")
+		if err := os.WriteFile(file, []byte("capture"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	exists := func(file string) bool {
+		_, err := os.Stat(file)
+		return err == nil
+	}
+
+	first := upload()
+	if err := s.Load([]string{first}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next upload is saved while the loaded capture can still be played
+	failed := upload()
+	if !exists(first) {
+		t.Fatalf("%s removed before the next upload was loaded", first)
+	}
+	s.DiscardUpload(filepath.Dir(failed))
+	if exists(failed) || !exists(first) {
+		t.Errorf("after a failed upload: %s exists %v, %s exists %v", failed, exists(failed), first, exists(first))
+	}
+
+	// Reloading the same capture keeps it, loading the next one removes it
+	if err := s.Load([]string{first}, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.DiscardUpload(filepath.Dir(first))
+	if !exists(first) {
+		t.Fatalf("%s of the loaded capture removed", first)
+	}
+	second := upload()
+	if err := s.Load([]string{second}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exists(first) || !exists(second) {
+		t.Errorf("after the next upload: %s exists %v, %s exists %v", first, exists(first), second, exists(second))
+	}
+}
+
+// TestStartFailure checks that a provider failing to start leaves the session as it was
+func TestStartFailure(t *testing.T) {
+	s := &Session{ID: "test", dir: filepath.Join(t.TempDir(), "test"), selection: AIProvider{LLM: "Test"}}
+	messages := []database.ProcessedMessage{{Protocol: "sip", Message: map[string]string{"Call-ID": "busy"}}}
+	if err := s.Load([]string{"call.pcap"}, messages); err != nil {
+		t.Fatal(err)
+	}
+	loaded := s.ai
+
+	if err := s.SelectProvider("Unavailable", ""); err == nil {
+		t.Error("SelectProvider of a failing provider succeeded")
+	}
+	if s.selection.LLM != "Test" || s.ai != loaded {
+		t.Errorf("after SelectProvider: selection %+v, conversation replaced %v", s.selection, s.ai != loaded)
+	}
+
+	s.selection = AIProvider{LLM: "Unavailable"}
+	if err := s.Load([]string{"next.pcap"}, nil); err == nil {
+		t.Error("Load with a failing provider succeeded")
+	}
+	files, got := s.Capture()
+	if len(files) != 1 || files[0] != "call.pcap" || len(got) != 1 || s.ai != loaded {
+		t.Errorf("after Load: files %v, %d messages, conversation replaced %v", files, len(got), s.ai != loaded)
+	}
+}
+
+// TestAskUnlocked checks that the session stays available while the AI answers
+// and that the answer is added to the conversation
+func TestAskUnlocked(t *testing.T) {
+	s := &Session{ID: "test", selection: AIProvider{LLM: "Test"}}
+	if err := s.Load(nil, []database.ProcessedMessage{{Protocol: "sip"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.ai.provider.(*fakeProvider).onChat = func() {
+		done := make(chan struct{})
+		go func() {
+			s.Capture()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Capture blocked while the AI answers")
+		}
+	}
+
+	if _, err := s.Ask(context.Background(), "Why did the call fail?"); err != nil {
+		t.Fatal(err)
+	}
+	var roles []string
+	for _, m := range s.ai.history {
+		roles = append(roles, m.Role)
+	}
+	if fmt.Sprint(roles) != "[system user assistant]" {
+		t.Errorf("history roles = %v", roles)
+	}
+}
@@ -0,0 +1,123 @@
+// budget.go
+// This file estimates how much capture data fits into a model's context window.
+// Core functionalities:
+// - Context window sizes of common models
+// - Rough token estimates from character counts
+//
+// Example scenarios:
+// 1. gpt-4o:
+//    128k token window, about 4 characters per token
+//
+// 2. Local llama3 through Ollama:
+//    8k token window, packet JSON tokenizes denser than prose
+
+package contextbuilder
+
+import "strings"
+
+// Budget describes the token space available for capture data
+type Budget struct {
+	Window        int     // Context window of the model in tokens
+	Reserve       int     // Tokens kept free for the conversation and the answer
+	CharsPerToken float64 // Average characters per token for the model's tokenizer
+}
+
+// Available returns the tokens left for capture data
+func (b Budget) Available() int {
+	return b.Window - b.Reserve
+}
+
+// Tokens estimates the token count of a text
+func (b Budget) Tokens(text string) int {
+	return int(float64(len(text))/b.CharsPerToken) + 1
+}
+
+// modelWindow maps model name prefixes to context windows in tokens
+// Longest matching prefix wins
+// Ollama entries are kept to what a workstation can serve, not the model maximum
+var modelWindow = map[string]int{
+	// OpenAI
+	"gpt-4o":        128000,
+	"gpt-4.1":       128000,
+	"gpt-4-turbo":   128000,
+	"gpt-4":         8192,
+	"gpt-3.5-turbo": 16385,
+	"o1":            128000,
+	"o3":            128000,
+	"o4":            128000,
+
+	// Gemini
+	"gemini-1.5": 1000000,
+	"gemini-2":   1000000,
+	"gemini-1.0": 32768,
+	"gemini-pro": 32768,
+
+	// Ollama
+	"llama3":   8192,
+	"llama3.1": 32768,
+	"llama3.2": 32768,
+	"llama3.3": 32768,
+	"llama2":   4096,
+	"mistral":  32768,
+	"mixtral":  32768,
+	"gemma":    8192,
+	"gemma2":   8192,
+	"gemma3":   32768,
+	"qwen2":    32768,
+	"qwen2.5":  32768,
+	"phi3":     4096,
+	"phi4":     16384,
+}
+
+// defaultWindow is used for the provider defaults and unknown models
+var defaultWindow = map[string]int{
+	"ChatGPT": 128000,  // gpt-4o
+	"Gemini":  1000000, // gemini-1.5-flash
+	"Ollama":  8192,    // llama3
+}
+
+// ForModel returns the budget for a provider and model
+// Parameters:
+//   - llm: Provider name (e.g. "ChatGPT", "Ollama", "Gemini")
+//   - model: Model name, provider default when empty
+//   - window: Context window override in tokens, ignored when 0
+func ForModel(llm, model string, window int) Budget {
+	b := Budget{CharsPerToken: 4}
+	// Llama-style tokenizers split JSON, hex and addresses into more tokens
+	if llm == "Ollama" {
+		b.CharsPerToken = 3
+	}
+
+	b.Window = window
+	if b.Window == 0 {
+		b.Window = lookupWindow(strings.ToLower(model))
+	}
+	if b.Window == 0 {
+		b.Window = defaultWindow[llm]
+	}
+	if b.Window == 0 {
+		b.Window = 8192
+	}
+
+	// Leave a quarter of the window for follow-up questions and answers
+	b.Reserve = b.Window / 4
+	if b.Reserve < 1024 {
+		b.Reserve = 1024
+	}
+	return b
+}
+
+// lookupWindow returns the window of the longest matching model prefix
+// Ollama tags (e.g. "llama3:8b") match on the name before the colon
+func lookupWindow(model string) int {
+	model, _, _ = strings.Cut(model, ":")
+	model = strings.TrimPrefix(model, "models/") // Gemini resource names
+
+	best, window := "", 0
+	for prefix, w := range modelWindow {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, window = prefix, w
+		}
+	}
+	return window
+}
@@ -0,0 +1,332 @@
+// builder.go
+// This file turns decoded messages into AI context that fits the model's window.
+// Core functionalities:
+// - Per-protocol and per-flow summary of the whole capture
+// - Shortening of large fields such as RTP payload hex
+// - Message list filled by priority until the token budget is used
+// - Report of what was left out
+//
+// Example scenarios:
+// 1. Small SIP capture:
+//    Summary plus every message, nothing dropped
+//
+// 2. Multi-megabyte VoIP capture:
+//    Signaling messages are listed, RTP packets are represented by their
+//    per-flow summary and the prompt states how many were left out
+
+// Package contextbuilder prepares capture data for the AI providers
+package contextbuilder
+
+import (
+	database "DeepPacketAI/internal/storage" // Decoded message types
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Field size limits in characters
+const (
+	maxFieldChars = 1024 // Any field, e.g. HTTP bodies or SIP message bodies
+	maxRawChars   = 64   // Hex dumps of payload bytes
+)
+
+// maxFlows is the number of flows listed in the summary
+const maxFlows = 50
+
+// minLineTokens is below the size of any encoded message
+// (protocol, addresses, frame and timestamp alone take more)
+const minLineTokens = 20
+
+// rawFields hold hex dumps with little meaning to the model
+var rawFields = map[string]bool{
+	"Payload":         true,
+	"Contents":        true,
+	"ExtensionHeader": true,
+}
+
+// flowKeys are message fields whose distinct values are listed per flow
+// Example: the SSRCs and codecs of RTP streams, the 5G NF types and services of an HTTP flow
+var flowKeys = map[string][]string{
+	"rtp_stream": {"ssrc", "codec"},
+	"http":       {"consumer_nf", "producer_nf", "sbi_service"},
+}
+
+// protocolPriority orders protocols when the message list must be cut
+// Lower values are listed first, unlisted protocols have priority 0
+// SIP call and RTP stream records summarize whole dialogs and streams and
+// come first, RTCP reports repeat what the stream records measure
+var protocolPriority = map[string]int{
+	"sip_call":   -1,
+	"rtp_stream": -1,
+	"rtcp":       1,
+}
+
+// routineFrames are HTTP/2 connection housekeeping frames
+// They are listed after transactions, resets and GOAWAYs, with RTCP
+var routineFrames = map[string]bool{
+	"SETTINGS":      true,
+	"WINDOW_UPDATE": true,
+	"PING":          true,
+	"PRIORITY":      true,
+}
+
+// Report describes what Build put into the context
+type Report struct {
+	Messages  int            // Decoded messages
+	Included  int            // Messages listed individually
+	Dropped   map[string]int // Messages left out per protocol
+	Truncated int            // Fields shortened
+	Tokens    int            // Estimated tokens of the context
+	Budget    int            // Tokens available for the context
+}
+
+// String formats the report for logs
+// Example: "312/48211 messages, 9120/6144 tokens, dropped rtp:47899, 47950 fields truncated"
+func (r Report) String() string {
+	text := fmt.Sprintf("%d/%d messages, %d/%d tokens", r.Included, r.Messages, r.Tokens, r.Budget)
+	if dropped := r.droppedList(); dropped != "" {
+		text += ", dropped " + dropped
+	}
+	if r.Truncated > 0 {
+		text += fmt.Sprintf(", %d fields truncated", r.Truncated)
+	}
+	return text
+}
+
+// droppedList formats the dropped counts as "proto:count" pairs
+func (r Report) droppedList() string {
+	protocols := make([]string, 0, len(r.Dropped))
+	for protocol := range r.Dropped {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	var parts []string
+	for _, protocol := range protocols {
+		parts = append(parts, fmt.Sprintf("%s:%d", protocol, r.Dropped[protocol]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Build creates the capture context for a model
+// Parameters:
+//   - messages: Decoded messages in frame order
+//   - budget: Token budget of the target model
+//
+// Returns the context text and a report of what it contains
+func Build(messages []database.ProcessedMessage, budget Budget) (string, Report) {
+	summary := Summary(messages)
+
+	list, report := Select(messages, Prioritized(messages), budget, budget.Available()-budget.Tokens(summary))
+	report.Budget = budget.Available()
+	report.Tokens += budget.Tokens(summary)
+	return summary + "\n" + list, report
+}
+
+// Prioritized returns message indexes with signaling first and media last
+// Frame order is kept within a protocol class
+func Prioritized(messages []database.ProcessedMessage) []int {
+	ranked := make([]int, len(messages))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return priority(messages[ranked[i]]) < priority(messages[ranked[j]])
+	})
+	return ranked
+}
+
+// priority returns the listing class of a message, lower is listed first
+func priority(m database.ProcessedMessage) int {
+	if m.Protocol == "http" && routineFrames[m.Message["frame_type"]] {
+		return 1
+	}
+	return protocolPriority[m.Protocol]
+}
+
+// Select lists messages in order of preference until the tokens are used
+// Parameters:
+//   - messages: Decoded messages in frame order
+//   - ranked: Indexes into messages, most wanted first
+//   - budget: Token estimate of the target model
+//   - tokens: Tokens available for the list
+//
+// Returns the list in frame order and a report of what it contains
+// Messages not in ranked are not counted as dropped
+func Select(messages []database.ProcessedMessage, ranked []int, budget Budget, tokens int) (string, Report) {
+	report := Report{
+		Messages: len(messages),
+		Dropped:  make(map[string]int),
+		Budget:   tokens,
+	}
+
+	// Shorten, encode and keep every message that still fits
+	used := 0
+	var included []int
+	lines := make(map[int]string)
+	for _, i := range ranked {
+		// No message encodes below minLineTokens, skip the work once full
+		if tokens-used < minLineTokens {
+			report.Dropped[messages[i].Protocol]++
+			continue
+		}
+		short, truncated := Shorten(messages[i])
+		line, err := json.Marshal(short)
+		if err != nil {
+			continue
+		}
+		cost := budget.Tokens(string(line))
+		if used+cost > tokens {
+			report.Dropped[messages[i].Protocol]++
+			continue
+		}
+		used += cost
+		report.Truncated += truncated
+		included = append(included, i)
+		lines[i] = string(line)
+	}
+	report.Included = len(included)
+
+	// Restore frame order for the listed messages
+	sort.Ints(included)
+
+	var text strings.Builder
+	text.WriteString("Messages (one JSON object per line, frame order):\n")
+	for _, i := range included {
+		text.WriteString(lines[i])
+		text.WriteString("\n")
+	}
+	if len(report.Dropped) > 0 {
+		fmt.Fprintf(&text, "\nNot listed to fit the context window (see summary): %s\n", report.droppedList())
+	}
+	if report.Truncated > 0 {
+		fmt.Fprintf(&text, "%d long fields were shortened, marked with \"...[N more chars]\".\n", report.Truncated)
+	}
+
+	report.Tokens = budget.Tokens(text.String())
+	return text.String(), report
+}
+
+// Shorten returns a copy of the message with large fields cut
+// Returns the copy and the number of fields cut
+func Shorten(m database.ProcessedMessage) (database.ProcessedMessage, int) {
+	short := m
+	short.Message = make(map[string]string, len(m.Message))
+	truncated := 0
+	for key, value := range m.Message {
+		limit := maxFieldChars
+		if rawFields[key] {
+			limit = maxRawChars
+		}
+		if len(value) > limit {
+			value = fmt.Sprintf("%s...[%d more chars]", value[:limit], len(value)-limit)
+			truncated++
+		}
+		short.Message[key] = value
+	}
+	return short, truncated
+}
+
+// flowStats aggregates the messages of one protocol between two hosts
+type flowStats struct {
+	protocol   string
+	src, dst   string
+	count      int
+	firstFrame uint64
+	lastFrame  uint64
+	firstTime  string
+	lastTime   string
+	values     map[string][]string // Distinct flowKeys values
+}
+
+// Summary describes the whole capture per protocol and per flow
+func Summary(messages []database.ProcessedMessage) string {
+	var text strings.Builder
+	if len(messages) == 0 {
+		return "Capture summary: no decoded messages\n"
+	}
+
+	fmt.Fprintf(&text, "Capture summary: %d decoded messages from %s to %s\n",
+		len(messages), messages[0].Time_Stamp, messages[len(messages)-1].Time_Stamp)
+
+	// Aggregate per protocol and per flow
+	perProtocol := make(map[string]int)
+	flows := make(map[string]*flowStats)
+	for _, m := range messages {
+		perProtocol[m.Protocol]++
+
+		key := m.Protocol + " " + m.Src_IpAddr + " " + m.Dst_IpAddr
+		f, ok := flows[key]
+		if !ok {
+			f = &flowStats{
+				protocol:   m.Protocol,
+				src:        m.Src_IpAddr,
+				dst:        m.Dst_IpAddr,
+				firstFrame: m.Frame_Number,
+				firstTime:  m.Time_Stamp,
+				values:     make(map[string][]string),
+			}
+			flows[key] = f
+		}
+		f.count++
+		f.lastFrame = m.Frame_Number
+		f.lastTime = m.Time_Stamp
+		for _, k := range flowKeys[m.Protocol] {
+			if v, ok := m.Message[k]; ok && len(f.values[k]) < 5 && !contains(f.values[k], v) {
+				f.values[k] = append(f.values[k], v)
+			}
+		}
+	}
+
+	// Protocols by message count
+	protocols := make([]string, 0, len(perProtocol))
+	for protocol := range perProtocol {
+		protocols = append(protocols, protocol)
+	}
+	sort.Slice(protocols, func(i, j int) bool {
+		return perProtocol[protocols[i]] > perProtocol[protocols[j]]
+	})
+	text.WriteString("Protocols:\n")
+	for _, protocol := range protocols {
+		fmt.Fprintf(&text, "  %s: %d messages\n", protocol, perProtocol[protocol])
+	}
+
+	// Busiest flows first
+	list := make([]*flowStats, 0, len(flows))
+	for _, f := range flows {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+		return list[i].firstFrame < list[j].firstFrame
+	})
+	text.WriteString("Flows (protocol source -> destination):\n")
+	for i, f := range list {
+		if i == maxFlows {
+			fmt.Fprintf(&text, "  ... %d more flows\n", len(list)-maxFlows)
+			break
+		}
+		fmt.Fprintf(&text, "  %s %s -> %s: %d messages, frames %d-%d, %s to %s",
+			f.protocol, f.src, f.dst, f.count, f.firstFrame, f.lastFrame, f.firstTime, f.lastTime)
+		for _, k := range flowKeys[f.protocol] {
+			if len(f.values[k]) > 0 {
+				fmt.Fprintf(&text, ", %s %s", k, strings.Join(f.values[k], ","))
+			}
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+// contains reports whether a value is in the list
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+// chatgpt.go
+// This file implements the ChatGPT provider on top of the OpenAI chat completions API.
+// Config.URL switches the client to any OpenAI-compatible server
+// (e.g. a local vLLM or llama.cpp endpoint such as http://localhost:8000/v1).
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	Register("ChatGPT", func() Provider { return &chatGPT{} })
+}
+
+// chatGPT talks to the OpenAI chat completions API
+type chatGPT struct {
+	client *openai.Client
+	model  string
+}
+
+// Init creates the OpenAI client
+// The API key is read from CHATGPT_API_KEY or OPENAI_API_KEY when not configured
+func (c *chatGPT) Init(ctx context.Context, cfg Config) error {
+	apiKey := cfg.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("CHATGPT_API_KEY")
+	}
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	// Self-hosted OpenAI-compatible servers usually run without a key
+	if apiKey == "" && cfg.URL == "" {
+		return fmt.Errorf("CHATGPT_API_KEY environment variable is not set")
+	}
+
+	clientConfig := openai.DefaultConfig(apiKey)
+	if cfg.URL != "" {
+		clientConfig.BaseURL = cfg.URL
+	}
+	clientConfig.HTTPClient = cfg.httpClient()
+	c.client = openai.NewClientWithConfig(clientConfig)
+
+	c.model = cfg.Model
+	if c.model == "" {
+		c.model = openai.GPT4o
+	}
+	return nil
+}
+
+// Chat sends the conversation and returns the first choice
+func (c *chatGPT) Chat(ctx context.Context, history []Message) (string, error) {
+	resp, err := c.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:    c.model,
+		Messages: toOpenAIMessages(history),
+	})
+	if err != nil {
+		return "", fmt.Errorf("ChatCompletion error: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("ChatCompletion returned no choices")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// Stream sends the conversation and forwards every content delta to onToken
+func (c *chatGPT) Stream(ctx context.Context, history []Message, onToken func(string) error) (string, error) {
+	stream, err := c.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:    c.model,
+		Messages: toOpenAIMessages(history),
+		Stream:   true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("ChatCompletionStream error: %v", err)
+	}
+	defer stream.Close()
+
+	var response string
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return response, nil
+		}
+		if err != nil {
+			return response, fmt.Errorf("ChatCompletionStream error: %v", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		token := chunk.Choices[0].Delta.Content
+		response += token
+		if err := onToken(token); err != nil {
+			return response, err
+		}
+	}
+}
+
+// ChatTools sends the conversation with the tools as OpenAI functions
+func (c *chatGPT) ChatTools(ctx context.Context, history []Message, tools []Tool) (Message, error) {
+	resp, err := c.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:    c.model,
+		Messages: toOpenAIMessages(history),
+		Tools:    toOpenAITools(tools),
+	})
+	if err != nil {
+		return Message{}, toolsError("ChatCompletion", err)
+	}
+	if len(resp.Choices) == 0 {
+		return Message{}, fmt.Errorf("ChatCompletion returned no choices")
+	}
+
+	choice := resp.Choices[0].Message
+	reply := Message{Role: RoleAssistant, Content: choice.Content}
+	for _, call := range choice.ToolCalls {
+		reply.ToolCalls = append(reply.ToolCalls, ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	return reply, nil
+}
+
+// StreamTools streams the reply content and collects tool call deltas
+// A call's ID and name arrive in its first delta, the arguments in pieces
+func (c *chatGPT) StreamTools(ctx context.Context, history []Message, tools []Tool, onToken func(string) error) (Message, error) {
+	stream, err := c.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:    c.model,
+		Messages: toOpenAIMessages(history),
+		Tools:    toOpenAITools(tools),
+		Stream:   true,
+	})
+	if err != nil {
+		return Message{}, toolsError("ChatCompletionStream", err)
+	}
+	defer stream.Close()
+
+	reply := Message{Role: RoleAssistant}
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return reply, nil
+		}
+		if err != nil {
+			return reply, fmt.Errorf("ChatCompletionStream error: %v", err)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+
+		for _, call := range delta.ToolCalls {
+			i := len(reply.ToolCalls) - 1
+			if call.Index != nil {
+				i = *call.Index
+			}
+			for len(reply.ToolCalls) <= i {
+				reply.ToolCalls = append(reply.ToolCalls, ToolCall{})
+			}
+			if call.ID != "" {
+				reply.ToolCalls[i].ID = call.ID
+			}
+			if call.Function.Name != "" {
+				reply.ToolCalls[i].Name = call.Function.Name
+			}
+			reply.ToolCalls[i].Arguments += call.Function.Arguments
+		}
+
+		if delta.Content == "" {
+			continue
+		}
+		reply.Content += delta.Content
+		if err := onToken(delta.Content); err != nil {
+			return reply, err
+		}
+	}
+}
+
+// Close is a no-op, the OpenAI client holds no resources
+func (c *chatGPT) Close() error {
+	return nil
+}
+
+// toolsError describes a failed request with tools
+// Refused tool definitions are marked with ErrToolsUnsupported; OpenAI names the
+// refused parameter, other servers are matched on their refusal message
+func toolsError(op string, err error) error {
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Param != nil && apiErr.HTTPStatusCode == http.StatusBadRequest &&
+		(*apiErr.Param == "tools" || *apiErr.Param == "tool_choice"),
+		errors.As(err, &apiErr) && toolsRejected(apiErr.HTTPStatusCode, apiErr.Message),
+		errors.As(err, &reqErr) && toolsRejected(reqErr.HTTPStatusCode, string(reqErr.Body)):
+		return fmt.Errorf("%s error: %w: %v", op, ErrToolsUnsupported, err)
+	}
+	return fmt.Errorf("%s error: %v", op, err)
+}
+
+// toOpenAITools converts tool definitions to OpenAI functions
+func toOpenAITools(tools []Tool) []openai.Tool {
+	definitions := make([]openai.Tool, 0, len(tools))
+	for _, t := range tools {
+		definitions = append(definitions, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  t.schema(),
+			},
+		})
+	}
+	return definitions
+}
+
+// toOpenAIMessages converts chat turns to the OpenAI message format
+func toOpenAIMessages(history []Message) []openai.ChatCompletionMessage {
+	messages := make([]openai.ChatCompletionMessage, 0, len(history))
+	for _, m := range history {
+		message := openai.ChatCompletionMessage{
+			Role:       m.Role,
+			Content:    m.Content,
+			ToolCallID: m.ToolCallID,
+		}
+		for _, call := range m.ToolCalls {
+			message.ToolCalls = append(message.ToolCalls, openai.ToolCall{
+				ID:       call.ID,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// openAIServer answers chat completions like the OpenAI API
+// Requests are checked for the model and the last user turn
+func openAIServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Model    string `json:"model"`
+			Stream   bool   `json:"stream"`
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		if req.Model != "test-model" {
+			t.Errorf("model = %q, want test-model", req.Model)
+		}
+		if n := len(req.Messages); n != 2 || req.Messages[n-1].Content != "Why did the call fail?" {
+			t.Errorf("unexpected messages %+v", req.Messages)
+		}
+
+		if !req.Stream {
+			fmt.Fprint(w, `{"id":"1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"486 Busy Here"},"finish_reason":"stop"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range []string{"486 ", "Busy ", "Here"} {
+			fmt.Fprintf(w, "data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", token)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+// history is the conversation sent by the provider tests
+var history = []Message{
+	{Role: RoleSystem, Content: "Decoded SIP messages"},
+	{Role: RoleUser, Content: "Why did the call fail?"},
+}
+
+func TestChatGPTChat(t *testing.T) {
+	server := openAIServer(t)
+	defer server.Close()
+
+	p := &chatGPT{}
+	if err := p.Init(context.Background(), Config{Model: "test-model", URL: server.URL + "/v1", APIKey: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := p.Chat(context.Background(), history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "486 Busy Here" {
+		t.Errorf("reply = %q", reply)
+	}
+}
+
+func TestChatGPTStream(t *testing.T) {
+	server := openAIServer(t)
+	defer server.Close()
+
+	p := &chatGPT{}
+	if err := p.Init(context.Background(), Config{Model: "test-model", URL: server.URL + "/v1", APIKey: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	var tokens []string
+	reply, err := p.Stream(context.Background(), history, func(token string) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "486 Busy Here" || strings.Join(tokens, "|") != "486 |Busy |Here" {
+		t.Errorf("reply = %q, tokens = %q", reply, tokens)
+	}
+}
+
+// TestChatGPTToolsRejected checks that only a refusal of the tools is marked ErrToolsUnsupported
+func TestChatGPTToolsRejected(t *testing.T) {
+	tests := []struct {
+		status  int
+		message string
+		param   string
+		want    bool
+	}{
+		{http.StatusBadRequest, `"auto" tool choice requires --enable-auto-tool-choice to be set`, "", true},
+		{http.StatusBadRequest, "Unsupported parameter: 'tools' is not supported with this model.", "tools", true},
+		{http.StatusBadRequest, "Invalid value for 'tool_choice': 'tool_choice' is only allowed when 'tools' are specified.", "tool_choice", true},
+		{http.StatusTooManyRequests, "Rate limit reached for requests", "", false},
+		{http.StatusBadRequest, "This model's maximum context length is 8192 tokens. However, your messages resulted in 9120 tokens " +
+			"(8710 in the messages, 410 in the functions). Please reduce the length of the messages or functions.", "messages", false},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(tt.status)
+			fmt.Fprintf(w, `{"error":{"message":%q,"type":"invalid_request_error","param":%q}}`, tt.message, tt.param)
+		}))
+
+		p := &chatGPT{}
+		if err := p.Init(context.Background(), Config{Model: "test-model", URL: server.URL + "/v1", APIKey: "key"}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := p.ChatTools(context.Background(), history, []Tool{{Name: "list_flows"}})
+		if err == nil || errors.Is(err, ErrToolsUnsupported) != tt.want {
+			t.Errorf("%d %q: err = %v, tools unsupported want %v", tt.status, tt.message, err, tt.want)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,163 @@
+// gemini.go
+// This file implements the Gemini provider on top of the Google generative AI client.
+// System turns become the model's system instruction and the remaining turns
+// are replayed as chat history before the last user turn is sent.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+func init() {
+	Register("Gemini", func() Provider { return &gemini{} })
+}
+
+// gemini talks to the Gemini generateContent API
+type gemini struct {
+	client *genai.Client
+	model  string
+}
+
+// Init creates the Gemini client
+// The API key is read from GEMINI_API_KEY when not configured
+func (g *gemini) Init(ctx context.Context, cfg Config) error {
+	apiKey := cfg.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("GEMINI_API_KEY")
+	}
+	if apiKey == "" {
+		return fmt.Errorf("GEMINI_API_KEY environment variable is not set")
+	}
+
+	opts := []option.ClientOption{option.WithAPIKey(apiKey)}
+	if cfg.URL != "" {
+		opts = append(opts, option.WithEndpoint(cfg.URL))
+	}
+	if cfg.HTTPClient != nil {
+		opts = append(opts, option.WithHTTPClient(cfg.HTTPClient))
+	}
+
+	client, err := genai.NewClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("error creating Gemini client: %v", err)
+	}
+	g.client = client
+
+	g.model = cfg.Model
+	if g.model == "" {
+		g.model = "gemini-1.5-flash"
+	}
+	return nil
+}
+
+// Chat sends the conversation and returns the text of the reply
+// The reply is read from the response stream, as the client library does itself
+func (g *gemini) Chat(ctx context.Context, history []Message) (string, error) {
+	response, err := g.Stream(ctx, history, func(string) error { return nil })
+	if err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
+// Stream sends the conversation and forwards every partial response to onToken
+func (g *gemini) Stream(ctx context.Context, history []Message, onToken func(string) error) (string, error) {
+	cs, last, err := g.session(history)
+	if err != nil {
+		return "", err
+	}
+
+	var response string
+	finished := false
+	iter := cs.SendMessageStream(ctx, genai.Text(last))
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			return response, nil
+		}
+		if err != nil {
+			// The reply is complete once a chunk carried the finish reason. Reading past
+			// it fails with the encoding/json v2 implementation of newer Go releases,
+			// as the stream reader of the client library does not see the closing bracket
+			if finished {
+				return response, nil
+			}
+			return response, fmt.Errorf("Gemini error: %v", err)
+		}
+		finished = finished || finishReason(resp) != genai.FinishReasonUnspecified
+		token := responseText(resp)
+		if token == "" {
+			continue
+		}
+		response += token
+		if err := onToken(token); err != nil {
+			return response, err
+		}
+	}
+}
+
+// Close releases the Gemini client connection
+func (g *gemini) Close() error {
+	if g.client == nil {
+		return nil
+	}
+	return g.client.Close()
+}
+
+// session builds a chat session holding every turn but the last one
+// Returns the session and the text of the last (user) turn
+func (g *gemini) session(history []Message) (*genai.ChatSession, string, error) {
+	if len(history) == 0 || history[len(history)-1].Role != RoleUser {
+		return nil, "", fmt.Errorf("Gemini chat must end with a user message")
+	}
+
+	model := g.client.GenerativeModel(g.model)
+	cs := model.StartChat()
+
+	var system []string
+	for _, m := range history[:len(history)-1] {
+		switch m.Role {
+		case RoleSystem:
+			system = append(system, m.Content)
+		case RoleAssistant:
+			cs.History = append(cs.History, &genai.Content{Parts: []genai.Part{genai.Text(m.Content)}, Role: "model"})
+		default:
+			cs.History = append(cs.History, &genai.Content{Parts: []genai.Part{genai.Text(m.Content)}, Role: "user"})
+		}
+	}
+	if len(system) > 0 {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(strings.Join(system, "\n\n")))
+	}
+
+	return cs, history[len(history)-1].Content, nil
+}
+
+// responseText joins the text parts of the first candidate
+func responseText(resp *genai.GenerateContentResponse) string {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text.WriteString(string(t))
+		}
+	}
+	return text.String()
+}
+
+// finishReason returns why the model stopped, FinishReasonUnspecified while it is still generating
+func finishReason(resp *genai.GenerateContentResponse) genai.FinishReason {
+	if resp == nil || len(resp.Candidates) == 0 {
+		return genai.FinishReasonUnspecified
+	}
+	return resp.Candidates[0].FinishReason
+}
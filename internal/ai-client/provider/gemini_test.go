@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// geminiServer answers generateContent and streamGenerateContent like the Gemini REST API
+// Streamed responses are one JSON array, sent element by element, the last one with the
+// finish reason; a truncated response ends after the second element
+func geminiServer(t *testing.T, truncated bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SystemInstruction struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"systemInstruction"`
+			Contents []struct {
+				Role  string `json:"role"`
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		if n := len(req.Contents); n != 1 || req.Contents[0].Parts[0].Text != "Why did the call fail?" {
+			t.Errorf("unexpected contents %+v", req.Contents)
+		}
+		if parts := req.SystemInstruction.Parts; len(parts) != 1 || parts[0].Text != "Decoded SIP messages" {
+			t.Errorf("unexpected system instruction %+v", req.SystemInstruction)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1beta/models/test-model:generateContent":
+			fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"486 Busy Here"}]}}]}`)
+		case "/v1beta/models/test-model:streamGenerateContent":
+			var chunks []string
+			for _, token := range []string{"486 ", "Busy "} {
+				chunks = append(chunks, fmt.Sprintf(`{"candidates":[{"content":{"role":"model","parts":[{"text":%q}]}}]}`, token))
+			}
+			if truncated {
+				fmt.Fprint(w, "["+strings.Join(chunks, ",\r\n"))
+				return
+			}
+			chunks = append(chunks, `{"candidates":[{"content":{"role":"model","parts":[{"text":"Here"}]},"finishReason":"STOP"}]}`)
+			fmt.Fprint(w, "["+strings.Join(chunks, ",\r\n")+"]")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// newTestGemini returns a Gemini provider talking to server
+func newTestGemini(t *testing.T, server *httptest.Server) *gemini {
+	p := &gemini{}
+	err := p.Init(context.Background(), Config{Model: "test-model", URL: server.URL, APIKey: "key", HTTPClient: server.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestGeminiChat(t *testing.T) {
+	server := geminiServer(t, false)
+	defer server.Close()
+
+	reply, err := newTestGemini(t, server).Chat(context.Background(), history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "486 Busy Here" {
+		t.Errorf("reply = %q", reply)
+	}
+}
+
+func TestGeminiStream(t *testing.T) {
+	server := geminiServer(t, false)
+	defer server.Close()
+
+	var tokens []string
+	reply, err := newTestGemini(t, server).Stream(context.Background(), history, func(token string) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "486 Busy Here" || strings.Join(tokens, "|") != "486 |Busy |Here" {
+		t.Errorf("reply = %q, tokens = %q", reply, tokens)
+	}
+}
+
+// TestGeminiTruncatedStream checks that a reply cut off before the finish reason is an error
+func TestGeminiTruncatedStream(t *testing.T) {
+	server := geminiServer(t, true)
+	defer server.Close()
+
+	p := newTestGemini(t, server)
+	if reply, err := p.Chat(context.Background(), history); err == nil {
+		t.Errorf("Chat reply = %q, want an error", reply)
+	}
+	if reply, err := p.Stream(context.Background(), history, func(string) error { return nil }); err == nil {
+		t.Errorf("Stream reply = %q, want an error", reply)
+	}
+}
@@ -0,0 +1,194 @@
+// ollama.go
+// This file implements the Ollama provider for locally hosted models.
+// Config.URL accepts either the server base URL (http://localhost:11434)
+// or the full chat endpoint (http://localhost:11434/api/chat).
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+func init() {
+	Register("Ollama", func() Provider { return &ollama{} })
+}
+
+// ollama talks to the Ollama /api/chat endpoint
+type ollama struct {
+	client *api.Client
+	model  string
+	window int // num_ctx option, server default when 0
+}
+
+// Init creates the Ollama client
+func (o *ollama) Init(ctx context.Context, cfg Config) error {
+	base := "http://localhost:11434" // Default Ollama API endpoint
+	if cfg.URL != "" {
+		base = strings.TrimSuffix(strings.TrimSuffix(cfg.URL, "/"), "/api/chat")
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("invalid Ollama URL: %v", err)
+	}
+	o.client = api.NewClient(u, cfg.httpClient())
+
+	o.model = cfg.Model
+	if o.model == "" {
+		o.model = "llama3"
+	}
+	// Ollama silently truncates prompts longer than num_ctx
+	o.window = cfg.Window
+	return nil
+}
+
+// Chat sends the conversation and waits for the complete reply
+func (o *ollama) Chat(ctx context.Context, history []Message) (string, error) {
+	stream := false
+	var response string
+	err := o.client.Chat(ctx, o.request(history, &stream), func(resp api.ChatResponse) error {
+		response += resp.Message.Content
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Ollama chat error: %v", err)
+	}
+	return response, nil
+}
+
+// Stream sends the conversation and forwards every streamed chunk to onToken
+func (o *ollama) Stream(ctx context.Context, history []Message, onToken func(string) error) (string, error) {
+	stream := true
+	var response string
+	err := o.client.Chat(ctx, o.request(history, &stream), func(resp api.ChatResponse) error {
+		if resp.Message.Content == "" {
+			return nil
+		}
+		response += resp.Message.Content
+		return onToken(resp.Message.Content)
+	})
+	if err != nil {
+		return response, fmt.Errorf("Ollama chat error: %v", err)
+	}
+	return response, nil
+}
+
+// ChatTools sends the conversation with the tools as Ollama functions
+func (o *ollama) ChatTools(ctx context.Context, history []Message, tools []Tool) (Message, error) {
+	return o.chatTools(ctx, history, tools, false, nil)
+}
+
+// StreamTools streams the reply content, Ollama sends tool calls in a single chunk
+func (o *ollama) StreamTools(ctx context.Context, history []Message, tools []Tool, onToken func(string) error) (Message, error) {
+	return o.chatTools(ctx, history, tools, true, onToken)
+}
+
+// chatTools runs a chat request with tools
+// Ollama does not number tool calls, IDs are assigned in order of the reply
+func (o *ollama) chatTools(ctx context.Context, history []Message, tools []Tool, stream bool, onToken func(string) error) (Message, error) {
+	req := o.request(history, &stream)
+	for _, t := range tools {
+		req.Tools = append(req.Tools, toOllamaTool(t))
+	}
+
+	var reply api.Message
+	err := o.client.Chat(ctx, req, func(resp api.ChatResponse) error {
+		reply.Content += resp.Message.Content
+		reply.ToolCalls = append(reply.ToolCalls, resp.Message.ToolCalls...)
+		if onToken != nil && resp.Message.Content != "" {
+			return onToken(resp.Message.Content)
+		}
+		return nil
+	})
+	if ollamaToolsRejected(err) {
+		return Message{}, fmt.Errorf("Ollama chat error: %w: %v", ErrToolsUnsupported, err)
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("Ollama chat error: %v", err)
+	}
+
+	message := Message{Role: RoleAssistant, Content: reply.Content}
+	for i, call := range reply.ToolCalls {
+		message.ToolCalls = append(message.ToolCalls, ToolCall{
+			ID:        fmt.Sprintf("call_%d", i),
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments.String(),
+		})
+	}
+	return message, nil
+}
+
+// ollamaToolsRejected tells whether a chat error refuses the tools of the request
+// The client returns the error text of streamed replies without their status,
+// Ollama answers models without function calling with "<model> does not support tools"
+func ollamaToolsRejected(err error) bool {
+	var status api.StatusError
+	if errors.As(err, &status) {
+		return toolsRejected(status.StatusCode, status.ErrorMessage)
+	}
+	return err != nil && strings.HasSuffix(err.Error(), "does not support tools")
+}
+
+// Close is a no-op, the Ollama client holds no resources
+func (o *ollama) Close() error {
+	return nil
+}
+
+// request builds an Ollama chat request from the conversation
+func (o *ollama) request(history []Message, stream *bool) *api.ChatRequest {
+	messages := make([]api.Message, 0, len(history))
+	for _, m := range history {
+		message := api.Message{
+			Role:    m.Role,
+			Content: m.Content,
+		}
+		for _, call := range m.ToolCalls {
+			var arguments api.ToolCallFunctionArguments
+			json.Unmarshal([]byte(call.Arguments), &arguments)
+			message.ToolCalls = append(message.ToolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{Name: call.Name, Arguments: arguments},
+			})
+		}
+		messages = append(messages, message)
+	}
+	req := &api.ChatRequest{
+		Model:    o.model,
+		Messages: messages,
+		Stream:   stream,
+	}
+	if o.window > 0 {
+		req.Options = map[string]interface{}{"num_ctx": o.window}
+	}
+	return req
+}
+
+// toOllamaTool converts a tool definition to the Ollama function format
+func toOllamaTool(t Tool) api.Tool {
+	tool := api.Tool{Type: "function"}
+	tool.Function.Name = t.Name
+	tool.Function.Description = t.Description
+	tool.Function.Parameters.Type = "object"
+	tool.Function.Parameters.Required = []string{}
+	tool.Function.Parameters.Properties = make(map[string]struct {
+		Type        string   `json:"type"`
+		Description string   `json:"description"`
+		Enum        []string `json:"enum,omitempty"`
+	})
+	for _, p := range t.Parameters {
+		tool.Function.Parameters.Properties[p.Name] = struct {
+			Type        string   `json:"type"`
+			Description string   `json:"description"`
+			Enum        []string `json:"enum,omitempty"`
+		}{Type: p.Type, Description: p.Description, Enum: p.Enum}
+		if p.Required {
+			tool.Function.Parameters.Required = append(tool.Function.Parameters.Required, p.Name)
+		}
+	}
+	return tool
+}
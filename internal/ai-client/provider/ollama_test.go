@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ollamaServer answers /api/chat like an Ollama server, one JSON object per line
+func ollamaServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Model    string         `json:"model"`
+			Stream   *bool          `json:"stream"`
+			Options  map[string]any `json:"options"`
+			Messages []Message      `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		if req.Model != "test-model" {
+			t.Errorf("model = %q, want test-model", req.Model)
+		}
+		if req.Options["num_ctx"] != float64(8192) {
+			t.Errorf("num_ctx = %v, want 8192", req.Options["num_ctx"])
+		}
+		if n := len(req.Messages); n != 2 || req.Messages[n-1].Content != "Why did the call fail?" {
+			t.Errorf("unexpected messages %+v", req.Messages)
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if req.Stream != nil && !*req.Stream {
+			fmt.Fprintln(w, `{"model":"test-model","message":{"role":"assistant","content":"486 Busy Here"},"done":true}`)
+			return
+		}
+		for _, token := range []string{"486 ", "Busy ", "Here"} {
+			fmt.Fprintf(w, "{\"model\":\"test-model\",\"message\":{\"role\":\"assistant\",\"content\":%q},\"done\":false}\n", token)
+		}
+		fmt.Fprintln(w, `{"model":"test-model","message":{"role":"assistant","content":""},"done":true}`)
+	}))
+}
+
+func TestOllamaChat(t *testing.T) {
+	server := ollamaServer(t)
+	defer server.Close()
+
+	p := &ollama{}
+	if err := p.Init(context.Background(), Config{Model: "test-model", URL: server.URL + "/api/chat", Window: 8192}); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := p.Chat(context.Background(), history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "486 Busy Here" {
+		t.Errorf("reply = %q", reply)
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	server := ollamaServer(t)
+	defer server.Close()
+
+	p := &ollama{}
+	if err := p.Init(context.Background(), Config{Model: "test-model", URL: server.URL, Window: 8192}); err != nil {
+		t.Fatal(err)
+	}
+	var tokens []string
+	reply, err := p.Stream(context.Background(), history, func(token string) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "486 Busy Here" || strings.Join(tokens, "|") != "486 |Busy |Here" {
+		t.Errorf("reply = %q, tokens = %q", reply, tokens)
+	}
+}
+
+// TestOllamaToolsRejected checks that only a refusal of the tools is marked ErrToolsUnsupported
+func TestOllamaToolsRejected(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   bool
+	}{
+		{http.StatusBadRequest, `{"error":"registry.ollama.ai/library/llama2:latest does not support tools"}`, true},
+		{http.StatusTooManyRequests, `{"error":"server busy, please try again"}`, false},
+		{http.StatusBadRequest, `{"error":"invalid message role: function"}`, false},
+		{http.StatusNotFound, `{"error":"model \"llama2\" not found, try pulling it first"}`, false},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		}))
+
+		p := &ollama{}
+		if err := p.Init(context.Background(), Config{Model: "llama2", URL: server.URL}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := p.ChatTools(context.Background(), history, []Tool{{Name: "list_flows"}})
+		if err == nil || errors.Is(err, ErrToolsUnsupported) != tt.want {
+			t.Errorf("status %d: err = %v, tools unsupported want %v", tt.status, err, tt.want)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,120 @@
+// provider.go
+// This file defines the interface implemented by every AI backend used by DeepPacketAI.
+// Core functionalities:
+// - Common chat message format shared by all backends
+// - Registry of named provider implementations
+// - Provider construction by name (e.g. "ChatGPT", "Ollama", "Gemini")
+//
+// Example scenarios:
+// 1. Adding a backend:
+//    A new file calls Register("MyLLM", func() Provider { return &myLLM{} })
+//    from init, no other code needs to change
+//
+// 2. Testing a backend:
+//    Config.URL points the provider at an httptest server
+//    instead of the public API
+
+// Package provider contains the pluggable AI backends
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Chat roles understood by every provider
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a single chat turn
+// Example: {Role: "user", Content: "Why did the INVITE fail?"}
+type Message struct {
+	Role       string     `json:"role"`                   // One of RoleSystem, RoleUser, RoleAssistant, RoleTool
+	Content    string     `json:"content"`                // Text of the turn
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // Functions requested by an assistant turn
+	ToolCallID string     `json:"tool_call_id,omitempty"` // Call answered by a RoleTool turn
+}
+
+// Config holds the settings passed to a provider on Init
+type Config struct {
+	Model      string       // Model name, provider default when empty
+	URL        string       // Endpoint override (self-hosted server or test stand-in)
+	APIKey     string       // API key, read from the environment when empty
+	HTTPClient *http.Client // HTTP client, http.DefaultClient when nil
+	Window     int          // Context window in tokens, provider default when 0
+}
+
+// Provider is implemented by every AI backend
+// Conversation history is owned by the caller and passed on each turn
+type Provider interface {
+	// Init creates the backend client
+	// ctx bounds any network calls made during setup
+	Init(ctx context.Context, cfg Config) error
+
+	// Chat sends the conversation and returns the complete reply
+	Chat(ctx context.Context, history []Message) (string, error)
+
+	// Stream sends the conversation and calls onToken for every chunk of the reply
+	// Returns the complete reply once the model is done
+	Stream(ctx context.Context, history []Message, onToken func(string) error) (string, error)
+
+	// Close releases the backend client
+	Close() error
+}
+
+// Factory creates an uninitialised provider
+type Factory func() Provider
+
+// registry maps provider names to their factories
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a provider available by name
+// Called from the init function of each implementation file
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic("provider: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// New returns an uninitialised provider registered under name
+func New(name string) (Provider, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported LLM selected: %q", name)
+	}
+	return factory(), nil
+}
+
+// Names lists the registered providers in alphabetical order
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// httpClient returns the configured HTTP client or the default one
+func (c Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,112 @@
+// tools.go
+// This file defines function calling for providers whose API supports it.
+// Core functionalities:
+// - Provider-neutral tool definitions with typed parameters
+// - Tool calls returned by the model and tool results sent back
+//
+// Example scenario:
+//    The model answers "Why did call X fail?" with a call to
+//    get_messages_by_call_id{"call_id": "X"}; the caller runs the tool,
+//    appends the result as a RoleTool message and asks again
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// RoleTool marks a message carrying the result of a tool call
+const RoleTool = "tool"
+
+// ErrToolsUnsupported is wrapped by ToolCaller errors when the model rejects
+// the tool definitions, e.g. an Ollama model without function calling
+// Callers may retry the conversation without tools; other errors are final
+var ErrToolsUnsupported = errors.New("model does not support tools")
+
+// Parameter describes one argument of a tool
+type Parameter struct {
+	Name        string   // Argument name
+	Type        string   // JSON schema type: "string", "integer", "number" or "boolean"
+	Description string   // What the argument means, shown to the model
+	Required    bool     // Whether the model must always pass it
+	Enum        []string // Allowed values, any when empty
+}
+
+// Tool describes a function the model may call
+type Tool struct {
+	Name        string      // Function name, e.g. "list_flows"
+	Description string      // What the function returns, shown to the model
+	Parameters  []Parameter // Arguments of the function
+}
+
+// ToolCall is a function call requested by the model
+type ToolCall struct {
+	ID        string `json:"id"`        // Call identifier, echoed in the result message
+	Name      string `json:"name"`      // Function name
+	Arguments string `json:"arguments"` // Arguments as a JSON object
+}
+
+// ToolCaller is implemented by providers supporting function calling
+type ToolCaller interface {
+	// ChatTools sends the conversation with the available tools
+	// Returns the assistant message, which either has content or ToolCalls
+	ChatTools(ctx context.Context, history []Message, tools []Tool) (Message, error)
+
+	// StreamTools is ChatTools with the reply content forwarded to onToken as it arrives
+	StreamTools(ctx context.Context, history []Message, tools []Tool, onToken func(string) error) (Message, error)
+}
+
+// toolRefusals are the messages of servers refusing the tools of a request, lower case
+// Other errors mentioning tools or functions, such as OpenAI's context length error
+// ("Please reduce the length of the messages or functions"), are not refusals
+var toolRefusals = []string{
+	"does not support tools",                         // Ollama
+	"tool choice requires --enable-auto-tool-choice", // vLLM without tool parsing
+	"'tools' is not supported",                       // OpenAI models without function calling
+	"unrecognized request argument supplied: tools",  // Azure OpenAI API versions before tools
+}
+
+// toolsRejected tells whether an API error refuses the tools of a request
+// Example: 400 "llama2 does not support tools"
+// Parameters:
+//   - status: HTTP status code of the reply
+//   - message: Error message of the reply
+func toolsRejected(status int, message string) bool {
+	if status != http.StatusBadRequest && status != http.StatusUnprocessableEntity {
+		return false
+	}
+	message = strings.ToLower(message)
+	for _, refusal := range toolRefusals {
+		if strings.Contains(message, refusal) {
+			return true
+		}
+	}
+	return false
+}
+
+// schema returns the JSON schema of the tool parameters
+func (t Tool) schema() map[string]any {
+	properties := make(map[string]any)
+	required := []string{}
+	for _, p := range t.Parameters {
+		property := map[string]any{
+			"type":        p.Type,
+			"description": p.Description,
+		}
+		if len(p.Enum) > 0 {
+			property["enum"] = p.Enum
+		}
+		properties[p.Name] = property
+		if p.Required {
+			required = append(required, p.Name)
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
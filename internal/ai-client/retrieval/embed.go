@@ -0,0 +1,122 @@
+// embed.go
+// This file adds optional embedding similarity to the search index.
+// Messages are embedded once when the capture is loaded, questions on every search.
+// Every message is embedded, including the rtp_stream records of media streams.
+//
+// Example scenario:
+//    -embed-model nomic-embed-text uses the Ollama /api/embed endpoint so
+//    "registration problems" also finds 401 and 403 responses to REGISTER
+
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ollama/ollama/api"
+)
+
+// embedBatch is the number of messages sent per embedding request
+const embedBatch = 64
+
+// maxEmbedChars bounds the text embedded per message
+const maxEmbedChars = 2000
+
+// Embedder turns texts into vectors
+type Embedder interface {
+	// Embed returns one vector per text, in order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// OllamaEmbedder embeds texts with a model served by Ollama
+type OllamaEmbedder struct {
+	client *api.Client
+	model  string
+}
+
+// NewOllamaEmbedder creates an embedder for an Ollama model
+// Parameters:
+//   - base: Ollama server URL, OLLAMA_HOST or localhost when empty
+//   - model: Embedding model, e.g. nomic-embed-text
+func NewOllamaEmbedder(base, model string) (*OllamaEmbedder, error) {
+	if base == "" {
+		client, err := api.ClientFromEnvironment()
+		if err != nil {
+			return nil, err
+		}
+		return &OllamaEmbedder{client: client, model: model}, nil
+	}
+
+	// Accept the chat endpoint used with -u as well as the server URL
+	u, err := url.Parse(strings.TrimSuffix(strings.TrimSuffix(base, "/"), "/api/chat"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Ollama URL: %v", err)
+	}
+	return &OllamaEmbedder{client: api.NewClient(u, http.DefaultClient), model: model}, nil
+}
+
+// Embed sends the texts to /api/embed
+func (e *OllamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := e.client.Embed(ctx, &api.EmbedRequest{Model: e.model, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("Ollama embed error: %v", err)
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("Ollama returned %d embeddings for %d texts", len(resp.Embeddings), len(texts))
+	}
+	return resp.Embeddings, nil
+}
+
+// Embed computes the message vectors used by Search
+// On error the index keeps working with keywords only
+func (ix *Index) Embed(ctx context.Context, embedder Embedder) error {
+	var docs []int
+	var texts []string
+	vectors := make(map[int][]float32)
+
+	// flush embeds the collected batch
+	flush := func() error {
+		if len(texts) == 0 {
+			return nil
+		}
+		result, err := embedder.Embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		for i, doc := range docs {
+			vectors[doc] = result[i]
+		}
+		docs, texts = docs[:0], texts[:0]
+		return nil
+	}
+
+	for i, m := range ix.messages {
+		text := documentText(m)
+		if len(text) > maxEmbedChars {
+			// Cut at the start of a character, not inside a multi-byte one
+			cut := maxEmbedChars
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+			text = text[:cut]
+		}
+		docs = append(docs, i)
+		texts = append(texts, text)
+		if len(texts) == embedBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	ix.embedder = embedder
+	ix.vectors = vectors
+	return nil
+}
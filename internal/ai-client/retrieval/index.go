@@ -0,0 +1,280 @@
+// index.go
+// This file implements a local search index over decoded messages.
+// Core functionalities:
+// - BM25 ranking over protocol, addresses, frame, time and header values
+// - Call-ID expansion so a matched SIP message brings its whole dialog
+// - Optional blending with embedding similarity
+//
+// Example scenarios:
+// 1. "Why did the call from 10.0.0.5 fail?":
+//    Messages to or from 10.0.0.5 rank first, the SIP dialogs they belong
+//    to follow, and only those are attached to the question
+//
+// 2. "What happened around 09:19:50?":
+//    Messages whose timestamp matches the clock time rank first
+
+// Package retrieval selects the decoded messages relevant to a question
+package retrieval
+
+import (
+	database "DeepPacketAI/internal/storage" // Decoded message types
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// skipFields hold hex dumps that never match a question
+var skipFields = map[string]bool{
+	"Payload":         true,
+	"Contents":        true,
+	"ExtensionHeader": true,
+}
+
+// stopWords are common question words that carry no search value
+var stopWords = map[string]bool{
+	"a": true, "all": true, "an": true, "and": true, "any": true, "are": true,
+	"did": true, "do": true, "does": true, "for": true, "from": true, "how": true,
+	"in": true, "is": true, "me": true, "of": true, "on": true, "or": true,
+	"show": true, "the": true, "there": true, "this": true, "to": true, "was": true,
+	"were": true, "what": true, "when": true, "which": true, "who": true, "why": true,
+	"with": true,
+}
+
+// framePattern finds frame references such as "frame 12" or "packet #12"
+var framePattern = regexp.MustCompile(`(?i)\b(?:frame|packet)\s*#?\s*(\d+)\b`)
+
+// posting records how often a term occurs in a message
+type posting struct {
+	doc int // Index of the message
+	tf  int // Term frequency
+}
+
+// Index ranks the decoded messages of one capture
+// Build once with New; Search is safe for concurrent use
+type Index struct {
+	messages []database.ProcessedMessage
+	postings map[string][]posting
+	docLen   []int
+	avgLen   float64
+	calls    map[string][]int // Call-ID to messages in frame order
+
+	embedder Embedder
+	vectors  map[int][]float32 // Embeddings of the embedded messages
+}
+
+// New indexes the decoded messages of a capture
+func New(messages []database.ProcessedMessage) *Index {
+	ix := &Index{
+		messages: messages,
+		postings: make(map[string][]posting),
+		docLen:   make([]int, len(messages)),
+		calls:    make(map[string][]int),
+	}
+
+	total := 0
+	for i, m := range messages {
+		tf := make(map[string]int)
+		for _, term := range tokenize(documentText(m)) {
+			tf[term]++
+		}
+		for term, n := range tf {
+			ix.postings[term] = append(ix.postings[term], posting{doc: i, tf: n})
+			ix.docLen[i] += n
+		}
+		total += ix.docLen[i]
+
+		if id := m.Message["Call-ID"]; id != "" {
+			ix.calls[id] = append(ix.calls[id], i)
+		}
+	}
+	if len(messages) > 0 {
+		ix.avgLen = float64(total) / float64(len(messages))
+	}
+	return ix
+}
+
+// Search returns the messages relevant to a question, most relevant first
+// Parameters:
+//   - ctx: Bounds the embedding request, if any
+//   - query: User question
+//   - limit: Maximum number of directly matched messages
+//
+// Messages of the same SIP dialog follow each match and do not count
+// towards limit. Returns nil when nothing matches.
+func (ix *Index) Search(ctx context.Context, query string, limit int) []int {
+	terms := tokenize(query)
+	for _, match := range framePattern.FindAllStringSubmatch(query, -1) {
+		terms = append(terms, "frame:"+match[1])
+	}
+
+	scores := ix.bm25(terms)
+
+	// Blend in semantic similarity when embeddings are available
+	if ix.embedder != nil && len(ix.vectors) > 0 {
+		if err := ix.blend(ctx, query, scores); err != nil {
+			fmt.Println("Embedding search failed, using keywords only:", err)
+		}
+	}
+	if len(scores) == 0 {
+		return nil
+	}
+
+	// Best score first, frame order on ties
+	ranked := make([]int, 0, len(scores))
+	for doc := range scores {
+		ranked = append(ranked, doc)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	// Bring the rest of each matched dialog along
+	seen := make(map[int]bool)
+	var result []int
+	for _, doc := range ranked {
+		if seen[doc] {
+			continue
+		}
+		seen[doc] = true
+		result = append(result, doc)
+		for _, other := range ix.calls[ix.messages[doc].Message["Call-ID"]] {
+			if !seen[other] {
+				seen[other] = true
+				result = append(result, other)
+			}
+		}
+	}
+	return result
+}
+
+// bm25 scores every message containing at least one query term
+func (ix *Index) bm25(terms []string) map[int]float64 {
+	scores := make(map[int]float64)
+	n := float64(len(ix.messages))
+	for _, term := range terms {
+		list := ix.postings[term]
+		if len(list) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-float64(len(list))+0.5)/(float64(len(list))+0.5))
+		for _, p := range list {
+			tf := float64(p.tf)
+			norm := tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(ix.docLen[p.doc])/ix.avgLen))
+			scores[p.doc] += idf * norm
+		}
+	}
+	return scores
+}
+
+// blend combines keyword and embedding scores in place
+// Both parts are scaled to 0..1 and weighted equally
+func (ix *Index) blend(ctx context.Context, query string, scores map[int]float64) error {
+	vectors, err := ix.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return err
+	}
+	if len(vectors) != 1 {
+		return fmt.Errorf("expected 1 query embedding, got %d", len(vectors))
+	}
+
+	maxScore := 0.0
+	for _, score := range scores {
+		maxScore = math.Max(maxScore, score)
+	}
+	for doc, score := range scores {
+		scores[doc] = 0.5 * score / maxScore
+	}
+	for doc, vector := range ix.vectors {
+		if similarity := cosine(vectors[0], vector); similarity > 0 {
+			scores[doc] += 0.5 * similarity
+		}
+	}
+	return nil
+}
+
+// documentText is the searchable text of a message
+func documentText(m database.ProcessedMessage) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s %s %s frame:%d %s", m.Protocol, m.Src_IpAddr, m.Dst_IpAddr, m.Frame_Number, m.Time_Stamp)
+
+	// RFC3339 timestamps also match clock times such as 09:19 or 09:19:50
+	if _, clock, ok := strings.Cut(m.Time_Stamp, "T"); ok && len(clock) >= 8 {
+		fmt.Fprintf(&text, " %s %s", clock[:5], clock[:8])
+	}
+
+	// Fixed key order keeps embedding input stable
+	keys := make([]string, 0, len(m.Message))
+	for key := range m.Message {
+		if !skipFields[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&text, " %s %s", key, m.Message[key])
+	}
+	return text.String()
+}
+
+// tokenize splits text into lower-case search terms
+// Addresses, Call-IDs and clock times stay whole and are also split
+// into their parts, so "alice@10.0.0.5" matches "alice" and "10.0.0.5"
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(".:@-_", r)
+	})
+
+	var terms []string
+	for _, word := range words {
+		word = strings.Trim(word, ".:@-_")
+		if word == "" || stopWords[word] {
+			continue
+		}
+		terms = append(terms, word)
+
+		parts := strings.FieldsFunc(word, func(r rune) bool {
+			return strings.ContainsRune(":@-_", r)
+		})
+		if len(parts) > 1 {
+			for _, part := range parts {
+				if len(part) > 1 && !stopWords[part] {
+					terms = append(terms, part)
+				}
+			}
+		}
+	}
+	return terms
+}
+
+// cosine returns the cosine similarity of two vectors
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
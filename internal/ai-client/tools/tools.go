@@ -0,0 +1,397 @@
+// tools.go
+// This file implements the capture queries the AI can call while answering.
+// Core functionalities:
+// - list_flows: message counts per protocol and host pair
+// - get_messages_by_call_id: every message of a SIP dialog
+// - filter_messages: messages by protocol, IP, frame and time range
+// - count_by_response_code: request methods and response codes per protocol
+// - get_frame: the message decoded from one frame
+//
+// Example scenario:
+//    For "Why did the second call fail?" the model lists the SIP flows,
+//    fetches the dialog by Call-ID and answers from the full messages
+//    instead of the truncated context
+
+// Package tools exposes decoded capture data to the AI as callable functions
+package tools
+
+import (
+	"DeepPacketAI/internal/ai-client/contextbuilder" // Field shortening
+	"DeepPacketAI/internal/ai-client/provider"       // Tool definitions
+	database "DeepPacketAI/internal/storage"         // Decoded message types and filters
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResultChars bounds the text returned by one tool call
+const maxResultChars = 12000
+
+// defaultLimit and maxLimit bound the messages returned by filter_messages
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+// Definitions are the tools offered to the model
+var Definitions = []provider.Tool{
+	{
+		Name:        "list_flows",
+		Description: "List message counts, frame range and time range per protocol, source IP and destination IP.",
+		Parameters: []provider.Parameter{
+			{Name: "protocol", Type: "string", Description: "Only flows of this protocol, e.g. sip, sip_call, http, diameter, rtp_stream, rtcp, dns"},
+		},
+	},
+	{
+		Name:        "get_messages_by_call_id",
+		Description: "Return every decoded SIP message with the given Call-ID header, in frame order.",
+		Parameters: []provider.Parameter{
+			{Name: "call_id", Type: "string", Description: "Value of the SIP Call-ID header", Required: true},
+		},
+	},
+	{
+		Name:        "filter_messages",
+		Description: "Return decoded messages matching all given filters, in frame order.",
+		Parameters: []provider.Parameter{
+			{Name: "protocol", Type: "string", Description: "Protocol, e.g. sip, sip_call, http, diameter, rtp_stream, rtcp, dns"},
+			{Name: "ip", Type: "string", Description: "Source or destination IP address"},
+			{Name: "from", Type: "string", Description: "Earliest timestamp, RFC3339 or HH:MM:SS on the capture day"},
+			{Name: "to", Type: "string", Description: "Latest timestamp, RFC3339 or HH:MM:SS on the capture day"},
+			{Name: "from_frame", Type: "integer", Description: "First frame number"},
+			{Name: "to_frame", Type: "integer", Description: "Last frame number"},
+			{Name: "limit", Type: "integer", Description: fmt.Sprintf("Maximum number of messages, default %d, at most %d", defaultLimit, maxLimit)},
+		},
+	},
+	{
+		Name:        "count_by_response_code",
+		Description: "Count requests by method and responses by status or result code.",
+		Parameters: []provider.Parameter{
+			{Name: "protocol", Type: "string", Description: "Protocol to count", Required: true, Enum: []string{"sip", "http", "diameter", "dns"}},
+		},
+	},
+	{
+		Name:        "get_frame",
+		Description: "Return the decoded message of one frame.",
+		Parameters: []provider.Parameter{
+			{Name: "frame", Type: "integer", Description: "Frame number", Required: true},
+		},
+	},
+}
+
+// Toolbox runs tool calls against the messages of one capture
+type Toolbox struct {
+	messages []database.ProcessedMessage
+}
+
+// New creates a toolbox for the decoded messages of a capture
+func New(messages []database.ProcessedMessage) *Toolbox {
+	return &Toolbox{messages: messages}
+}
+
+// Call runs a tool requested by the model
+// Errors are returned as text so the model can correct its arguments
+func (t *Toolbox) Call(call provider.ToolCall) string {
+	args := arguments{}
+	if strings.TrimSpace(call.Arguments) != "" {
+		if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+			return fmt.Sprintf("error: arguments are not a JSON object: %v", err)
+		}
+	}
+
+	var result string
+	var err error
+	switch call.Name {
+	case "list_flows":
+		result = t.listFlows(args.str("protocol"))
+	case "get_messages_by_call_id":
+		result, err = t.messagesByCallID(args.str("call_id"))
+	case "filter_messages":
+		result, err = t.filterMessages(args)
+	case "count_by_response_code":
+		result, err = t.countByResponseCode(args.str("protocol"))
+	case "get_frame":
+		result, err = t.frame(args)
+	default:
+		err = fmt.Errorf("unknown tool %q", call.Name)
+	}
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return result
+}
+
+// listFlows aggregates messages per protocol and host pair
+func (t *Toolbox) listFlows(protocol string) string {
+	type flow struct {
+		Protocol   string `json:"protocol"`
+		Src        string `json:"src"`
+		Dst        string `json:"dst"`
+		Messages   int    `json:"messages"`
+		FirstFrame uint64 `json:"first_frame"`
+		LastFrame  uint64 `json:"last_frame"`
+		FirstTime  string `json:"first_time"`
+		LastTime   string `json:"last_time"`
+	}
+
+	index := make(map[string]*flow)
+	var flows []*flow
+	for _, m := range t.messages {
+		if protocol != "" && m.Protocol != protocol {
+			continue
+		}
+		key := m.Protocol + " " + m.Src_IpAddr + " " + m.Dst_IpAddr
+		f, ok := index[key]
+		if !ok {
+			f = &flow{Protocol: m.Protocol, Src: m.Src_IpAddr, Dst: m.Dst_IpAddr, FirstFrame: m.Frame_Number, FirstTime: m.Time_Stamp}
+			index[key] = f
+			flows = append(flows, f)
+		}
+		f.Messages++
+		f.LastFrame = m.Frame_Number
+		f.LastTime = m.Time_Stamp
+	}
+	return encode(flows)
+}
+
+// messagesByCallID returns the messages of one SIP dialog
+func (t *Toolbox) messagesByCallID(callID string) (string, error) {
+	if callID == "" {
+		return "", fmt.Errorf("call_id is required")
+	}
+	var result []database.ProcessedMessage
+	for _, m := range t.messages {
+		if m.Message["Call-ID"] == callID {
+			result = append(result, m)
+		}
+	}
+	return encodeMessages(result, len(result)), nil
+}
+
+// filterMessages returns messages matching a database filter
+func (t *Toolbox) filterMessages(args arguments) (string, error) {
+	filter := database.Filter{
+		Protocol: args.str("protocol"),
+		IP:       args.str("ip"),
+		Limit:    defaultLimit,
+	}
+
+	var err error
+	if filter.From, err = t.timeArg(args.str("from")); err != nil {
+		return "", err
+	}
+	if filter.To, err = t.timeArg(args.str("to")); err != nil {
+		return "", err
+	}
+	if from, ok := args.int("from_frame"); ok {
+		filter.FromFrame = uint64(from)
+	}
+	if to, ok := args.int("to_frame"); ok {
+		filter.ToFrame = uint64(to)
+	}
+	if limit, ok := args.int("limit"); ok && limit > 0 {
+		filter.Limit = min(limit, maxLimit)
+	}
+
+	var result []database.ProcessedMessage
+	total := 0
+	for _, m := range t.messages {
+		if !filter.Matches(m) {
+			continue
+		}
+		total++
+		if len(result) < filter.Limit {
+			result = append(result, m)
+		}
+	}
+	return encodeMessages(result, total), nil
+}
+
+// countByResponseCode counts request methods and response codes
+func (t *Toolbox) countByResponseCode(protocol string) (string, error) {
+	var code func(m database.ProcessedMessage) string
+	switch protocol {
+	case "sip":
+		code = sipCode
+	case "http":
+		code = httpCode
+	case "diameter":
+		code = diameterCode
+	case "dns":
+		code = dnsCode
+	default:
+		return "", fmt.Errorf("protocol must be one of sip, http, diameter, dns")
+	}
+
+	counts := make(map[string]int)
+	for _, m := range t.messages {
+		if m.Protocol != protocol {
+			continue
+		}
+		if c := code(m); c != "" {
+			counts[c]++
+		}
+	}
+	return encode(counts), nil
+}
+
+// frame returns the message of one frame
+func (t *Toolbox) frame(args arguments) (string, error) {
+	frame, ok := args.int("frame")
+	if !ok {
+		return "", fmt.Errorf("frame is required")
+	}
+	var result []database.ProcessedMessage
+	for _, m := range t.messages {
+		if m.Frame_Number == uint64(frame) {
+			result = append(result, m)
+		}
+	}
+	if len(result) == 0 {
+		return "", fmt.Errorf("no decoded message in frame %d", frame)
+	}
+	return encodeMessages(result, len(result)), nil
+}
+
+// timeArg parses a time argument
+// HH:MM[:SS] is taken on the day of the first message
+func (t *Toolbox) timeArg(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return ts, nil
+	}
+
+	day := time.Now().UTC()
+	if len(t.messages) > 0 {
+		if first, err := time.Parse(time.RFC3339, t.messages[0].Time_Stamp); err == nil {
+			day = first
+		}
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if clock, err := time.Parse(layout, value); err == nil {
+			return time.Date(day.Year(), day.Month(), day.Day(),
+				clock.Hour(), clock.Minute(), clock.Second(), 0, day.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC3339 or HH:MM:SS", value)
+}
+
+// sipCode returns the method of a request or the status code of a response
+// Example: "INVITE" or "486"
+func sipCode(m database.ProcessedMessage) string {
+	fields := strings.Fields(m.Message["Status"])
+	if len(fields) < 2 {
+		return ""
+	}
+	if fields[0] == "SIP/2.0" {
+		return fields[1]
+	}
+	return fields[0]
+}
+
+// httpCode returns the :status of a response or the :method of a request
+func httpCode(m database.ProcessedMessage) string {
+	if status := m.Message[":status"]; status != "" {
+		return status
+	}
+	return m.Message[":method"]
+}
+
+// diameterCode returns the command of a request or the Result-Code of an answer
+// Example: "request 316" or "answer 316 result 2001"
+func diameterCode(m database.ProcessedMessage) string {
+	if m.Message["IsAnswer"] != "true" {
+		return "request " + m.Message["CommandCode"]
+	}
+	code := "answer " + m.Message["CommandCode"]
+	for i := 1; ; i++ {
+		avp, ok := m.Message[fmt.Sprintf("AVP_%d_Code", i)]
+		if !ok {
+			break
+		}
+		if avp == "268" { // Result-Code
+			value := m.Message[fmt.Sprintf("AVP_%d_ExtendedAttribute_TypedValue", i)]
+			if value == "" {
+				value = m.Message[fmt.Sprintf("AVP_%d_Data", i)]
+			}
+			code += " result " + value
+			break
+		}
+	}
+	return code
+}
+
+// dnsCode returns the query or response code of a DNS message
+func dnsCode(m database.ProcessedMessage) string {
+	if m.Message["QR"] != "true" {
+		return "query"
+	}
+	return "response " + m.Message["ResponseCode"]
+}
+
+// encodeMessages returns shortened messages as JSON within maxResultChars
+// Parameters:
+//   - messages: Messages to return
+//   - total: Number of matching messages, including ones not returned
+func encodeMessages(messages []database.ProcessedMessage, total int) string {
+	short := make([]database.ProcessedMessage, 0, len(messages))
+	for _, m := range messages {
+		s, _ := contextbuilder.Shorten(m)
+		short = append(short, s)
+	}
+
+	// Drop trailing messages until the list fits
+	data, _ := json.Marshal(short)
+	for len(data) > maxResultChars && len(short) > 1 {
+		short = short[:len(short)/2]
+		data, _ = json.Marshal(short)
+	}
+
+	text := string(data)
+	if len(short) < total {
+		text += fmt.Sprintf("\n%d of %d matching messages shown, narrow the filter to see the rest", len(short), total)
+	}
+	return text
+}
+
+// encode returns a value as JSON within maxResultChars
+func encode(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	if len(data) > maxResultChars {
+		return string(data[:maxResultChars]) + "...[truncated]"
+	}
+	return string(data)
+}
+
+// arguments holds the decoded arguments of a tool call
+type arguments map[string]any
+
+// str returns a string argument
+func (a arguments) str(name string) string {
+	switch v := a[name].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// int returns an integer argument
+// Some models send numbers as strings
+func (a arguments) int(name string) (int, bool) {
+	switch v := a[name].(type) {
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		return n, err == nil
+	}
+	return 0, false
+}
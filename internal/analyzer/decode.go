@@ -4,40 +4,51 @@ package decode
 
 // Required imports for packet processing and protocol analysis
 import (
-	decode_diameter "DeepPacketAI/internal/protocols/diameter"
-	decode_dns "DeepPacketAI/internal/protocols/dns"
 	decode_http "DeepPacketAI/internal/protocols/http" // HTTP/2 protocol decoder
-	decode_rtcp "DeepPacketAI/internal/protocols/rtcp"
-	decode_rtp "DeepPacketAI/internal/protocols/rtp"
-	decode_sip "DeepPacketAI/internal/protocols/sip" // SIP protocol decoder
-	"DeepPacketAI/pkg/config"                        // Application configuration
-	"fmt"                                            // Formatted I/O operations
-	"time"                                           // Time-related functions
+	decode_rtp "DeepPacketAI/internal/protocols/rtp"   // RTP stream statistics
+	decode_sip "DeepPacketAI/internal/protocols/sip"   // SIP protocol decoder
+	database "DeepPacketAI/internal/storage"           // Decoded message collection
+	"DeepPacketAI/pkg/config"                          // Application configuration
+	"errors"                                           // Live capture conflict
+	"fmt"                                              // Formatted I/O operations
+	"os"                                               // Standard error for progress output
+	"sync"                                             // Serializes decoding runs
+	"time"                                             // Time-related functions
 
 	"github.com/google/gopacket"        // Core packet processing
 	"github.com/google/gopacket/layers" // Protocol layer definitions
 	"github.com/google/gopacket/pcap"   // Packet capture functionality
-	"github.com/sipcapture/heplify/ownlayers"
 )
 
+// processMu serializes decoding runs
+// Protocol decoders keep per-capture state (e.g. HPACK tables, TCP streams) in
+// package variables. Live capture takes it per packet and sets liveRunning, so
+// Process refuses to decode uploads that would reset the live decoder state
+var processMu sync.Mutex
+
+// liveRunning is set while Capture decodes traffic, guarded by processMu
+var liveRunning bool
+
+// ErrLiveCapture is returned by Process for captures not yet decoded while a
+// live capture is running; stored decodes are still returned
+var ErrLiveCapture = errors.New("a live capture is running, new capture files cannot be decoded until it stops")
+
 // processPcapFile handles the analysis of a single pcap file
 // Parameters:
 //   - file: Path to the pcap file for analysis
-func processPcapFile(file string) {
+//   - total_packets: Packet count of all files, for progress reporting
+//
+// Returns an error if the file cannot be opened
+func processPcapFile(file string, total_packets uint64) error {
 	// Open pcap file for reading
 	// Returns handle for packet operations
 	h, err := pcap.OpenOffline(file)
 	if err != nil {
-		fmt.Println("Error opening", file, "file", "err:", err)
-		return
+		return fmt.Errorf("error opening %s: %v", file, err)
 	}
 	// Ensure file handle is closed after processing
 	defer h.Close()
 
-	// Get total packet count for progress tracking
-	// Enables accurate progress percentage calculation
-	total_packets := totalPackets()
-
 	// Initialize packet counter for sequential processing
 	// Used for maintaining packet order in analysis
 	var frame uint64
@@ -53,178 +64,163 @@ func processPcapFile(file string) {
 		frame++
 
 		// Calculate and display processing progress
-		// Shows percentage of packets processed
+		// Shows percentage of packets processed on stderr so that
+		// batch output on stdout only carries the AI answer
 		progress := float64(frame) / float64(total_packets) * 100
-		fmt.Printf("\rProgress: %.2f%%", progress)
-
-		// Extract IP layer information
-		// Contains source and destination addresses
-		network := packet.NetworkLayer()
-		if network == nil {
-			continue // Skip packets without network layer
-		}
-
-		// Validate IP addresses
-		// Skip packets with invalid addresses (0.0.0.0)
-		if network.NetworkFlow().Dst().String() == "0.0.0.0" &&
-			network.NetworkFlow().Src().String() == "0.0.0.0" {
-			continue
-		}
-
-		// Check for SIP protocol packets
-		// Process VoIP signaling if present
-		sip := packet.Layer(layers.LayerTypeSIP)
-		if sip != nil {
-			// Process SIP packet with metadata
-			decode_sip.Process(
-				sip,                                  // SIP layer data
-				network.NetworkFlow().Src().String(), // Source IP
-				network.NetworkFlow().Dst().String(), // Destination IP
-				packet.Metadata().Timestamp.Format(time.RFC3339), // Timestamp
-				frame, // Packet number
-			)
-			continue
-		}
+		fmt.Fprintf(os.Stderr, "\rProgress: %.2f%%", progress)
 
-		// Check for RTP protocol packets
-		rtp := packet.Layer(ownlayers.LayerTypeRTP)
-		if rtp != nil {
-			// Process RTP packet with metadata
-			decode_rtp.Process(
-				rtp,                                  // RTP layer data
-				network.NetworkFlow().Src().String(), // Source IP
-				network.NetworkFlow().Dst().String(), // Destination IP
-				packet.Metadata().Timestamp.Format(time.RFC3339), // Timestamp
-				frame, // Packet number
-			)
+		// Skip packets outside the -start-time/-end-time window
+		// Frame numbers keep counting so they match the capture file
+		if !config.Input.InTimeWindow(packet.Metadata().Timestamp) {
 			continue
 		}
 
-		// Check for DNS protocol packets
-		dns := packet.Layer(layers.LayerTypeDNS)
-		if dns != nil {
-			// Process RTP packet with metadata
-			decode_dns.Process(
-				dns,                                  // DNS layer data
-				network.NetworkFlow().Src().String(), // Source IP
-				network.NetworkFlow().Dst().String(), // Destination IP
-				packet.Metadata().Timestamp.Format(time.RFC3339), // Timestamp
-				frame, // Packet number
-			)
-			continue
-		}
+		processPacket(packet, frame)
+	}
+	fmt.Fprintln(os.Stderr) // New line after progress display
+	return nil
+}
 
-		// Check for UDP protocol packets
-		udpLayer := packet.Layer(layers.LayerTypeUDP)
-		if udpLayer != nil {
-			// udp, _ := udpLayer.(*layers.UDP)
-			// You might want to filter based on port numbers here if you know them
-			// Example: if udp.DstPort == 5005 || udp.DstPort == 5006 { // Example RTCP ports
-
-			// 3. Extract UDP payload and attempt to decode as RTCP
-			app := packet.ApplicationLayer()
-			if app != nil {
-				rtcpPayload := app.Payload()
-				// Decode Diameter packet
-				decode_rtcp.Process(
-					rtcpPayload,                                      // HTTP/2 frame data
-					network.NetworkFlow().Src().String(),             // Source IP
-					network.NetworkFlow().Dst().String(),             // Destination IP
-					packet.Metadata().Timestamp.Format(time.RFC3339), // Timestamp
-					frame, // Packet number
-				)
-			}
-			continue
-		}
+// processPacket hands one packet to the matching protocol decoder
+// Shared by offline files and live capture
+// Parameters:
+//   - packet: Decoded packet layers
+//   - frame: Frame number of the packet in its capture
+func processPacket(packet gopacket.Packet, frame uint64) {
+	// Extract IP layer information
+	// Contains source and destination addresses
+	network := packet.NetworkLayer()
+	if network == nil {
+		return // Skip packets without network layer
+	}
 
-		// Extract STCP layer for transport protocol
-		// Required for Diameter analysis
-		stcp := packet.Layer(layers.LayerTypeSCTP)
-		if stcp != nil {
-			sctpPkt := stcp.(*layers.SCTP)
-			if sctpPkt.DstPort == 3868 || sctpPkt.SrcPort == 3868 || sctpPkt.DstPort == 1677 || sctpPkt.SrcPort == 1677 {
-				app := packet.ApplicationLayer()
-				if app != nil {
-					diameterPayload := app.Payload()
-					// Decode Diameter packet
-					decode_diameter.Process(
-						diameterPayload,                                  // HTTP/2 frame data
-						network.NetworkFlow().Src().String(),             // Source IP
-						network.NetworkFlow().Dst().String(),             // Destination IP
-						packet.Metadata().Timestamp.Format(time.RFC3339), // Timestamp
-						frame, // Packet number
-					)
-				}
-			}
-			continue // Skip non-SCTP packets
-		}
+	// Validate IP addresses
+	// Skip packets with invalid addresses (0.0.0.0)
+	src := network.NetworkFlow().Src().String()
+	dst := network.NetworkFlow().Dst().String()
+	if dst == "0.0.0.0" && src == "0.0.0.0" {
+		return
+	}
 
-		// Extract TCP layer for transport protocol
-		// Required for Diameter analysis
-		tcp := packet.Layer(layers.LayerTypeTCP)
-		if tcp != nil {
-			tcpPkt := tcp.(*layers.TCP)
-			if tcpPkt.DstPort == 3868 || tcpPkt.SrcPort == 3868 {
-				app := packet.ApplicationLayer()
-				if app != nil {
-					diameterPayload := app.Payload()
-					// Decode Diameter packet
-					decode_diameter.Process(
-						diameterPayload,                                  // HTTP/2 frame data
-						network.NetworkFlow().Src().String(),             // Source IP
-						network.NetworkFlow().Dst().String(),             // Destination IP
-						packet.Metadata().Timestamp.Format(time.RFC3339), // Timestamp
-						frame, // Packet number
-					)
-				}
-			}
-			continue // Skip non-TCP packets
-		}
+	// Reassemble TCP streams before decoding
+	// HTTP, SIP and Diameter messages span segments and segments carry several
+	if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
+		tcp.assemble(
+			network.NetworkFlow(),       // IP addresses
+			tcpLayer.(*layers.TCP),      // TCP segment
+			packet.Metadata().Timestamp, // Timestamp
+			frame,                       // Packet number
+		)
+		return
+	}
 
-		// Process application layer data
-		// Contains protocol-specific content
+	// UDP and SCTP payloads go to the dissector of their port or content
+	d := &datagram{src: src, dst: dst, seen: packet.Metadata().Timestamp, frame: frame}
+	if udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		d.srcPort, d.dstPort, d.payload = uint16(udp.SrcPort), uint16(udp.DstPort), udp.Payload
+		dispatch(transportUDP, d)
+		return
+	}
+	if sctp, ok := packet.Layer(layers.LayerTypeSCTP).(*layers.SCTP); ok {
 		app := packet.ApplicationLayer()
-		if app != nil {
-			data := app.Payload()
-			// Check for non-empty payload
-			if len(data) > 0 {
-				// Process HTTP/2 data with metadata
-				decode_http.Process(
-					data,                                 // HTTP/2 frame data
-					network.NetworkFlow().Src().String(), // Source IP
-					network.NetworkFlow().Dst().String(), // Destination IP
-					packet.Metadata().Timestamp.Format(time.RFC3339), // Timestamp
-					frame, // Packet number
-				)
-			}
-			continue
+		if app == nil {
+			return // No DATA chunk
 		}
+		d.srcPort, d.dstPort, d.payload = uint16(sctp.SrcPort), uint16(sctp.DstPort), app.Payload()
+		dispatch(transportSCTP, d)
 	}
-	fmt.Println() // New line after progress display
 }
 
 // Process initializes and manages the packet analysis workflow
 // Handles file reading and packet processing coordination
-func Process() {
-	// Process each configured pcap file
+// Parameters:
+//   - files: Paths of the pcap files to decode together
+//
+// Returns the decoded messages of all files, or the first error
+// encountered while opening a capture; ErrLiveCapture while Capture runs
+func Process(files []string) ([]database.ProcessedMessage, error) {
+	// Only one capture set is decoded at a time
+	processMu.Lock()
+	defer processMu.Unlock()
+
+	// Reuse a stored decode of the same captures, time window and decoder version
+	id, err := database.CaptureID(files, windowOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading capture files: %v", err)
+	}
+	if messages, err := database.Default.Load(id); err == nil {
+		fmt.Fprintln(os.Stderr, "Using stored decode of capture", id[:12])
+		return messages, nil
+	}
+	if liveRunning {
+		return nil, ErrLiveCapture
+	}
+
+	// Start from clean decoder state and an empty message collection
+	if err := setupDissectors(config.Input.Ports); err != nil {
+		return nil, err
+	}
+	decode_http.Reset()
+	decode_sip.Reset()
+	decode_rtp.Reset()
+	resetReassembly()
+	resetRTPFlows()
+	database.Take()
+
+	// Get total packet count for progress tracking
+	// Enables accurate progress percentage calculation
+	total_packets := totalPackets(files)
+
+	// Process each pcap file
 	// Supports batch analysis of multiple captures
-	for _, file := range config.Input.Files {
-		processPcapFile(file) // Process individual file
+	for _, file := range files {
+		// Process individual file
+		if err := processPcapFile(file, total_packets); err != nil {
+			database.Take() // Drop partial results
+			return nil, err
+		}
+	}
+	tcp.flush()        // Decode data left behind gaps in TCP streams
+	decode_sip.Flush() // Store calls still open at the end
+	decode_rtp.Flush() // Store the quality of media streams
+	messages := database.Take()
+
+	// Keep the result for later analyses of the same captures
+	capture := database.Capture{ID: id, Files: files, Created: time.Now()}
+	if err := database.Default.Save(capture, messages); err != nil {
+		fmt.Fprintln(os.Stderr, "Error storing decoded capture:", err)
 	}
+	return messages, nil
 }
 
-// totalPackets counts packets in all configured pcap files
+// windowOptions describes the configured time window and port mappings for CaptureID
+// The same files decoded with another window or mapping give different messages
+func windowOptions() []string {
+	var options []string
+	if !config.Input.StartTime.IsZero() {
+		options = append(options, "start="+config.Input.StartTime.Format(time.RFC3339))
+	}
+	if !config.Input.EndTime.IsZero() {
+		options = append(options, "end="+config.Input.EndTime.Format(time.RFC3339))
+	}
+	for _, m := range config.Input.Ports {
+		options = append(options, fmt.Sprintf("port=%s:%d-%d", m.Protocol, m.First, m.Last))
+	}
+	return options
+}
+
+// totalPackets counts packets in all given pcap files
 // Used for accurate progress tracking during analysis
-func totalPackets() uint64 {
+func totalPackets(files []string) uint64 {
 	// Initialize packet counter
 	var count uint64 = 0
 
-	// Process each configured file
-	for _, file := range config.Input.Files {
+	// Process each file
+	for _, file := range files {
 		// Open pcap file for counting
 		h, err := pcap.OpenOffline(file)
 		if err != nil {
-			fmt.Println("Error opening", file, "file", "err:", err)
+			fmt.Fprintln(os.Stderr, "Error opening", file, "file", "err:", err)
 			return 0
 		}
 		defer h.Close() // Ensure file handle is closed
@@ -238,8 +234,3 @@ func totalPackets() uint64 {
 
 	return count // Return total packet count
 }
-
-// Check if a packet is a Diameter packet
-func isDiameterPacket(data []byte) bool {
-	return len(data) > 20 && data[0] == 1 // Diameter Version = 1
-}
@@ -0,0 +1,276 @@
+// dissector.go
+// This file chooses the protocol decoder of each UDP and SCTP packet and of each TCP stream.
+// Core functionalities:
+// - Registry of dissectors per transport, each with a name, well-known ports and a heuristic
+// - Port mappings from the -ports option, taking precedence over the well-known ports
+// - RTP and RTCP endpoints announced in SDP, ahead of every port table
+// - Port lookup first, then the heuristics in registration order
+// - RTP of unclaimed UDP flows after several consistent packets
+//
+// Example scenarios:
+// 1. 5G SBI on port 29510:
+//    No port mapping applies, the HTTP/2 heuristic recognises the frames
+//
+// 2. Diameter on port 3869:
+//    -ports "diameter:3869" decodes the port as Diameter on TCP and SCTP
+//
+// 3. Call media on a dynamic port:
+//    "m=audio 30974 RTP/AVP 0 8" in an INVITE makes the packets to and from
+//    that address and port RTP, and port 30975 RTCP
+//
+// 4. Adding a protocol:
+//    A type implementing packetDissector or streamDissector is registered in
+//    init below, the dispatch in processPacket and sniff stays as it is
+
+package decode
+
+import (
+	decode_sip "DeepPacketAI/internal/protocols/sip" // Media endpoints learned from SDP
+	"DeepPacketAI/pkg/config"                        // Port mappings
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Transports dissectors are registered for
+const (
+	transportUDP  = "udp"
+	transportTCP  = "tcp"
+	transportSCTP = "sctp"
+)
+
+// Heuristic results of dissector.match
+const (
+	matchNo   = -1 // Not this protocol
+	matchMore = 0  // More bytes are needed to tell (TCP only)
+	matchYes  = 1  // This protocol
+)
+
+// dissector is a protocol decoder on one transport
+type dissector interface {
+	// name identifies the protocol in -ports mappings, e.g. "sip" or "http2"
+	name() string
+
+	// ports returns the well-known ports of the protocol
+	ports() []uint16
+
+	// match tells from the first bytes of a payload or stream whether they belong
+	// to the protocol: matchYes, matchMore or matchNo
+	match(data []byte) int
+}
+
+// packetDissector decodes UDP and SCTP payloads
+type packetDissector interface {
+	dissector
+
+	// decode stores the messages of one payload
+	decode(d *datagram)
+}
+
+// streamDissector decodes reassembled TCP streams
+type streamDissector interface {
+	dissector
+
+	// start sets the framing and decoding of a stream (next, decode and close)
+	start(s *tcpStream)
+}
+
+// datagram is one UDP or SCTP payload
+type datagram struct {
+	src, dst         string // IP addresses
+	srcPort, dstPort uint16
+	payload          []byte
+	seen             time.Time
+	frame            uint64
+}
+
+// timestamp formats the capture time for the decoders storing RFC3339 strings
+func (d *datagram) timestamp() string {
+	return d.seen.Format(time.RFC3339)
+}
+
+// registry holds the dissectors of one transport
+type registry struct {
+	ordered []dissector          // Heuristics are tried in this order
+	byPort  map[uint16]dissector // Well-known ports and -ports mappings
+}
+
+// dissectors holds the registries by transport
+// Port tables are rebuilt by setupDissectors before each capture, guarded by processMu
+var dissectors = map[string]*registry{
+	transportUDP:  {},
+	transportTCP:  {},
+	transportSCTP: {},
+}
+
+// registerPacket adds a dissector of UDP or SCTP payloads
+// Heuristics run in the order of registration, so more specific checks come first
+func registerPacket(transport string, d packetDissector) {
+	r := dissectors[transport]
+	r.ordered = append(r.ordered, d)
+}
+
+// registerStream adds a dissector of TCP streams, tried like registerPacket
+func registerStream(d streamDissector) {
+	r := dissectors[transportTCP]
+	r.ordered = append(r.ordered, d)
+}
+
+// init registers the dissectors of every supported protocol
+func init() {
+	// UDP: SIP start lines are unambiguous; RTCP is the loosest check and comes last
+	registerPacket(transportUDP, sipDissector{})
+	registerPacket(transportUDP, dnsDissector{})
+	registerPacket(transportUDP, rtpDissector{})
+	registerPacket(transportUDP, rtcpDissector{})
+
+	// SCTP
+	registerPacket(transportSCTP, diameterDissector{})
+
+	// TCP: text protocols before binary framings that accept more byte patterns
+	registerStream(sipDissector{})
+	registerStream(webSocketDissector{})
+	registerStream(httpDissector{})
+	registerStream(http2Dissector{})
+	registerStream(dnsDissector{})
+	registerStream(diameterDissector{})
+}
+
+// setupDissectors builds the port tables from the well-known ports and the -ports option
+// Returns an error naming the mappings no dissector is registered for
+func setupDissectors(mappings []config.PortMapping) error {
+	known := make(map[string]bool)
+	for _, r := range dissectors {
+		r.byPort = make(map[uint16]dissector)
+		for _, d := range r.ordered {
+			known[d.name()] = true
+			for _, port := range d.ports() {
+				r.byPort[port] = d
+			}
+		}
+	}
+
+	// Mappings apply to every transport the protocol is registered for
+	for _, m := range mappings {
+		if !known[m.Protocol] {
+			return fmt.Errorf("unknown protocol %q in port mapping, known protocols: %s", m.Protocol, strings.Join(dissectorNames(), ", "))
+		}
+		for _, r := range dissectors {
+			for _, d := range r.ordered {
+				if d.name() != m.Protocol {
+					continue
+				}
+				for port := int(m.First); port <= int(m.Last); port++ {
+					r.byPort[uint16(port)] = d
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// dissectorNames lists the registered protocol names in alphabetical order
+func dissectorNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, r := range dissectors {
+		for _, d := range r.ordered {
+			if !seen[d.name()] {
+				seen[d.name()] = true
+				names = append(names, d.name())
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// byPort returns the dissector mapped to one of the ports of a packet or stream
+// The lower port is looked up first, servers usually listen on it
+// Returns nil when neither port is mapped
+func byPort(transport string, srcPort uint16, dstPort uint16) dissector {
+	r := dissectors[transport]
+	low, high := srcPort, dstPort
+	if high < low {
+		low, high = high, low
+	}
+	if d, ok := r.byPort[low]; ok {
+		return d
+	}
+	return r.byPort[high]
+}
+
+// heuristic returns the first dissector of a transport whose heuristic accepts data
+// Parameters:
+//   - transport: transportUDP, transportTCP or transportSCTP
+//   - data: Payload, or the first bytes of a stream
+//
+// Returns the dissector with matchYes, or nil with matchMore (TCP only) or matchNo
+func heuristic(transport string, data []byte) (dissector, int) {
+	for _, d := range dissectors[transport].ordered {
+		switch d.match(data) {
+		case matchYes:
+			return d, matchYes
+		case matchMore:
+			if transport == transportTCP {
+				// Wait for more of the stream rather than let a later heuristic take it
+				return nil, matchMore
+			}
+		}
+	}
+	return nil, matchNo
+}
+
+// dispatch hands a UDP or SCTP payload to its dissector
+func dispatch(transport string, d *datagram) {
+	if len(d.payload) == 0 {
+		return
+	}
+	var found dissector
+	if transport == transportUDP {
+		found = announced(d)
+	}
+	if found == nil {
+		found = byPort(transport, d.srcPort, d.dstPort)
+	}
+	if found == nil {
+		found, _ = heuristic(transport, d.payload)
+	}
+	if found == nil && transport == transportUDP {
+		// Unclaimed UDP may be RTP of a call whose signaling was not captured
+		for _, packet := range learnRTP(d) {
+			rtpDissector{}.decode(packet)
+		}
+		return
+	}
+	if found != nil {
+		found.(packetDissector).decode(d)
+	}
+}
+
+// announced returns the RTP or RTCP dissector of a UDP packet sent to or from
+// an endpoint announced in SDP, the destination is looked up first
+// Returns nil for other packets, and for packets on an RTCP endpoint that are
+// not RTCP, so they go through the port tables and heuristics
+func announced(d *datagram) dissector {
+	m, ok := mediaOf(d)
+	switch {
+	case !ok:
+		return nil
+	case m.Kind == "rtp":
+		return rtpDissector{} // Multiplexed RTCP is told apart by decode
+	case isRTCP(d.payload):
+		return rtcpDissector{}
+	}
+	return nil
+}
+
+// mediaOf returns the SDP announcement of the destination or, failing that, the
+// source endpoint of a UDP packet
+func mediaOf(d *datagram) (decode_sip.Media, bool) {
+	if m, ok := decode_sip.MediaAt(d.dst, d.dstPort); ok {
+		return m, true
+	}
+	return decode_sip.MediaAt(d.src, d.srcPort)
+}
@@ -0,0 +1,106 @@
+package decode
+
+import (
+	database "DeepPacketAI/internal/storage"
+	"DeepPacketAI/pkg/config"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/http2"
+)
+
+// dnsQuery builds a DNS query for name
+func dnsQuery(t *testing.T, name string) []byte {
+	t.Helper()
+	dns := &layers.DNS{ID: 0x1d2e, RD: true, QDCount: 1,
+		Questions: []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestPortMappings checks that -ports mappings take precedence over the
+// well-known ports and the heuristics
+func TestPortMappings(t *testing.T) {
+	options := "OPTIONS sip:bob@b.example SIP/2.0\r\nVia: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1\r\n" +
+		"From: <sip:alice@a.example>;tag=A\r\nTo: <sip:bob@b.example>\r\nCall-ID: ping\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n"
+
+	tests := []struct {
+		name     string
+		mappings []config.PortMapping
+		port     uint16
+		payload  []byte
+		want     string // Protocols of the stored messages
+	}{
+		{"SIP heuristic", nil, 41000, []byte(options), "[sip]"},
+		{"mapping overrides the SIP heuristic", []config.PortMapping{{Protocol: "dns", First: 41000, Last: 41010}}, 41000, []byte(options), "[]"},
+		{"DNS on an unknown port", nil, 5353, dnsQuery(t, "mmec01.mmegi8001.mme.epc.mnc001.mcc001.3gppnetwork.org"), "[]"},
+		{"DNS mapped to a port", []config.PortMapping{{Protocol: "dns", First: 5353, Last: 5353}}, 5353, dnsQuery(t, "mmec01.mmegi8001.mme.epc.mnc001.mcc001.3gppnetwork.org"), "[dns]"},
+		{"mapping overrides the well-known port", []config.PortMapping{{Protocol: "dns", First: 5060, Last: 5060}}, 5060, dnsQuery(t, "pcscf.ims.mnc001.mcc001.3gppnetwork.org"), "[dns]"},
+	}
+	for _, tt := range tests {
+		if err := setupDissectors(tt.mappings); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resetRTPFlows()
+		database.Take()
+		dispatch(transportUDP, &datagram{src: "10.0.0.1", dst: "10.0.0.2", srcPort: 50000, dstPort: tt.port,
+			payload: tt.payload, seen: time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC), frame: 1})
+
+		var got []string
+		for _, m := range database.Take() {
+			got = append(got, m.Protocol)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("%s: stored %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestPortMappingStream checks that a mapping selects the dissector of a TCP
+// stream on the well-known port of another protocol
+func TestPortMappingStream(t *testing.T) {
+	for _, mapped := range []bool{false, true} {
+		resetDecoders(t)
+		if mapped {
+			if err := setupDissectors([]config.PortMapping{{Protocol: "http2", First: 3868, Last: 3868}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		conn := newTCPConn(40000, 3868)
+		client := newHTTP2Writer()
+		client.out.WriteString(http2.ClientPreface)
+		client.headers(1, true, ":method", "GET", ":path", "/nudm-sdm/v2/imsi-001010000000001/am-data")
+		conn.send(conn.segment(false, client.take()))
+
+		want := 0 // Not a Diameter message, dropped
+		if mapped {
+			want = 1
+		}
+		if got := len(decoded("http")); got != want {
+			t.Errorf("mapped %v: %d HTTP messages, want %d", mapped, got, want)
+		}
+	}
+}
+
+// TestUnknownPortMapping checks that a mapping naming no registered dissector is refused
+func TestUnknownPortMapping(t *testing.T) {
+	err := setupDissectors([]config.PortMapping{{Protocol: "sip", First: 5080, Last: 5080}, {Protocol: "h323", First: 1720, Last: 1720}})
+	if err == nil {
+		t.Fatal("mapping of h323 accepted")
+	}
+	for _, want := range []string{`"h323"`, "diameter, dns, http, http2, rtcp, rtp, sip, websocket"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not name %s", err, want)
+		}
+	}
+	if err := setupDissectors(nil); err != nil {
+		t.Fatal(err)
+	}
+}
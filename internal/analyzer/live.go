@@ -0,0 +1,202 @@
+// live.go
+// This file implements live analysis from a network interface.
+// Core functionalities:
+// - Opens an interface with snaplen, promiscuous mode and BPF filter
+// - Feeds captured packets through the same protocol decoders as pcap files
+// - Keeps decoded messages in a rolling window for the AI
+// - Stops after a duration, a packet limit or on cancellation
+//
+// Example scenarios:
+// 1. Watch SIP signaling on eth0:
+//    -live eth0 -bpf "udp port 5060" opens the web interface on the rolling window
+//
+// 2. Test without an interface:
+//    -replay capture.pcap feeds a file through the live pipeline
+
+package decode
+
+import (
+	decode_http "DeepPacketAI/internal/protocols/http" // HTTP/2 protocol decoder
+	decode_rtp "DeepPacketAI/internal/protocols/rtp"   // RTP stream statistics
+	decode_sip "DeepPacketAI/internal/protocols/sip"   // SIP call tracking
+	database "DeepPacketAI/internal/storage"           // Decoded message collection
+	"DeepPacketAI/pkg/config"                          // Port mappings
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// PacketSource supplies packets to Capture
+// *gopacket.PacketSource implements it for pcap handles and files;
+// tests can inject any other source
+type PacketSource interface {
+	// NextPacket returns the next packet, io.EOF when the source is exhausted
+	NextPacket() (gopacket.Packet, error)
+}
+
+// LiveOptions configures a live capture
+type LiveOptions struct {
+	Interface   string        // Network interface, e.g. eth0
+	Filter      string        // BPF filter expression, all traffic when empty
+	Snaplen     int32         // Bytes captured per packet
+	Promiscuous bool          // Capture traffic not addressed to this host
+	Duration    time.Duration // Stop after this long, 0 for no limit
+	PacketLimit uint64        // Stop after this many packets, 0 for no limit
+}
+
+// readTimeout bounds how long a live read blocks so cancellation is noticed
+const readTimeout = 500 * time.Millisecond
+
+// Read errors other than timeouts, e.g. the interface going down, are retried
+// after a growing pause; Capture gives up after maxReadErrors in a row
+const (
+	maxReadErrors = 5
+	readBackoff   = 10 * time.Millisecond // Doubled after every failed read
+)
+
+// progressInterval is how often the packet count is written to stderr
+const progressInterval = 250 * time.Millisecond
+
+// OpenLive opens a network interface for capture
+// Parameters:
+//   - opts: Interface, snaplen, promiscuous mode and BPF filter
+//
+// Returns the packet source and a function closing the interface
+func OpenLive(opts LiveOptions) (PacketSource, func(), error) {
+	inactive, err := pcap.NewInactiveHandle(opts.Interface)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening interface %s: %v", opts.Interface, err)
+	}
+	defer inactive.CleanUp()
+
+	if err := inactive.SetSnapLen(int(opts.Snaplen)); err != nil {
+		return nil, nil, fmt.Errorf("error setting snaplen: %v", err)
+	}
+	if err := inactive.SetPromisc(opts.Promiscuous); err != nil {
+		return nil, nil, fmt.Errorf("error setting promiscuous mode: %v", err)
+	}
+	if err := inactive.SetTimeout(readTimeout); err != nil {
+		return nil, nil, fmt.Errorf("error setting read timeout: %v", err)
+	}
+
+	h, err := inactive.Activate()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error activating capture on %s: %v", opts.Interface, err)
+	}
+	if opts.Filter != "" {
+		if err := h.SetBPFFilter(opts.Filter); err != nil {
+			h.Close()
+			return nil, nil, fmt.Errorf("invalid BPF filter %q: %v", opts.Filter, err)
+		}
+	}
+
+	return gopacket.NewPacketSource(h, h.LinkType()), h.Close, nil
+}
+
+// OpenReplay opens a capture file as a live packet source
+// Used to exercise live mode without a network interface
+func OpenReplay(file string) (PacketSource, func(), error) {
+	h, err := pcap.OpenOffline(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening %s: %v", file, err)
+	}
+	return gopacket.NewPacketSource(h, h.LinkType()), h.Close, nil
+}
+
+// Capture decodes packets from a source into a rolling window
+// Parameters:
+//   - ctx: Cancels the capture (e.g. Ctrl-C)
+//   - source: Live interface, replayed file or injected packets
+//   - opts: Duration and packet limit
+//   - window: Receives the decoded messages
+//
+// Returns nil when a limit is reached, the source ends or ctx is cancelled,
+// and an error when a -ports mapping names an unknown protocol or the source
+// keeps failing; the messages decoded until then stay in the window
+// Process refuses to decode new capture files until Capture returns
+func Capture(ctx context.Context, source PacketSource, opts LiveOptions, window *database.Window) error {
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	// Live HPACK, SIP call and TCP stream state starts empty like a new file
+	processMu.Lock()
+	if err := setupDissectors(config.Input.Ports); err != nil {
+		processMu.Unlock()
+		return err
+	}
+	decode_http.Reset()
+	decode_sip.Reset()
+	decode_rtp.Reset()
+	resetReassembly()
+	resetRTPFlows()
+	liveRunning = true
+	processMu.Unlock()
+
+	var frame uint64
+	var readErr error
+	var failures int
+	var progressed time.Time
+	for ctx.Err() == nil {
+		if opts.PacketLimit > 0 && frame >= opts.PacketLimit {
+			break
+		}
+
+		packet, err := source.NextPacket()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrClosedPipe) {
+			break // End of a replayed file or closed handle
+		}
+		if errors.Is(err, pcap.NextErrorTimeoutExpired) {
+			continue // No traffic within readTimeout, check ctx again
+		}
+		if err != nil {
+			// Retry a few times, the source may recover
+			failures++
+			if failures == maxReadErrors {
+				readErr = fmt.Errorf("error reading packets: %v", err)
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(readBackoff << (failures - 1)):
+			}
+			continue
+		}
+		failures = 0
+
+		frame++
+		if now := time.Now(); now.Sub(progressed) >= progressInterval {
+			fmt.Fprintf(os.Stderr, "\rCaptured: %d packets, %d messages", frame, window.Total())
+			progressed = now
+		}
+
+		// Decoders keep package state, decode one packet at a time
+		processMu.Lock()
+		processPacket(packet, frame)
+		messages := database.Take()
+		processMu.Unlock()
+
+		window.Add(messages...)
+	}
+
+	// Decode data left behind gaps in TCP streams and store calls still open
+	processMu.Lock()
+	tcp.flush()
+	decode_sip.Flush()
+	decode_rtp.Flush()
+	messages := database.Take()
+	liveRunning = false
+	processMu.Unlock()
+	window.Add(messages...)
+
+	fmt.Fprintf(os.Stderr, "\rCaptured: %d packets, %d messages\n", frame, window.Total())
+	return readErr
+}
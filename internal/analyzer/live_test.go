@@ -0,0 +1,164 @@
+package decode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	database "DeepPacketAI/internal/storage"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// fakeSource replays prepared packets and runs onPacket before handing out each one
+type fakeSource struct {
+	packets  []gopacket.Packet
+	onPacket func(n int)
+	n        int
+}
+
+func (f *fakeSource) NextPacket() (gopacket.Packet, error) {
+	if f.n == len(f.packets) {
+		return nil, io.EOF
+	}
+	if f.onPacket != nil {
+		f.onPacket(f.n)
+	}
+	f.n++
+	return f.packets[f.n-1], nil
+}
+
+// sipPacket builds an Ethernet frame carrying a SIP message over UDP port 5060
+func sipPacket(t *testing.T, src, dst net.IP, seen time.Time, message string) gopacket.Packet {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	udp := &layers.UDP{SrcPort: 5060, DstPort: 5060}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, eth, ip, udp, gopacket.Payload(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = seen
+	packet.Metadata().CaptureLength = len(buf.Bytes())
+	packet.Metadata().Length = len(buf.Bytes())
+	return packet
+}
+
+// busyCall formats a request or response of the INVITE transaction of call "busy"
+func busyCall(startLine, method, toTag string) string {
+	to := "<sip:bob@b.example>"
+	if toTag != "" {
+		to += ";tag=" + toTag
+	}
+	return fmt.Sprintf("%s\r\nVia: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1\r\nFrom: <sip:alice@a.example>;tag=A\r\n"+
+		"To: %s\r\nCall-ID: busy\r\nCSeq: 1 %s\r\nContent-Length: 0\r\n\r\n", startLine, to, method)
+}
+
+// TestCaptureReplay drives Capture with a rejected call and checks the window,
+// and that uploads are refused while the capture runs
+func TestCaptureReplay(t *testing.T) {
+	alice, bob := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	source := &fakeSource{packets: []gopacket.Packet{
+		sipPacket(t, alice, bob, start, busyCall("INVITE sip:bob@b.example SIP/2.0", "INVITE", "")),
+		sipPacket(t, bob, alice, start.Add(50*time.Millisecond), busyCall("SIP/2.0 486 Busy Here", "INVITE", "B")),
+		sipPacket(t, alice, bob, start.Add(55*time.Millisecond), busyCall("ACK sip:bob@b.example SIP/2.0", "ACK", "B")),
+	}}
+
+	upload := filepath.Join(t.TempDir(), "upload.pcap")
+	if err := os.WriteFile(upload, []byte("not decoded before"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var uploadErr error
+	source.onPacket = func(n int) {
+		if n == 1 {
+			_, uploadErr = Process([]string{upload})
+		}
+	}
+
+	window := database.NewWindow(0, 0)
+	if err := Capture(context.Background(), source, LiveOptions{}, window); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(uploadErr, ErrLiveCapture) {
+		t.Errorf("Process during capture: err = %v, want ErrLiveCapture", uploadErr)
+	}
+
+	messages := window.Messages()
+	var got []string
+	for _, m := range messages {
+		got = append(got, fmt.Sprintf("%s %d %s", m.Protocol, m.Frame_Number, m.Src_IpAddr))
+	}
+	// The call is recorded with its final response
+	want := []string{"sip 1 10.0.0.1", "sip 2 10.0.0.2", "sip_call 2 10.0.0.1", "sip 3 10.0.0.1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("window = %q, want %q", got, want)
+	}
+	if call := messages[2].Message; call["Call-ID"] != "busy" || call["disposition"] != "busy" {
+		t.Errorf("call record = %v", call)
+	}
+	if window.Total() != 4 {
+		t.Errorf("Total = %d, want 4", window.Total())
+	}
+
+	// Once the capture is over uploads are decoded again
+	if _, err := Process([]string{upload}); errors.Is(err, ErrLiveCapture) {
+		t.Errorf("Process after capture: err = %v", err)
+	}
+}
+
+// flakySource fails the first failures reads with err, then reads from PacketSource
+type flakySource struct {
+	PacketSource
+	failures int
+	err      error
+}
+
+func (f *flakySource) NextPacket() (gopacket.Packet, error) {
+	if f.failures > 0 {
+		f.failures--
+		return nil, f.err
+	}
+	return f.PacketSource.NextPacket()
+}
+
+// TestCaptureReadErrors checks that Capture rides out a few read errors and
+// returns the error when the source keeps failing
+func TestCaptureReadErrors(t *testing.T) {
+	alice, bob := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		failures int
+		wantErr  bool
+		want     int // Messages in the window
+	}{
+		{"transient", maxReadErrors - 1, false, 1},
+		{"interface gone", 1000, true, 0},
+	}
+	for _, tt := range tests {
+		down := errors.New("eth0: The interface went down")
+		source := &flakySource{failures: tt.failures, err: down, PacketSource: &fakeSource{packets: []gopacket.Packet{
+			sipPacket(t, alice, bob, start, busyCall("OPTIONS sip:bob@b.example SIP/2.0", "OPTIONS", "")),
+		}}}
+
+		window := database.NewWindow(0, 0)
+		err := Capture(context.Background(), source, LiveOptions{}, window)
+		if (err != nil) != tt.wantErr || (err != nil && !strings.Contains(err.Error(), down.Error())) {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := len(window.Messages()); got != tt.want {
+			t.Errorf("%s: %d messages, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// protocols.go
+// This file connects the protocol decoders to the dissector registry.
+// Core functionalities:
+// - Well-known ports and content heuristics of SIP, DNS, RTP, RTCP, HTTP and Diameter
+// - Strict RTCP header check for UDP flows that were neither announced nor mapped
+// - RTP flow check over several packets for the same flows, after every other check
+// - Decoding of UDP and SCTP payloads with the protocol packages
+// - Framing of TCP streams: SIP, WebSocket, HTTP/1, HTTP/2, DNS and Diameter
+//
+// Example scenarios:
+// 1. SIP on UDP port 5080:
+//    The start line "INVITE sip:bob@example.com SIP/2.0" is recognised on any port
+//
+// 2. Media of a call:
+//    Ports announced in the SDP of the INVITE and its answer are decoded as RTP
+//    and RTCP, see announced in dissector.go
+//
+// 3. Media on a known port range:
+//    -ports "rtp:10000-20000" decodes RTP there; RTCP multiplexed on the same
+//    ports (RFC 5761) is still decoded as RTCP
+//
+// 4. Media of a call whose signaling was not captured:
+//    Five PCMA packets in a row with one SSRC and increasing sequence numbers
+//    make the flow RTP, the five packets included
+
+package decode
+
+import (
+	decode_diameter "DeepPacketAI/internal/protocols/diameter" // Diameter on SCTP and TCP
+	decode_dns "DeepPacketAI/internal/protocols/dns"           // DNS on UDP and TCP
+	decode_http "DeepPacketAI/internal/protocols/http"         // HTTP/1 and HTTP/2
+	decode_rtcp "DeepPacketAI/internal/protocols/rtcp"         // RTCP reports
+	decode_rtp "DeepPacketAI/internal/protocols/rtp"           // RTP media
+	decode_sip "DeepPacketAI/internal/protocols/sip"           // SIP on UDP and TCP
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// sipDissector decodes SIP on UDP and TCP
+type sipDissector struct{}
+
+func (sipDissector) name() string    { return "sip" }
+func (sipDissector) ports() []uint16 { return []uint16{5060} }
+
+// match accepts a SIP request or status line
+// "OPTIONS " may start SIP or HTTP/1, the rest of the line tells
+func (sipDissector) match(data []byte) int {
+	switch {
+	case decode_sip.LooksLikeSIP(data):
+		return matchYes
+	case bytes.HasPrefix(data, []byte("OPTIONS ")) && !bytes.Contains(data, []byte("\r\n")):
+		return matchMore
+	}
+	return matchNo
+}
+
+// decode stores one SIP message, CRLF keep-alives (RFC 5626 section 4.4.1) are skipped
+func (sipDissector) decode(d *datagram) {
+	if len(bytes.TrimLeft(d.payload, "\r\n")) == 0 {
+		return
+	}
+	decode_sip.Process(d.payload, d.src, d.dst, d.seen, d.frame)
+}
+
+// start cuts the stream at Content-Length
+func (sipDissector) start(s *tcpStream) {
+	s.next = sipMessage
+	s.decode = s.decodeSIP("TCP")
+}
+
+// webSocketDissector decodes SIP over WebSocket whose handshake was not captured
+// Connections with a captured handshake switch to WebSocket from HTTP/1
+type webSocketDissector struct{}
+
+func (webSocketDissector) name() string    { return "websocket" }
+func (webSocketDissector) ports() []uint16 { return nil }
+
+func (webSocketDissector) match(data []byte) int {
+	if wsCarriesSIP(data) {
+		return matchYes
+	}
+	return matchNo
+}
+
+func (webSocketDissector) start(s *tcpStream) {
+	s.startWebSocket()
+}
+
+// httpDissector decodes HTTP/1.x requests and responses
+type httpDissector struct{}
+
+func (httpDissector) name() string    { return "http" }
+func (httpDissector) ports() []uint16 { return nil }
+
+func (httpDissector) match(data []byte) int {
+	if decode_http.LooksLikeHTTP1(data) {
+		return matchYes
+	}
+	return matchNo
+}
+
+// start cuts the stream into messages; an answered WebSocket upgrade switches
+// the connection to WebSocket framing, e.g. SIP from a WebRTC client
+func (httpDissector) start(s *tcpStream) {
+	s.next = func(data []byte) int {
+		return decode_http.HTTP1Length(data, s.src, s.dst, s.srcPort, s.dstPort)
+	}
+	s.decode = func(p []byte, seen time.Time, frame uint64) {
+		decode_http.ProcessHTTP1(p, s.src, s.dst, s.srcPort, s.dstPort, seen, frame)
+		if decode_http.WebSocketUpgrade(p) {
+			s.startWebSocket()
+		}
+	}
+	s.close = func(rest []byte) {
+		decode_http.CloseHTTP1(rest, s.src, s.dst, s.srcPort, s.dstPort, s.lastSeen, s.owner.frame)
+	}
+}
+
+// http2Dissector decodes HTTP/2 frames, e.g. 5G SBI
+type http2Dissector struct{}
+
+func (http2Dissector) name() string    { return "http2" }
+func (http2Dissector) ports() []uint16 { return nil }
+
+// match accepts the client preface or a valid frame header of a defined type
+// Extension frames have no header rule, so they cannot tell HTTP/2 from other bytes
+func (http2Dissector) match(data []byte) int {
+	if len(data) >= http2HeaderLen && int(data[3]) >= len(http2Rules) && !bytes.HasPrefix(http2Preface, data[:4]) {
+		return matchNo
+	}
+	switch n := http2Frame(data); {
+	case n > 0:
+		return matchYes
+	case n == 0:
+		return matchMore // Too short to tell
+	}
+	return matchNo
+}
+
+func (http2Dissector) start(s *tcpStream) {
+	s.next = http2Frame
+	s.decode = func(p []byte, seen time.Time, frame uint64) {
+		decode_http.Process(p, s.src, s.dst, s.srcPort, s.dstPort, seen, frame)
+	}
+	s.close = func([]byte) {
+		decode_http.Close(s.src, s.dst, s.srcPort, s.dstPort)
+	}
+}
+
+// dnsDissector decodes DNS on UDP and TCP
+type dnsDissector struct{}
+
+func (dnsDissector) name() string          { return "dns" }
+func (dnsDissector) ports() []uint16       { return []uint16{53} }
+func (dnsDissector) match(data []byte) int { return matchNo } // Port only
+
+func (dnsDissector) decode(d *datagram) {
+	storeDNS(d.payload, d.src, d.dst, d.seen, d.frame)
+}
+
+// start cuts the stream at the two-byte message length (RFC 1035 section 4.2.2)
+func (dnsDissector) start(s *tcpStream) {
+	s.next = func(data []byte) int {
+		if len(data) < 2 {
+			return 0
+		}
+		return 2 + int(binary.BigEndian.Uint16(data))
+	}
+	s.decode = func(p []byte, seen time.Time, frame uint64) {
+		storeDNS(p[2:], s.src, s.dst, seen, frame)
+	}
+}
+
+// storeDNS decodes one DNS message, undecodable messages are skipped
+func storeDNS(p []byte, src string, dst string, seen time.Time, frame uint64) {
+	dns := &layers.DNS{}
+	if err := dns.DecodeFromBytes(p, gopacket.NilDecodeFeedback); err != nil {
+		return
+	}
+	decode_dns.Process(dns, src, dst, seen.Format(time.RFC3339), frame)
+}
+
+// rtpDissector decodes RTP media on ports announced in SDP or mapped with -ports
+// RTP has no reliable signature in a single packet, so match takes nothing;
+// other flows are checked over several packets by learnRTP
+type rtpDissector struct{}
+
+func (rtpDissector) name() string          { return "rtp" }
+func (rtpDissector) ports() []uint16       { return nil }
+func (rtpDissector) match(data []byte) int { return matchNo }
+
+// decode adds one RTP packet to its stream, RTCP sharing the port goes to the RTCP decoder
+// The call and the payload type names come from the SDP announcing the endpoint
+func (rtpDissector) decode(d *datagram) {
+	if isRTCP(d.payload) {
+		rtcpDissector{}.decode(d)
+		return
+	}
+	var session decode_rtp.Session
+	if m, ok := mediaOf(d); ok {
+		session = decode_rtp.Session{CallID: m.CallID, Media: m.Type, Codecs: m.Codecs}
+	}
+	decode_rtp.Process(d.payload, d.src, d.dst, d.srcPort, d.dstPort, d.seen, d.frame, session)
+}
+
+// RTP flow heuristic limits
+const (
+	rtpConfirmPackets = 5  // Consistent packets in a row that make a flow RTP
+	rtpMaxGap         = 10 // Largest sequence number step counted as consistent, lost packets included
+)
+
+// rtpFlow is the RTP heuristic state of one direction of a UDP flow
+type rtpFlow struct {
+	confirmed bool        // The flow is RTP, its packets are decoded directly
+	ssrc      uint32      // SSRC of the held packets
+	seq       uint16      // Sequence number of the last held packet
+	held      []*datagram // Consistent packets so far, decoded once the flow is confirmed
+}
+
+// rtpFlows holds the flows checked by learnRTP, by "src:port>dst:port"
+// Replaced by resetRTPFlows before each capture, guarded by processMu
+var rtpFlows = make(map[string]*rtpFlow)
+
+// resetRTPFlows discards the RTP flows of the previous capture
+func resetRTPFlows() {
+	rtpFlows = make(map[string]*rtpFlow)
+}
+
+// learnRTP checks a UDP packet no port, announcement or heuristic claimed for RTP
+// A flow is RTP once rtpConfirmPackets packets in a row have version 2, a static
+// audio payload type, the same SSRC and sequence numbers increasing by 1 to rtpMaxGap
+// Returns the packets to decode as RTP: none while the flow is checked or is not RTP,
+// the held packets when this one confirms the flow, and this one afterwards
+func learnRTP(d *datagram) []*datagram {
+	key := fmt.Sprintf("%s:%d>%s:%d", d.src, d.srcPort, d.dst, d.dstPort)
+	flow, ok := rtpFlows[key]
+	if !ok {
+		flow = &rtpFlow{}
+		rtpFlows[key] = flow
+	}
+	if flow.confirmed {
+		return []*datagram{d}
+	}
+
+	ssrc, seq, ok := audioRTPHeader(d.payload)
+	if !ok {
+		flow.held = nil
+		return nil
+	}
+	if step := seq - flow.seq; len(flow.held) > 0 && (ssrc != flow.ssrc || step == 0 || step > rtpMaxGap) {
+		flow.held = nil // Start over from this packet
+	}
+	held := *d
+	held.payload = bytes.Clone(d.payload) // The capture may reuse the packet buffer
+	flow.ssrc, flow.seq, flow.held = ssrc, seq, append(flow.held, &held)
+	if len(flow.held) < rtpConfirmPackets {
+		return nil
+	}
+	packets := flow.held
+	flow.confirmed, flow.held = true, nil
+	return packets
+}
+
+// audioRTPHeader reads the SSRC and sequence number of a version 2 RTP packet
+// with a static audio payload type (RFC 3551 section 6) and a header that fits
+// Returns false for anything else, dynamic payload types included
+func audioRTPHeader(data []byte) (uint32, uint16, bool) {
+	if len(data) < 12 || data[0]>>6 != 2 {
+		return 0, 0, false
+	}
+	codec, ok := decode_rtp.StaticPayloadTypes[data[1]&0x7f]
+	if !ok || !strings.HasSuffix(codec, "/8000") { // Video payload types run at 90000
+		return 0, 0, false
+	}
+	length := 12 + 4*int(data[0]&0x0f) // CSRC list
+	if data[0]&0x10 != 0 {
+		if len(data) < length+4 {
+			return 0, 0, false
+		}
+		length += 4 + 4*int(binary.BigEndian.Uint16(data[length+2:length+4]))
+	}
+	if length > len(data) {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(data[8:12]), binary.BigEndian.Uint16(data[2:4]), true
+}
+
+// rtcpDissector decodes RTCP reports
+type rtcpDissector struct{}
+
+func (rtcpDissector) name() string    { return "rtcp" }
+func (rtcpDissector) ports() []uint16 { return nil }
+
+// match accepts a datagram that is a valid compound RTCP packet, see validRTCP
+func (rtcpDissector) match(data []byte) int {
+	if validRTCP(data) {
+		return matchYes
+	}
+	return matchNo
+}
+
+// decode stores one compound RTCP packet with the call of the SDP announcing its port
+func (rtcpDissector) decode(d *datagram) {
+	var callID string
+	if m, ok := mediaOf(d); ok {
+		callID = m.CallID
+	}
+	decode_rtcp.Process(d.payload, d.src, d.dst, d.seen, d.frame, callID)
+}
+
+// isRTCP reports whether data starts with version 2 and an RTCP packet type,
+// 192 to 223, a range RTP sharing the port does not use (RFC 5761 section 4)
+func isRTCP(data []byte) bool {
+	return len(data) >= 8 && data[0]>>6 == 2 && data[1] >= 192 && data[1] <= 223
+}
+
+// validRTCP reports whether data is a compound RTCP packet (RFC 3550 appendix A.2)
+// Used on ports nothing is known about, where the loose isRTCP check takes RTP
+// and other UDP traffic for RTCP:
+//   - The first packet is SR or RR, or transport or payload-specific feedback
+//     as sent alone in reduced-size RTCP (RFC 5506), and is not padded
+//   - Every packet has version 2 and a packet type from 192 to 223
+//   - The packet lengths add up to the datagram length exactly
+//   - Only the last packet may be padded
+func validRTCP(data []byte) bool {
+	if !isRTCP(data) || data[0]&0x20 != 0 {
+		return false
+	}
+	switch data[1] {
+	case 200, 201, 205, 206: // SR, RR, RTPFB, PSFB
+	default:
+		return false
+	}
+	for offset := 0; offset < len(data); {
+		rest := data[offset:]
+		if len(rest) < 4 || rest[0]>>6 != 2 || rest[1] < 192 || rest[1] > 223 {
+			return false
+		}
+		length := 4 * (int(binary.BigEndian.Uint16(rest[2:4])) + 1)
+		if length > len(rest) || (rest[0]&0x20 != 0 && length != len(rest)) {
+			return false
+		}
+		offset += length
+	}
+	return true
+}
+
+// diameterDissector decodes Diameter on SCTP and TCP
+type diameterDissector struct{}
+
+func (diameterDissector) name() string    { return "diameter" }
+func (diameterDissector) ports() []uint16 { return []uint16{3868} }
+
+// match accepts a version 1 header with an aligned length and clear reserved flags
+func (diameterDissector) match(data []byte) int {
+	if len(data) == 0 || diameterMessage(data) < 0 || data[0] != 1 {
+		return matchNo
+	}
+	if len(data) < diameterHeaderLen {
+		return matchMore
+	}
+	if data[4]&0x0f != 0 {
+		return matchNo // Reserved command flag bits
+	}
+	return matchYes
+}
+
+func (diameterDissector) decode(d *datagram) {
+	decode_diameter.Process(d.payload, d.src, d.dst, d.timestamp(), d.frame)
+}
+
+func (diameterDissector) start(s *tcpStream) {
+	s.next = diameterMessage
+	s.decode = func(p []byte, seen time.Time, frame uint64) {
+		decode_diameter.Process(p, s.src, s.dst, seen.Format(time.RFC3339), frame)
+	}
+}
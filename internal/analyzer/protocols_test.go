@@ -0,0 +1,95 @@
+package decode
+
+import (
+	decode_rtp "DeepPacketAI/internal/protocols/rtp"
+	database "DeepPacketAI/internal/storage"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// rtpPacket builds an RTP packet with 160 bytes of payload
+func rtpPacket(payloadType uint8, seq uint16, ssrc uint32) []byte {
+	p := make([]byte, 12+160)
+	p[0] = 0x80 // Version 2
+	p[1] = payloadType
+	binary.BigEndian.PutUint16(p[2:4], seq)
+	binary.BigEndian.PutUint32(p[4:8], uint32(seq)*160)
+	binary.BigEndian.PutUint32(p[8:12], ssrc)
+	return p
+}
+
+// TestUnannouncedRTP sends UDP flows on ports neither announced in SDP nor mapped
+// and checks which of them are decoded as RTP streams
+func TestUnannouncedRTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets [][]byte
+		want    string // Packets of the stream, empty when no stream is stored
+	}{
+		{
+			name: "PCMA with a lost packet",
+			packets: [][]byte{rtpPacket(8, 100, 0x5a1b2c3d), rtpPacket(8, 101, 0x5a1b2c3d), rtpPacket(8, 103, 0x5a1b2c3d),
+				rtpPacket(8, 104, 0x5a1b2c3d), rtpPacket(8, 105, 0x5a1b2c3d), rtpPacket(8, 106, 0x5a1b2c3d)},
+			want: "6",
+		},
+		{
+			name: "sequence wraps",
+			packets: [][]byte{rtpPacket(0, 65534, 7), rtpPacket(0, 65535, 7), rtpPacket(0, 0, 7),
+				rtpPacket(0, 1, 7), rtpPacket(0, 2, 7)},
+			want: "5",
+		},
+		{
+			name: "too few packets",
+			packets: [][]byte{rtpPacket(0, 1, 7), rtpPacket(0, 2, 7), rtpPacket(0, 3, 7),
+				rtpPacket(0, 4, 7)},
+		},
+		{
+			name: "SSRC changes",
+			packets: [][]byte{rtpPacket(0, 1, 7), rtpPacket(0, 2, 8), rtpPacket(0, 3, 7),
+				rtpPacket(0, 4, 8), rtpPacket(0, 5, 7), rtpPacket(0, 6, 8)},
+		},
+		{
+			name: "sequence repeats",
+			packets: [][]byte{rtpPacket(0, 1, 7), rtpPacket(0, 1, 7), rtpPacket(0, 1, 7),
+				rtpPacket(0, 1, 7), rtpPacket(0, 1, 7), rtpPacket(0, 1, 7)},
+		},
+		{
+			name: "dynamic payload type",
+			packets: [][]byte{rtpPacket(96, 1, 7), rtpPacket(96, 2, 7), rtpPacket(96, 3, 7),
+				rtpPacket(96, 4, 7), rtpPacket(96, 5, 7), rtpPacket(96, 6, 7)},
+		},
+		{
+			name: "video payload type",
+			packets: [][]byte{rtpPacket(34, 1, 7), rtpPacket(34, 2, 7), rtpPacket(34, 3, 7),
+				rtpPacket(34, 4, 7), rtpPacket(34, 5, 7), rtpPacket(34, 6, 7)},
+		},
+	}
+	for _, tt := range tests {
+		if err := setupDissectors(nil); err != nil {
+			t.Fatal(err)
+		}
+		decode_rtp.Reset()
+		resetRTPFlows()
+		database.Take()
+
+		start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+		for i, payload := range tt.packets {
+			dispatch(transportUDP, &datagram{src: "10.0.0.1", dst: "10.0.0.2", srcPort: 41000, dstPort: 52000,
+				payload: payload, seen: start.Add(time.Duration(i) * 20 * time.Millisecond), frame: uint64(i + 1)})
+		}
+		decode_rtp.Flush()
+
+		var got string
+		for _, m := range database.Take() {
+			if m.Protocol != "rtp_stream" || got != "" {
+				t.Errorf("%s: unexpected %s record %v", tt.name, m.Protocol, m.Message)
+				continue
+			}
+			got = m.Message["packets"]
+		}
+		if got != tt.want {
+			t.Errorf("%s: stream of %q packets, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// reassembly.go
+// This file rebuilds TCP byte streams before HTTP and Diameter decoding.
+// Core functionalities:
+// - Orders segments and drops retransmitted bytes with gopacket tcpassembly
+// - Keeps one buffer per connection direction
+// - Selects the stream dissector by port, or by the first bytes of the stream
+// - Cuts the byte stream into whole HTTP/2 frames, HTTP/1, SIP and Diameter messages
+//
+// Example scenarios:
+// 1. Large 5G SBI response:
+//    A 9 kB HEADERS+DATA response spread over seven segments is decoded
+//    once, in the frame carrying its last byte
+//
+// 2. Several Diameter messages in one segment:
+//    Each message is decoded separately
+//
+// 3. Capture started mid-connection:
+//    The first segment seen starts the stream; bytes that do not look like a
+//    frame or message header are dropped until the next segment
+
+package decode
+
+import (
+	decode_http "DeepPacketAI/internal/protocols/http" // HTTP/1 and HTTP/2 protocol decoder
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"golang.org/x/net/http2"
+)
+
+const (
+	diameterHeaderLen = 20      // Diameter message header
+	http2HeaderLen    = 9       // HTTP/2 frame header
+	maxHTTP2Frame     = 1 << 20 // Larger frames are taken as non-HTTP/2 traffic (TLS, HTTP/1.x)
+
+	// Out-of-order data buffered per connection before the gap is given up
+	maxPagesPerConnection = 256
+	maxPagesTotal         = 65536
+
+	// Undecoded bytes kept per stream, e.g. an HTTP/1 body delimited by connection close
+	maxStreamBuffer = 16 << 20
+
+	// Live capture closes connections idle for this long
+	idleTimeout = 2 * time.Minute
+)
+
+// http2Preface is sent by HTTP/2 clients before the first frame
+var http2Preface = []byte(http2.ClientPreface)
+
+// streamKey identifies one direction of a TCP connection
+type streamKey struct {
+	network, transport gopacket.Flow
+}
+
+// reassembler feeds TCP segments into tcpassembly and decodes the result
+// tcpassembly calls Reassembled synchronously from Assemble, so the frame
+// number of the segment being assembled is known to the streams
+type reassembler struct {
+	assembler *tcpassembly.Assembler
+	started   map[streamKey]bool // Directions tcpassembly has a start sequence for
+	frame     uint64             // Frame number of the segment being assembled
+	lastFlush time.Time          // Capture time of the last idle connection flush
+}
+
+// tcp is the reassembly state of the capture being decoded
+// Replaced by resetReassembly before each capture, guarded by processMu
+var tcp = newReassembler()
+
+// newReassembler creates an empty reassembler
+func newReassembler() *reassembler {
+	r := &reassembler{started: make(map[streamKey]bool)}
+	r.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(r))
+	r.assembler.MaxBufferedPagesPerConnection = maxPagesPerConnection
+	r.assembler.MaxBufferedPagesTotal = maxPagesTotal
+	return r
+}
+
+// resetReassembly discards the TCP streams of the previous capture
+func resetReassembly() {
+	tcp = newReassembler()
+}
+
+// assemble adds a TCP segment to its stream
+// Parameters:
+//   - network: IP addresses of the segment
+//   - segment: Decoded TCP layer
+//   - ts: Capture timestamp
+//   - frame: Frame number of the segment
+func (r *reassembler) assemble(network gopacket.Flow, segment *layers.TCP, ts time.Time, frame uint64) {
+	r.frame = frame
+
+	// tcpassembly waits for a SYN before it passes data on; captures started
+	// mid-connection start the stream at the first segment seen instead
+	key := streamKey{network, segment.TransportFlow()}
+	if !r.started[key] && !segment.SYN && len(segment.Payload) > 0 {
+		start := layers.TCP{
+			SrcPort: segment.SrcPort,
+			DstPort: segment.DstPort,
+			Seq:     segment.Seq - 1,
+			SYN:     true,
+		}
+		start.SetInternalPortsForTesting()
+		r.assembler.AssembleWithTimestamp(network, &start, ts)
+	}
+	if segment.SYN || len(segment.Payload) > 0 {
+		r.started[key] = true
+	}
+	r.assembler.AssembleWithTimestamp(network, segment, ts)
+
+	// Live captures never end, close idle connections now and then
+	if r.lastFlush.IsZero() {
+		r.lastFlush = ts
+	} else if ts.Sub(r.lastFlush) > idleTimeout {
+		r.assembler.FlushOlderThan(ts.Add(-idleTimeout))
+		r.lastFlush = ts
+	}
+}
+
+// flush decodes the data still waiting behind gaps at the end of a capture
+// and stores the HTTP/2 transactions that never completed
+func (r *reassembler) flush() {
+	r.assembler.FlushAll()
+	decode_http.Flush()
+}
+
+// New creates the stream of one connection direction (tcpassembly.StreamFactory)
+// Mapped ports (e.g. 3868 for Diameter) select the dissector; on other ports
+// the first bytes tell the protocol
+func (r *reassembler) New(network, transport gopacket.Flow) tcpassembly.Stream {
+	s := &tcpStream{
+		owner:   r,
+		key:     streamKey{network, transport},
+		src:     network.Src().String(),
+		dst:     network.Dst().String(),
+		srcPort: binary.BigEndian.Uint16(transport.Src().Raw()),
+		dstPort: binary.BigEndian.Uint16(transport.Dst().Raw()),
+	}
+	if d := byPort(transportTCP, s.srcPort, s.dstPort); d != nil {
+		d.(streamDissector).start(s)
+	} else {
+		s.next = s.sniff
+	}
+	return s
+}
+
+// sniff selects the protocol of a stream from its first bytes with the
+// heuristics of the TCP dissectors, in their registration order
+// Used as next until the protocol is known
+func (s *tcpStream) sniff(data []byte) int {
+	if data[0] == '\r' || data[0] == '\n' {
+		// CRLF keep-alive before the first SIP message, nothing to decode
+		s.decode = func([]byte, time.Time, uint64) {}
+		return len(data) - len(bytes.TrimLeft(data, "\r\n"))
+	}
+
+	d, result := heuristic(transportTCP, data)
+	if result != matchYes {
+		// Too short to tell (0) or no known protocol (-1), keep sniffing
+		return result
+	}
+	d.(streamDissector).start(s)
+	return s.next(data)
+}
+
+// tcpStream buffers one direction of a connection until a whole unit arrives
+type tcpStream struct {
+	owner            *reassembler
+	key              streamKey
+	src, dst         string    // IP addresses
+	srcPort, dstPort uint16    // TCP ports
+	buf              []byte    // Bytes not yet decoded
+	lastSeen         time.Time // Capture time of the latest bytes
+
+	// next returns the length of the unit at the start of data,
+	// 0 when more data is needed and -1 when data is not at a unit boundary
+	next func(data []byte) int
+
+	// decode processes one whole unit
+	decode func(p []byte, seen time.Time, frame uint64)
+
+	// close passes the undecoded rest of the stream and drops decoder state,
+	// nil when there is none
+	close func(rest []byte)
+}
+
+// Reassembled receives the next in-order bytes of the stream
+func (s *tcpStream) Reassembled(pieces []tcpassembly.Reassembly) {
+	for _, piece := range pieces {
+		// Bytes were lost, the buffered partial unit can never complete
+		if piece.Skip != 0 || len(s.buf) > maxStreamBuffer {
+			s.buf = s.buf[:0]
+		}
+		s.buf = append(s.buf, piece.Bytes...)
+		s.lastSeen = piece.Seen
+
+		for len(s.buf) > 0 {
+			n := s.next(s.buf)
+			if n < 0 {
+				// Not a frame or message header, resynchronise on the next segment
+				s.buf = s.buf[:0]
+				break
+			}
+			if n == 0 || n > len(s.buf) {
+				break // Wait for the rest of the unit
+			}
+			s.decode(s.buf[:n], piece.Seen, s.owner.frame)
+			s.buf = s.buf[n:]
+		}
+
+		// Start the next unit at the front of the buffer again
+		if len(s.buf) == 0 {
+			s.buf = nil
+		}
+	}
+}
+
+// ReassemblyComplete is called when the connection is closed or flushed
+func (s *tcpStream) ReassemblyComplete() {
+	delete(s.owner.started, s.key)
+	if s.close != nil {
+		s.close(s.buf)
+	}
+	s.buf = nil
+}
+
+// http2Rule constrains the header of one HTTP/2 frame type (RFC 9113 section 6)
+type http2Rule struct {
+	flags  byte // Defined flag bits
+	stream int  // 0: stream ID must be 0, 1: must not be 0, -1: either
+	length int  // Exact payload length, -1 for any, -8 for at least 8
+}
+
+// http2Rules is indexed by frame type
+// Captures that start mid-connection begin inside a frame; checking the
+// header against its type keeps random bytes from passing as a frame
+var http2Rules = [...]http2Rule{
+	0x0: {flags: 0x09, stream: 1, length: -1}, // DATA
+	0x1: {flags: 0x2d, stream: 1, length: -1}, // HEADERS
+	0x2: {flags: 0x00, stream: 1, length: 5},  // PRIORITY
+	0x3: {flags: 0x00, stream: 1, length: 4},  // RST_STREAM
+	0x4: {flags: 0x01, stream: 0, length: -1}, // SETTINGS
+	0x5: {flags: 0x0c, stream: 1, length: -1}, // PUSH_PROMISE
+	0x6: {flags: 0x01, stream: 0, length: 8},  // PING
+	0x7: {flags: 0x00, stream: 0, length: -8}, // GOAWAY
+	0x8: {flags: 0x00, stream: -1, length: 4}, // WINDOW_UPDATE
+	0x9: {flags: 0x04, stream: 1, length: -1}, // CONTINUATION
+}
+
+// http2Frame returns the length of the HTTP/2 frame at the start of data
+// The client preface is passed on as a unit of its own. Frame types without a
+// rule (ALTSVC, ORIGIN and other extensions) are framed by their length only,
+// the decoder ignores them as RFC 9113 section 5.5 requires
+func http2Frame(data []byte) int {
+	if bytes.HasPrefix(data, http2Preface) {
+		return len(http2Preface)
+	}
+	if len(data) < len(http2Preface) && bytes.HasPrefix(http2Preface, data) {
+		return 0 // Wait for the rest of the preface
+	}
+	if len(data) < http2HeaderLen {
+		return 0
+	}
+	length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	if length > maxHTTP2Frame {
+		return -1
+	}
+	if int(data[3]) >= len(http2Rules) {
+		return http2HeaderLen + length
+	}
+
+	rule := http2Rules[data[3]]
+	stream := binary.BigEndian.Uint32(data[5:9])
+	switch {
+	case data[4]&^rule.flags != 0:
+		return -1 // Undefined flags
+	case stream&0x80000000 != 0:
+		return -1 // Reserved bit
+	case rule.stream == 0 && stream != 0, rule.stream == 1 && stream == 0:
+		return -1 // Frame type sent on the wrong stream
+	case rule.length >= 0 && length != rule.length, rule.length == -8 && length < 8:
+		return -1 // Fixed-size frame with another size
+	case data[3] == 0x4 && length%6 != 0:
+		return -1 // SETTINGS carries 6-byte parameters
+	}
+	return http2HeaderLen + length
+}
+
+// diameterMessage returns the length of the Diameter message at the start of data
+func diameterMessage(data []byte) int {
+	if len(data) < 4 {
+		return 0
+	}
+	length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if data[0] != 1 || length < diameterHeaderLen || length%4 != 0 {
+		return -1 // Version 1 and 32-bit aligned length expected
+	}
+	return length
+}
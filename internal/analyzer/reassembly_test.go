@@ -0,0 +1,300 @@
+package decode
+
+import (
+	decode_http "DeepPacketAI/internal/protocols/http"
+	database "DeepPacketAI/internal/storage"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// tcpConn sends the segments of one test connection through tcp.assemble
+type tcpConn struct {
+	client, server         net.IP
+	clientPort, serverPort uint16
+	seq                    [2]uint32 // Next sequence number of the client and of the server
+	frame                  uint64
+	start                  time.Time
+}
+
+func newTCPConn(clientPort, serverPort uint16) *tcpConn {
+	return &tcpConn{
+		client: net.IPv4(10, 0, 0, 1).To4(), server: net.IPv4(10, 0, 0, 2).To4(),
+		clientPort: clientPort, serverPort: serverPort,
+		seq:   [2]uint32{1001, 5001},
+		start: time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+// segment returns the next segment of one side carrying payload
+func (c *tcpConn) segment(fromServer bool, payload []byte) *layers.TCP {
+	side := 0
+	seg := &layers.TCP{SrcPort: layers.TCPPort(c.clientPort), DstPort: layers.TCPPort(c.serverPort), ACK: true}
+	if fromServer {
+		side = 1
+		seg.SrcPort, seg.DstPort = seg.DstPort, seg.SrcPort
+	}
+	seg.Seq = c.seq[side]
+	seg.Payload = payload
+	seg.SetInternalPortsForTesting()
+	c.seq[side] += uint32(len(payload))
+	return seg
+}
+
+// open sends the SYN of both sides, so data segments may arrive out of order
+func (c *tcpConn) open() {
+	for _, fromServer := range []bool{false, true} {
+		syn := c.segment(fromServer, nil)
+		syn.Seq--
+		syn.SYN = true
+		c.send(syn)
+	}
+}
+
+// send assembles segments in the given order, one frame each, 1 ms apart
+func (c *tcpConn) send(segments ...*layers.TCP) {
+	for _, seg := range segments {
+		c.frame++
+		network := gopacket.NewFlow(layers.EndpointIPv4, c.client, c.server)
+		if uint16(seg.SrcPort) == c.serverPort {
+			network = network.Reverse()
+		}
+		tcp.assemble(network, seg, c.start.Add(time.Duration(c.frame)*time.Millisecond), c.frame)
+	}
+}
+
+// http2Writer writes the frames one side of a test connection sends
+// Header blocks are encoded with the side's own HPACK table
+type http2Writer struct {
+	out    bytes.Buffer
+	framer *http2.Framer
+	block  bytes.Buffer
+	hpack  *hpack.Encoder
+}
+
+func newHTTP2Writer() *http2Writer {
+	w := &http2Writer{}
+	w.framer = http2.NewFramer(&w.out, nil)
+	w.hpack = hpack.NewEncoder(&w.block)
+	return w
+}
+
+// headers writes a HEADERS frame with fields as name/value pairs
+func (w *http2Writer) headers(streamID uint32, endStream bool, fields ...string) {
+	w.block.Reset()
+	for i := 0; i+1 < len(fields); i += 2 {
+		w.hpack.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	w.framer.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, EndStream: endStream, EndHeaders: true,
+		BlockFragment: w.block.Bytes()})
+}
+
+// take returns the frames written so far
+func (w *http2Writer) take() []byte {
+	data := bytes.Clone(w.out.Bytes())
+	w.out.Reset()
+	return data
+}
+
+// resetDecoders starts a test capture with clean TCP and decoder state
+func resetDecoders(t *testing.T) {
+	t.Helper()
+	if err := setupDissectors(nil); err != nil {
+		t.Fatal(err)
+	}
+	decode_http.Reset()
+	resetReassembly()
+	database.Take()
+}
+
+// decoded flushes the connections and returns the stored messages of protocol
+func decoded(protocol string) []database.ProcessedMessage {
+	tcp.flush()
+	var messages []database.ProcessedMessage
+	for _, m := range database.Take() {
+		if m.Protocol == protocol {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// TestHTTP2ExtensionFrames checks that ALTSVC, ORIGIN and unknown frame types are
+// skipped without losing the frames after them or the HPACK tables
+func TestHTTP2ExtensionFrames(t *testing.T) {
+	resetDecoders(t)
+	conn := newTCPConn(40000, 8080)
+	client, server := newHTTP2Writer(), newHTTP2Writer()
+
+	client.out.WriteString(http2.ClientPreface)
+	client.headers(1, true, ":method", "GET", ":path", "/nnrf-disc/v1/nf-instances", "3gpp-sbi-target-apiroot", "https://udm.5gc.example")
+	conn.send(conn.segment(false, client.take()))
+
+	server.framer.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 100}) // Server preface
+	origin := append([]byte{0, 23}, "https://udm.5gc.example"...)                               // Length-prefixed origin
+	server.framer.WriteRawFrame(0xa, 0, 0, append(bytes.Clone(origin), `h2=":8443"`...))        // ALTSVC
+	server.framer.WriteRawFrame(0xc, 0, 0, origin)                                              // ORIGIN
+	server.framer.WriteRawFrame(0xf0, 0x5, 1, []byte("extension"))
+	server.headers(1, true, ":status", "200", "content-type", "application/json")
+	conn.send(conn.segment(true, server.take()))
+
+	// The second request reuses header fields indexed by the first one
+	client.framer.WriteRawFrame(0xb, 0, 0, nil) // Unassigned type
+	client.headers(3, true, ":method", "GET", ":path", "/nnrf-disc/v1/nf-instances", "3gpp-sbi-target-apiroot", "https://udm.5gc.example")
+	conn.send(conn.segment(false, client.take()))
+	server.headers(3, true, ":status", "200", "content-type", "application/json")
+	conn.send(conn.segment(true, server.take()))
+
+	var records []map[string]string
+	for _, m := range decoded("http") {
+		switch m.Message["frame_type"] {
+		case "":
+			records = append(records, m.Message)
+		case "SETTINGS":
+		default:
+			t.Errorf("frame message %v", m.Message)
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d transactions, want 2: %v", len(records), records)
+	}
+	for _, r := range records {
+		if r[":path"] != "/nnrf-disc/v1/nf-instances" || r[":status"] != "200" {
+			t.Errorf("stream %s: %v", r["stream_id"], r)
+		}
+	}
+}
+
+// diameterRequest builds a Device-Watchdog-Request with an Origin-Host AVP
+func diameterRequest(hopByHop uint32, host string) []byte {
+	avp := make([]byte, 8, 8+len(host)+3)
+	binary.BigEndian.PutUint32(avp[0:4], 264) // Origin-Host
+	binary.BigEndian.PutUint32(avp[4:8], 0x40<<24|uint32(8+len(host)))
+	avp = append(avp, host...)
+	for len(avp)%4 != 0 {
+		avp = append(avp, 0) // Padding
+	}
+
+	header := make([]byte, diameterHeaderLen)
+	binary.BigEndian.PutUint32(header[0:4], 1<<24|uint32(diameterHeaderLen+len(avp)))
+	binary.BigEndian.PutUint32(header[4:8], 0x80<<24|280) // Request, Device-Watchdog
+	binary.BigEndian.PutUint32(header[12:16], hopByHop)
+	binary.BigEndian.PutUint32(header[16:20], hopByHop)
+	return append(header, avp...)
+}
+
+// TestReassembly sends HTTP/2 and Diameter streams cut into segments and checks
+// that every message is decoded once, in the frame carrying its last byte
+func TestReassembly(t *testing.T) {
+	// POST split over HEADERS and DATA, followed by a GET
+	sbi := newHTTP2Writer()
+	sbi.headers(1, false, ":method", "POST", ":path", "/nausf-auth/v1/ue-authentications", "content-type", "application/json")
+	sbi.framer.WriteData(1, true, []byte(`{"supiOrSuci":"suci-0-001-01-0-0-0-0000000001"}`))
+	sbi.headers(3, true, ":method", "GET", ":path", "/nudm-sdm/v2/imsi-001010000000001/am-data")
+	http2Frames := sbi.take()
+	http2Data := append([]byte(http2.ClientPreface), http2Frames...)
+	dataStart := len(http2.ClientPreface) + 2*http2HeaderLen + int(http2Frames[2]) // Preface, HEADERS and the DATA header
+
+	dwr1, dwr2 := diameterRequest(1, "mme.epc.example"), diameterRequest(2, "mme.epc.example")
+	diameterData := append(bytes.Clone(dwr1), dwr2...)
+
+	// Bytes of the previous message seen first in captures started mid-connection
+	tail := []byte(`0000000001"}`)
+
+	tests := []struct {
+		name  string
+		port  uint16
+		data  []byte
+		cuts  []int // Offsets the data is cut into segments at
+		order []int // Segments in capture order
+		syn   bool  // The capture has the SYNs; frames 1 and 2
+		want  []string
+	}{
+		{
+			name: "HTTP/2 frames split across segments",
+			port: 8080, data: http2Data, cuts: []int{30, dataStart + 10}, order: []int{0, 1, 2}, syn: true,
+			want: []string{"POST /nausf-auth/v1/ue-authentications frame 4", "GET /nudm-sdm/v2/imsi-001010000000001/am-data frame 5"},
+		},
+		{
+			name: "HTTP/2 segments out of order",
+			port: 8080, data: http2Data, cuts: []int{30, dataStart + 10}, order: []int{0, 2, 1}, syn: true,
+			want: []string{"POST /nausf-auth/v1/ue-authentications frame 5", "GET /nudm-sdm/v2/imsi-001010000000001/am-data frame 5"},
+		},
+		{
+			name: "HTTP/2 duplicate segment",
+			port: 8080, data: http2Data, cuts: []int{30, dataStart + 10}, order: []int{0, 1, 1, 2}, syn: true,
+			want: []string{"POST /nausf-auth/v1/ue-authentications frame 4", "GET /nudm-sdm/v2/imsi-001010000000001/am-data frame 6"},
+		},
+		{
+			name: "HTTP/2 capture started mid-connection",
+			port: 8080, data: append(bytes.Clone(tail), http2Frames...), cuts: []int{len(tail)}, order: []int{0, 1},
+			want: []string{"POST /nausf-auth/v1/ue-authentications frame 2", "GET /nudm-sdm/v2/imsi-001010000000001/am-data frame 2"},
+		},
+		{
+			name: "two Diameter messages in one segment",
+			port: 3868, data: diameterData, order: []int{0}, syn: true,
+			want: []string{"280 hop-by-hop 1 frame 3", "280 hop-by-hop 2 frame 3"},
+		},
+		{
+			name: "Diameter message split across segments",
+			port: 3868, data: diameterData, cuts: []int{len(dwr1) + 10}, order: []int{0, 1}, syn: true,
+			want: []string{"280 hop-by-hop 1 frame 3", "280 hop-by-hop 2 frame 4"},
+		},
+		{
+			name: "Diameter segments out of order with a duplicate",
+			port: 3868, data: diameterData, cuts: []int{10, len(dwr1) + 10}, order: []int{0, 2, 2, 1}, syn: true,
+			want: []string{"280 hop-by-hop 1 frame 6", "280 hop-by-hop 2 frame 6"},
+		},
+		{
+			name: "Diameter capture started mid-connection",
+			port: 3868, data: append(bytes.Clone(tail), diameterData...), cuts: []int{len(tail), len(tail) + len(dwr1)}, order: []int{0, 1, 2},
+			want: []string{"280 hop-by-hop 1 frame 2", "280 hop-by-hop 2 frame 3"},
+		},
+	}
+	for _, tt := range tests {
+		resetDecoders(t)
+		conn := newTCPConn(40000, tt.port)
+		if tt.syn {
+			conn.open()
+		}
+		var segments []*layers.TCP
+		start := 0
+		for _, end := range append(tt.cuts, len(tt.data)) {
+			segments = append(segments, conn.segment(false, tt.data[start:end]))
+			start = end
+		}
+		for _, i := range tt.order {
+			conn.send(segments[i])
+		}
+
+		var got []string
+		if tt.port == 3868 {
+			for _, m := range decoded("diameter") {
+				got = append(got, fmt.Sprintf("%s hop-by-hop %s frame %d", m.Message["CommandCode"], m.Message["HopByHopID"], m.Frame_Number))
+			}
+		} else {
+			for _, m := range decoded("http") {
+				r := m.Message
+				if r["frame_type"] != "" {
+					t.Errorf("%s: frame message %v", tt.name, r)
+					continue
+				}
+				if r[":method"] == "POST" && r["request_json_supiOrSuci"] != "suci-0-001-01-0-0-0-0000000001" {
+					t.Errorf("%s: request body %q", tt.name, r["request_body"])
+				}
+				got = append(got, fmt.Sprintf("%s %s frame %s", r[":method"], r[":path"], r["request_frame"]))
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: decoded %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// websocket.go
+// This file frames SIP carried over TCP and over WebSocket (RFC 7118).
+// Core functionalities:
+// - Cuts a SIP byte stream at Content-Length, skipping CRLF keep-alives
+// - Cuts a WebSocket stream into frames and unmasks client frames
+// - Joins fragmented WebSocket messages before decoding
+//
+// Example scenarios:
+// 1. SIP over TCP:
+//    An INVITE split over three segments is decoded once, and a 200 OK and ACK
+//    sharing one segment are decoded separately
+//
+// 2. WebRTC client:
+//    After "GET /ws HTTP/1.1" with "Upgrade: websocket" and the 101 answer, each
+//    text frame holding a SIP message is decoded like SIP over TCP
+
+package decode
+
+import (
+	decode_sip "DeepPacketAI/internal/protocols/sip" // SIP protocol decoder
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxSIPHeader   = 64 * 1024 // Start line and headers of a SIP message
+	maxWebSocket   = 1 << 20   // Larger frames are taken as non-WebSocket traffic
+	wsOpContinue   = 0x0       // Continuation of a fragmented message
+	wsOpText       = 0x1
+	wsOpBinary     = 0x2
+	wsOpClose      = 0x8
+	wsOpPing       = 0x9
+	wsOpPong       = 0xa
+	wsFinalFrame   = 0x80 // FIN bit of the first header byte
+	wsMaskedFrame  = 0x80 // MASK bit of the second header byte
+	wsReservedBits = 0x70 // RSV1-3, only set by extensions such as compression
+)
+
+// sipMessage returns the length of the SIP message at the start of data
+// CRLF keep-alives (RFC 5626 section 4.4.1) are returned as units of their own
+func sipMessage(data []byte) int {
+	if n := len(data) - len(bytes.TrimLeft(data, "\r\n")); n > 0 {
+		return n
+	}
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(data) > maxSIPHeader {
+			return -1
+		}
+		return 0
+	}
+	if !decode_sip.LooksLikeSIP(data) {
+		return -1
+	}
+
+	// Content-Length is mandatory on stream transports (RFC 3261 section 20.14)
+	length := 0
+	for _, line := range strings.Split(string(data[:end]), "\r\n")[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "content-length" || name == "l" { // "l" is the compact form
+			if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n >= 0 {
+				length = n
+			}
+		}
+	}
+	return end + 4 + length
+}
+
+// decodeSIP stores one unit cut by sipMessage
+func (s *tcpStream) decodeSIP(transport string) func(p []byte, seen time.Time, frame uint64) {
+	return func(p []byte, seen time.Time, frame uint64) {
+		if len(bytes.TrimLeft(p, "\r\n")) == 0 {
+			return // Keep-alive
+		}
+		decode_sip.ProcessStream(p, transport, s.src, s.dst, seen, frame)
+	}
+}
+
+// wsFrame returns the length of the WebSocket frame at the start of data (RFC 6455 section 5.2)
+func wsFrame(data []byte) int {
+	if len(data) < 2 {
+		return 0
+	}
+	opcode := data[0] & 0x0f
+	switch {
+	case opcode > wsOpBinary && opcode < wsOpClose, opcode > wsOpPong:
+		return -1 // Reserved opcode
+	case opcode >= wsOpClose && data[0]&wsFinalFrame == 0:
+		return -1 // Control frames are never fragmented
+	}
+
+	header, length := 2, int(data[1]&0x7f)
+	switch length {
+	case 126:
+		if len(data) < 4 {
+			return 0
+		}
+		header, length = 4, int(binary.BigEndian.Uint16(data[2:4]))
+	case 127:
+		if len(data) < 10 {
+			return 0
+		}
+		size := binary.BigEndian.Uint64(data[2:10])
+		if size > maxWebSocket {
+			return -1
+		}
+		header, length = 10, int(size)
+	}
+	if data[1]&wsMaskedFrame != 0 {
+		header += 4 // Masking key
+	}
+	return header + length
+}
+
+// wsPayload returns the unmasked payload of a whole frame
+func wsPayload(frame []byte) []byte {
+	header, length := 2, int(frame[1]&0x7f)
+	switch length {
+	case 126:
+		header = 4
+	case 127:
+		header = 10
+	}
+	if frame[1]&wsMaskedFrame == 0 {
+		return frame[header:]
+	}
+	key := frame[header : header+4]
+	payload := append([]byte(nil), frame[header+4:]...)
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+	return payload
+}
+
+// startWebSocket switches a stream to WebSocket framing
+// Text and binary messages holding SIP are decoded, other messages are ignored
+func (s *tcpStream) startWebSocket() {
+	var message []byte // Fragments of the message being received
+	s.next = wsFrame
+	s.decode = func(p []byte, seen time.Time, frame uint64) {
+		opcode := p[0] & 0x0f
+		switch {
+		case opcode >= wsOpClose:
+			return // Close, ping and pong
+		case opcode != wsOpContinue:
+			message = nil // A new message
+		}
+		if p[0]&wsReservedBits != 0 {
+			return // Compressed (permessage-deflate) frames are not decoded
+		}
+		message = append(message, wsPayload(p)...)
+		if p[0]&wsFinalFrame == 0 || len(message) > maxWebSocket {
+			return
+		}
+		if decode_sip.LooksLikeSIP(message) {
+			decode_sip.ProcessStream(message, "WebSocket", s.src, s.dst, seen, frame)
+		}
+		message = nil
+	}
+}
+
+// wsCarriesSIP reports whether data starts with a whole WebSocket frame holding SIP
+// Used to recognise WebSocket connections whose handshake was not captured
+func wsCarriesSIP(data []byte) bool {
+	n := wsFrame(data)
+	if n <= 0 || n > len(data) {
+		return false
+	}
+	opcode := data[0] & 0x0f
+	return (opcode == wsOpText || opcode == wsOpBinary) && decode_sip.LooksLikeSIP(wsPayload(data[:n]))
+}
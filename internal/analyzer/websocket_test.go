@@ -0,0 +1,151 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// webSocketFrame builds a WebSocket frame, masked when key is set
+func webSocketFrame(fin bool, opcode byte, payload []byte, key []byte) []byte {
+	first := opcode
+	if fin {
+		first |= wsFinalFrame
+	}
+	var mask byte
+	if key != nil {
+		mask = wsMaskedFrame
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, mask|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, mask|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, mask|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if key == nil {
+		return append(frame, payload...)
+	}
+	frame = append(frame, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	return frame
+}
+
+// sipRequest formats a SIP message of call "ws" with a body of bodyLength bytes
+func sipRequest(startLine string, method string, bodyLength int) string {
+	return fmt.Sprintf("%s\r\nVia: SIP/2.0/WSS df7jal23ls0d.invalid;branch=z9hG4bK56sdasks\r\n"+
+		"From: <sip:alice@example.com>;tag=A\r\nTo: <sip:bob@example.com>\r\nCall-ID: ws\r\nCSeq: 1 %s\r\n"+
+		"Content-Length: %d\r\n\r\n%s", startLine, method, bodyLength, strings.Repeat("v", bodyLength))
+}
+
+// TestWebSocketFraming checks frame lengths and unmasking (RFC 6455 section 5.2)
+func TestWebSocketFraming(t *testing.T) {
+	key := []byte{0x37, 0xfa, 0x21, 0x3d}
+	long := bytes.Repeat([]byte("SIP/2.0 200 OK\r\n"), 5000) // 80000 bytes
+	tests := []struct {
+		name    string
+		frame   []byte
+		want    int    // wsFrame result
+		payload []byte // Unmasked payload of whole frames
+	}{
+		{"unmasked", webSocketFrame(true, wsOpText, []byte("Hello"), nil), 7, []byte("Hello")},
+		{"masked", webSocketFrame(true, wsOpText, []byte("Hello"), key), 11, []byte("Hello")},
+		{"16-bit length", webSocketFrame(true, wsOpBinary, long[:300], key), 4 + 4 + 300, long[:300]},
+		{"64-bit length", webSocketFrame(true, wsOpText, long, key), 10 + 4 + len(long), long},
+		{"64-bit length above the limit", append([]byte{0x81, 127}, binary.BigEndian.AppendUint64(nil, maxWebSocket+1)...), -1, nil},
+		{"incomplete 16-bit length", []byte{0x81, 126, 0x01}, 0, nil},
+		{"incomplete 64-bit length", []byte{0x81, 127, 0, 0, 0, 0}, 0, nil},
+		{"reserved opcode", webSocketFrame(true, 0x3, []byte("x"), nil), -1, nil},
+		{"fragmented ping", webSocketFrame(false, wsOpPing, nil, nil), -1, nil},
+	}
+	for _, tt := range tests {
+		got := wsFrame(tt.frame)
+		if got != tt.want {
+			t.Errorf("%s: wsFrame = %d, want %d", tt.name, got, tt.want)
+			continue
+		}
+		if got > 0 && !bytes.Equal(wsPayload(tt.frame[:got]), tt.payload) {
+			t.Errorf("%s: wrong payload", tt.name)
+		}
+	}
+}
+
+// TestSIPStreams sends SIP over TCP and over WebSocket cut into segments and checks
+// the messages decoded, with the frame carrying their last byte
+func TestSIPStreams(t *testing.T) {
+	invite := sipRequest("INVITE sip:bob@example.com SIP/2.0", "INVITE", 120)
+	ok := sipRequest("SIP/2.0 200 OK", "INVITE", 0)
+	ack := sipRequest("ACK sip:bob@example.com SIP/2.0", "ACK", 0)
+	compact := strings.Replace(sipRequest("MESSAGE sip:bob@example.com SIP/2.0", "MESSAGE", 5), "Content-Length:", "l:", 1)
+
+	key := []byte{0x0a, 0x1b, 0x2c, 0x3d}
+	register := sipRequest("REGISTER sip:example.com SIP/2.0", "REGISTER", 0)
+	cut := strings.Index(register, "\r\n") + 2
+
+	tests := []struct {
+		name     string
+		port     uint16
+		segments []string
+		want     string
+	}{
+		{
+			name:     "SIP message split over three segments",
+			port:     5060,
+			segments: []string{invite[:40], invite[40:200], invite[200:]},
+			want:     "[INVITE sip:bob@example.com SIP/2.0 TCP frame 3]",
+		},
+		{
+			name:     "several SIP messages and a keep-alive in one segment",
+			port:     5060,
+			segments: []string{ok + ack + "\r\n\r\n" + compact},
+			want:     "[SIP/2.0 200 OK TCP frame 1 ACK sip:bob@example.com SIP/2.0 TCP frame 1 MESSAGE sip:bob@example.com SIP/2.0 TCP frame 1]",
+		},
+		{
+			name:     "SIP message ending in the next segment with another one",
+			port:     5060,
+			segments: []string{ok[:len(ok)-10], ok[len(ok)-10:] + ack},
+			want:     "[SIP/2.0 200 OK TCP frame 2 ACK sip:bob@example.com SIP/2.0 TCP frame 2]",
+		},
+		{
+			name: "fragmented WebSocket message around a ping",
+			port: 8443,
+			segments: []string{
+				string(webSocketFrame(false, wsOpText, []byte(register[:cut]), key)),
+				string(webSocketFrame(true, wsOpPing, []byte("keep"), key)),
+				string(webSocketFrame(true, wsOpContinue, []byte(register[cut:]), key)),
+			},
+			want: "[REGISTER sip:example.com SIP/2.0 WebSocket frame 3]",
+		},
+		{
+			name: "two WebSocket messages in one segment",
+			port: 8443,
+			segments: []string{
+				string(webSocketFrame(true, wsOpText, []byte(invite), key)) + string(webSocketFrame(true, wsOpText, []byte(ack), key)),
+			},
+			want: "[INVITE sip:bob@example.com SIP/2.0 WebSocket frame 1 ACK sip:bob@example.com SIP/2.0 WebSocket frame 1]",
+		},
+	}
+	for _, tt := range tests {
+		resetDecoders(t)
+		conn := newTCPConn(40000, tt.port)
+		for _, segment := range tt.segments {
+			conn.send(conn.segment(false, []byte(segment)))
+		}
+
+		var got []string
+		for _, m := range decoded("sip") {
+			got = append(got, fmt.Sprintf("%s %s frame %d", m.Message["Status"], m.Message["Transport"], m.Frame_Number))
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("%s: decoded %q, want %s", tt.name, got, tt.want)
+		}
+	}
+}
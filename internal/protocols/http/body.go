@@ -0,0 +1,234 @@
+// body.go
+// This file decodes HTTP request and response bodies for analysis.
+// Core functionalities:
+// - Removes gzip and deflate Content-Encoding
+// - Formats the body by its Content-Type: JSON and problem+json, XML, forms, text
+// - Adds the top-level scalar members of JSON bodies as fields of their own
+// - Shows binary content as hex up to a size limit
+// - Guesses the type of bodies sent without Content-Type
+//
+// Example scenarios:
+// 1. Compressed JSON response:
+//    content-encoding: gzip, content-type: application/json becomes the compact
+//    JSON document and {"response_encoding": "gzip (312 -> 1045 bytes)"}
+//
+// 2. Registration at the UDM:
+//    {"amfInstanceId": "c9e1...", "ratType": "NR", "guami": {...}} adds
+//    {"request_json_amfInstanceId": "c9e1...", "request_json_ratType": "NR"}
+//
+// 3. Form submission:
+//    "user=alice&scope=nudm-sdm" becomes "scope: nudm-sdm\nuser: alice"
+//
+// 4. Image download:
+//    image/png becomes "89504e470d0a1a0a... (18231 bytes)"
+
+package decode_http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxDecodedBytes = 1 << 20 // Bound on a decompressed body
+	maxHexBytes     = 256     // Binary content shown as hex
+	maxJSONFields   = 32      // Top-level JSON members added as fields
+)
+
+// contentDecode removes the Content-Encoding of a body
+// Encodings are listed in the order they were applied and removed in reverse
+// Parameters:
+// - encoding: Content-Encoding header value, e.g. "gzip"
+// - body: Captured body
+//
+// Returns: The decoded body, a description of the decoding (empty when there was
+// none) and an error when decoding stopped early; the part decoded so far is returned
+func contentDecode(encoding string, body []byte) ([]byte, string, error) {
+	var codings []string
+	for _, coding := range strings.Split(encoding, ",") {
+		if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	if len(codings) == 0 || len(body) == 0 {
+		return body, "", nil
+	}
+
+	data := body
+	for i := len(codings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		var err error
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(data))
+		case "deflate":
+			// HTTP deflate is zlib-wrapped (RFC 9110 section 8.4.1.2), some servers send raw deflate
+			reader, err = zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(data)), nil
+			}
+		default:
+			return data, "", fmt.Errorf("content-encoding %s not supported", codings[i])
+		}
+		if err != nil {
+			return data, "", fmt.Errorf("%s: %v", codings[i], err)
+		}
+
+		decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBytes))
+		if err != nil {
+			// Truncated capture, keep what could be decompressed
+			return decoded, describeCoding(codings, body, decoded), fmt.Errorf("%s: %v", codings[i], err)
+		}
+		data = decoded
+	}
+	return data, describeCoding(codings, body, data), nil
+}
+
+// describeCoding summarizes a decoding, e.g. "gzip (312 -> 1045 bytes)"
+func describeCoding(codings []string, body []byte, decoded []byte) string {
+	return fmt.Sprintf("%s (%d -> %d bytes)", strings.Join(codings, ", "), len(body), len(decoded))
+}
+
+// bodyText formats a decoded body for analysis by its media type
+// JSON keeps its structure and key order without insignificant whitespace, forms
+// are listed per field, text and XML are kept as they are and binary content becomes hex
+// Parameters:
+// - contentType: Content-Type header value, empty when the sender gave none
+// - body: Body without Content-Encoding
+func bodyText(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case isJSON(mediaType), mediaType == "" && json.Valid(body):
+		var compact bytes.Buffer
+		if json.Compact(&compact, body) == nil {
+			return compact.String()
+		}
+		return textOrHex(body) // Invalid or truncated JSON
+
+	case mediaType == "application/x-www-form-urlencoded":
+		if text := formText(body); text != "" {
+			return text
+		}
+	}
+	// XML (application/xml, text/xml, ...+xml), text/* and everything else
+	return textOrHex(body)
+}
+
+// jsonFields adds the top-level scalar members of a JSON object body
+// Strings, numbers, booleans and null become "<prefix>_json_<name>" fields so they
+// can be read and filtered without parsing the body; objects and arrays are skipped
+// Parameters:
+// - message: Stored fields of the transaction
+// - prefix: "request" or "response"
+// - contentType: Content-Type header value, empty when the sender gave none
+// - body: Body without Content-Encoding
+func jsonFields(message map[string]string, prefix string, contentType string, body []byte) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if (!isJSON(mediaType) && mediaType != "") || !json.Valid(body) {
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // Keep numbers as sent, e.g. large SUPI-derived values
+	var object map[string]any
+	if decoder.Decode(&object) != nil {
+		return // Not a JSON object, or truncated
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	added := 0
+	for _, name := range names {
+		var value string
+		switch v := object[name].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = strconv.FormatBool(v)
+		case nil:
+			value = "null"
+		default:
+			continue // Object or array, kept in the body
+		}
+		key := prefix + "_json_" + name
+		if _, taken := message[key]; taken {
+			continue
+		}
+		if added == maxJSONFields {
+			return
+		}
+		message[key] = value
+		added++
+	}
+}
+
+// formText lists the fields of a URL-encoded form, one "name: value" per line
+func formText(body []byte) string {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		for _, value := range values[name] {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// textOrHex returns readable UTF-8 text as it is and anything else as hex
+func textOrHex(body []byte) string {
+	if isReadable(body) {
+		return strings.TrimSpace(string(body))
+	}
+	return hexDump(body)
+}
+
+// isJSON reports whether a media type carries JSON, e.g. application/problem+json
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isReadable reports whether data is UTF-8 text without control characters
+func isReadable(data []byte) bool {
+	return utf8.Valid(data) && !bytes.ContainsFunc(data, func(r rune) bool {
+		return r < 32 && r != '\n' && r != '\r' && r != '\t'
+	})
+}
+
+// hexDump returns binary content as hex, shortened to maxHexBytes bytes
+func hexDump(data []byte) string {
+	if len(data) <= maxHexBytes {
+		return hex.EncodeToString(data)
+	}
+	return fmt.Sprintf("%s... (%d bytes)", hex.EncodeToString(data[:maxHexBytes]), len(data))
+}
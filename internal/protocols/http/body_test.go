@@ -0,0 +1,46 @@
+package decode_http
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBodyTextCompactJSON(t *testing.T) {
+	body := []byte("{\n  \"supi\": \"imsi-001010000000001\",\n  \"guami\": {\"plmnId\": {\"mcc\": \"001\", \"mnc\": \"01\"}}\n}\n")
+	want := `{"supi":"imsi-001010000000001","guami":{"plmnId":{"mcc":"001","mnc":"01"}}}`
+	for _, contentType := range []string{"application/json", "application/problem+json; charset=utf-8", ""} {
+		if got := bodyText(contentType, body); got != want {
+			t.Errorf("bodyText(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        map[string]string
+	}{
+		{
+			name:        "scalars",
+			contentType: "application/json",
+			body:        `{"amfInstanceId":"c9e1","ratType":"NR","initialRegistrationInd":true,"pei":null,"n1N2FailureTxfNotifURI":"","priority":12345678901234567890,"guami":{"amfId":"cafe00"},"backupAmfInfo":[]}`,
+			want: map[string]string{
+				"request_json_amfInstanceId": "c9e1", "request_json_ratType": "NR", "request_json_initialRegistrationInd": "true",
+				"request_json_pei": "null", "request_json_n1N2FailureTxfNotifURI": "", "request_json_priority": "12345678901234567890",
+			},
+		},
+		{name: "no content type", body: `{"cause":"USER_NOT_FOUND"}`, want: map[string]string{"request_json_cause": "USER_NOT_FOUND"}},
+		{name: "array", contentType: "application/json", body: `[{"nfType":"AMF"}]`, want: map[string]string{}},
+		{name: "truncated", contentType: "application/json", body: `{"supi":"imsi-0010`, want: map[string]string{}},
+		{name: "text", contentType: "text/plain", body: `{"supi":"imsi-001010000000001"}`, want: map[string]string{}},
+	}
+	for _, tt := range tests {
+		message := map[string]string{}
+		jsonFields(message, "request", tt.contentType, []byte(tt.body))
+		if !reflect.DeepEqual(message, tt.want) {
+			t.Errorf("%s: fields = %v, want %v", tt.name, message, tt.want)
+		}
+	}
+}
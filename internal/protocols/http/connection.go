@@ -0,0 +1,244 @@
+// connection.go
+// This file keeps the state of HTTP/2 connections between frames.
+// Core functionalities:
+// - One state per connection direction, linked to the opposite direction
+// - HPACK dynamic table per direction, sized by the peer's SETTINGS_HEADER_TABLE_SIZE
+// - Header blocks waiting for CONTINUATION frames
+// - Open request/response transactions per stream
+// - Flow-control send windows from SETTINGS, WINDOW_UPDATE and DATA
+//
+// Example scenarios:
+// 1. Two clients on the same host talk to one server:
+//    Each connection direction decodes headers with its own HPACK table,
+//    so indexed headers of one connection never resolve in the other
+//
+// 2. A server sends DATA until the client's INITIAL_WINDOW_SIZE is used up
+//    and no WINDOW_UPDATE arrives; the last DATA frame is marked with
+//    "flow_control": "stream send window exhausted"
+//
+// 3. A connection is closed:
+//    Its state is dropped, a new connection on the same ports starts empty
+
+package decode_http
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	frameHeaderLen   = 9     // HTTP/2 frame header
+	defaultWindow    = 65535 // Initial connection and stream window (RFC 9113 section 6.9.2)
+	defaultTableSize = 4096  // Initial HPACK dynamic table size (RFC 9113 section 6.5.2)
+)
+
+// preface is sent by HTTP/2 clients before the first frame
+var preface = []byte(http2.ClientPreface)
+
+// direction is the state of the frames one endpoint sends on a connection
+type direction struct {
+	src, dst         string    // IP addresses of the sending and receiving endpoint
+	srcPort, dstPort uint16    // TCP ports of the sending and receiving endpoint
+	seen             time.Time // Capture time of the data being decoded
+	frame            uint64    // Frame number of the data being decoded
+	closed           bool      // TCP stream ended
+
+	peer     *direction                 // Opposite direction of the same connection
+	settings map[http2.SettingID]uint32 // Settings sent by this endpoint
+	decoder  *hpack.Decoder             // HPACK state of the header blocks this endpoint sends
+	block    *pendingBlock              // Header block awaiting CONTINUATION
+
+	// Requests this endpoint sent that are not complete yet, by stream ID
+	transactions map[uint32]*transaction
+
+	// HTTP/1 requests this endpoint sent, oldest first, answered in order
+	pipeline []*transaction
+
+	// Flow control of the DATA this endpoint sends
+	// Only known when the connection start was captured
+	started       bool
+	window        int64            // Connection send window
+	initialWindow int64            // Send window of new streams, the peer's INITIAL_WINDOW_SIZE
+	streams       map[uint32]int64 // Send window per open stream
+}
+
+// pendingBlock is a header block split over several frames
+type pendingBlock struct {
+	streamID   uint32               // Stream of the HEADERS or PUSH_PROMISE frame
+	message    map[string]string    // Fields of the HEADERS or PUSH_PROMISE frame
+	endStream  bool                 // END_STREAM flag of the HEADERS frame
+	priority   *http2.PriorityParam // Priority of a HEADERS frame with the PRIORITY flag
+	promisedID uint32               // Promised stream of a PUSH_PROMISE frame, 0 for HEADERS
+	fragments  []byte               // Header block received so far
+}
+
+// Map of connection directions seen in the capture
+// Key: TCP 5-tuple of the sending endpoint, "src_ip:port>dst_ip:port"
+var directions = make(map[string]*direction)
+
+// directionKey returns the directions key of the frames sent from src to dst
+func directionKey(src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16) string {
+	return fmt.Sprintf("%s:%d>%s:%d", src_ipaddr, src_port, dst_ipaddr, dst_port)
+}
+
+// lookupDirection returns the state of the frames sent from src to dst
+// Both directions of a connection are created together
+func lookupDirection(src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16) *direction {
+	key := directionKey(src_ipaddr, dst_ipaddr, src_port, dst_port)
+	if d, ok := directions[key]; ok {
+		return d
+	}
+
+	// A new connection on the ports of one that ended in this direction only
+	peerKey := directionKey(dst_ipaddr, src_ipaddr, dst_port, src_port)
+	if old, ok := directions[peerKey]; ok {
+		old.flushTransactions()
+		old.peer.flushTransactions()
+	}
+
+	d := newDirection(src_ipaddr, dst_ipaddr, src_port, dst_port)
+	peer := newDirection(dst_ipaddr, src_ipaddr, dst_port, src_port)
+	d.peer, peer.peer = peer, d
+	directions[key] = d
+	directions[peerKey] = peer
+	return d
+}
+
+// newDirection creates the state of a direction with protocol defaults
+func newDirection(src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16) *direction {
+	return &direction{
+		src:          src_ipaddr,
+		dst:          dst_ipaddr,
+		srcPort:      src_port,
+		dstPort:      dst_port,
+		settings:     make(map[http2.SettingID]uint32),
+		decoder:      hpack.NewDecoder(defaultTableSize, nil),
+		transactions: make(map[uint32]*transaction),
+	}
+}
+
+// Close drops the state of the frames sent from src to dst
+// Called when the TCP stream ends; the opposite direction is dropped when it ends too
+// Open transactions are stored once both directions have ended
+// Parameters:
+// - src_ipaddr, dst_ipaddr: IP addresses of the sending and receiving endpoint
+// - src_port, dst_port: TCP ports of the sending and receiving endpoint
+func Close(src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16) {
+	key := directionKey(src_ipaddr, dst_ipaddr, src_port, dst_port)
+	d, ok := directions[key]
+	if !ok {
+		return
+	}
+	delete(directions, key)
+	d.closed = true
+	if d.peer.closed {
+		d.flushTransactions()
+		d.peer.flushTransactions()
+	}
+}
+
+// Flush stores the transactions still open at the end of a capture
+func Flush() {
+	keys := make([]string, 0, len(directions))
+	for key := range directions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		d := directions[key]
+		d.flushTransactions()
+		d.peer.flushTransactions() // The peer may have been closed already
+	}
+}
+
+// start resets both directions when the connection preface is seen
+// From here on the flow-control windows are known
+func (d *direction) start() {
+	for _, side := range []*direction{d, d.peer} {
+		side.started = true
+		side.window = defaultWindow
+		side.initialWindow = defaultWindow
+		side.streams = make(map[uint32]int64)
+	}
+}
+
+// applySettings records the settings this endpoint sends
+// SETTINGS_HEADER_TABLE_SIZE bounds the dynamic table the peer encodes with and
+// SETTINGS_INITIAL_WINDOW_SIZE changes the send windows of the peer's streams
+func (d *direction) applySettings(f *http2.SettingsFrame) {
+	f.ForeachSetting(func(setting http2.Setting) error {
+		d.settings[setting.ID] = setting.Val
+		if setting.ID == http2.SettingHeaderTableSize {
+			// The peer announces the new size with a dynamic table size update
+			d.peer.decoder.SetAllowedMaxDynamicTableSize(setting.Val)
+		}
+		if setting.ID == http2.SettingInitialWindowSize && d.peer.started {
+			delta := int64(setting.Val) - d.peer.initialWindow
+			d.peer.initialWindow = int64(setting.Val)
+			for id := range d.peer.streams {
+				d.peer.streams[id] += delta
+			}
+		}
+		return nil
+	})
+}
+
+// peerWindowUpdate applies a WINDOW_UPDATE this endpoint sends to the peer's send window
+func (d *direction) peerWindowUpdate(streamID uint32, increment int64) {
+	peer := d.peer
+	if !peer.started {
+		return
+	}
+	if streamID == 0 {
+		peer.window += increment
+		return
+	}
+	peer.streams[streamID] = peer.streamWindow(streamID) + increment
+}
+
+// consume charges a DATA frame this endpoint sends against its send windows
+// Parameters:
+// - streamID: Stream of the DATA frame
+// - length: Frame payload length including padding
+// - endStream: END_STREAM flag, the stream window is no longer needed
+//
+// Returns: Description of an exhausted window, empty otherwise
+func (d *direction) consume(streamID uint32, length int64, endStream bool) string {
+	if !d.started {
+		return ""
+	}
+	d.window -= length
+	stream := d.streamWindow(streamID) - length
+	if endStream {
+		delete(d.streams, streamID)
+	} else {
+		d.streams[streamID] = stream
+	}
+
+	switch {
+	case d.window <= 0:
+		return fmt.Sprintf("connection send window exhausted (%d)", d.window)
+	case stream <= 0:
+		return fmt.Sprintf("stream send window exhausted (%d)", stream)
+	}
+	return ""
+}
+
+// closeStream forgets the send windows of a reset stream
+func (d *direction) closeStream(streamID uint32) {
+	delete(d.streams, streamID)
+	delete(d.peer.streams, streamID)
+}
+
+// streamWindow returns the send window of a stream, the initial window for new streams
+func (d *direction) streamWindow(streamID uint32) int64 {
+	if window, ok := d.streams[streamID]; ok {
+		return window
+	}
+	return d.initialWindow
+}
@@ -0,0 +1,70 @@
+package decode_http
+
+import (
+	database "DeepPacketAI/internal/storage"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// TestInterleavedConnections sends the HEADERS of two clients on one host to the
+// same server port alternately; indexed headers must resolve in their own
+// connection's dynamic table only
+func TestInterleavedConnections(t *testing.T) {
+	Reset()
+	database.Take()
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	clients := []*endpoint{newEndpoint("10.0.0.1", 40000), newEndpoint("10.0.0.1", 40001)}
+	servers := []*endpoint{newEndpoint("10.0.0.2", 8080), newEndpoint("10.0.0.2", 8080)} // One HPACK encoder per connection
+	supi := []string{"imsi-001010000000001", "imsi-001010000000002"}
+	status := []string{"200", "404"}
+
+	frame := uint64(0)
+	for _, streamID := range []uint32{1, 3, 5} {
+		// Repeated fields are sent as indexes into the client's dynamic table
+		for i, client := range clients {
+			client.frame.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, EndStream: true, EndHeaders: true,
+				BlockFragment: client.headers(":method", "GET", ":path", "/nudm-sdm/v2/"+supi[i]+"/am-data",
+					"user-agent", fmt.Sprintf("AMF-%d", i), "x-stream", fmt.Sprint(streamID))})
+			frame++
+			client.send(servers[i], start.Add(time.Duration(frame)*time.Millisecond), frame)
+		}
+		for i, server := range servers {
+			server.frame.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, EndStream: true, EndHeaders: true,
+				BlockFragment: server.headers(":status", status[i], "server", fmt.Sprintf("UDM-%d", i))})
+			frame++
+			server.send(clients[i], start.Add(time.Duration(frame)*time.Millisecond), frame)
+		}
+	}
+
+	records := transactions(t)
+	if len(records) != 6 {
+		t.Fatalf("%d transactions, want 6: %v", len(records), records)
+	}
+	var got []string
+	for _, r := range records {
+		if r["state"] != "complete" {
+			t.Errorf("transaction %v not complete", r)
+		}
+		headers := strings.ReplaceAll(r["request_headers"]+" "+r["response_headers"], "\n", " ")
+		got = append(got, fmt.Sprintf("%s stream %s %s %s %s", r["connection"], r["stream_id"], r[":path"], r[":status"], headers))
+	}
+	sort.Strings(got)
+
+	var want []string
+	for i := range clients {
+		for _, streamID := range []int{1, 3, 5} {
+			want = append(want, fmt.Sprintf("10.0.0.1:4000%d -> 10.0.0.2:8080 stream %d /nudm-sdm/v2/%s/am-data %s user-agent: AMF-%d x-stream: %d server: UDM-%d",
+				i, streamID, supi[i], status[i], i, streamID, i))
+		}
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("transaction\n got %s\nwant %s", got[i], want[i])
+		}
+	}
+}
@@ -1,18 +1,24 @@
 // http2.go
 // This file implements HTTP/2 protocol processing for DeepPacketAI.
 // Core functionalities:
-// - Decodes HTTP/2 frames (HEADERS, DATA)
-// - Handles HPACK header compression
+// - Decodes every HTTP/2 frame type of RFC 9113, including the connection preface,
+//   and skips frames of extension types such as ALTSVC and ORIGIN
+// - Joins header blocks split over CONTINUATION frames
+// - Handles HPACK header compression per connection direction
 // - Processes JSON payloads
 //
 // Example scenarios:
 // 1. HEADERS Frame Processing:
 //    Input: HTTP/2 HEADERS frame
-//    Output: Decoded headers {"method": "GET", "path": "/api"}
+//    Output: Decoded headers {":method": "GET", ":path": "/api", "stream_id": "1"}
 //
 // 2. DATA Frame Processing:
 //    Input: HTTP/2 DATA frame with JSON
 //    Output: Formatted payload for analysis
+//
+// 3. Connection Problems:
+//    Input: RST_STREAM or GOAWAY frame
+//    Output: {"frame_type": "RST_STREAM", "stream_id": "5", "error_code": "CANCEL"}
 
 // Package decode_http provides HTTP/2 frame processing capabilities
 package decode_http
@@ -21,189 +27,246 @@ package decode_http
 import (
 	database "DeepPacketAI/internal/storage" // Database operations for storing processed frames
 	"bytes"                                  // Byte manipulation utilities
-	"encoding/json"                          // JSON encoding/decoding
-	"regexp"                                 // Regular expression for payload formatting
+	"encoding/binary"                        // Frame header fields
+	"encoding/hex"                           // PING payload formatting
+	"fmt"                                    // Error descriptions
+	"strconv"                                // Numeric field formatting
 	"strings"                                // String manipulation utilities
+	"time"                                   // Capture timestamps
 
 	"golang.org/x/net/http2"       // HTTP/2 protocol implementation
 	"golang.org/x/net/http2/hpack" // HPACK header compression
 )
 
-// Process handles HTTP/2 frame processing and analysis
+// Process decodes the HTTP/2 frames of one connection direction
+// HEADERS, CONTINUATION and DATA frames are joined into one transaction per
+// stream, stored when it ends; every other frame becomes a message of its own
 // Parameters:
-// - p: Raw frame payload bytes
+// - p: Whole frames in stream order, may start with the client preface
 // - src_ipaddr: Source IP (e.g., "192.168.1.1")
 // - dst_ipaddr: Destination IP (e.g., "10.0.0.1")
-// - time: Timestamp (e.g., "2024-03-20T15:04:05Z")
+// - src_port: Source TCP port
+// - dst_port: Destination TCP port
+// - seen: Capture time, kept to the nanosecond for request latency
 // - frame_num: Sequential frame identifier
-func Process(p []byte, src_ipaddr string, dst_ipaddr string, time string, frame_num uint64) {
-	// Create a byte reader for processing HTTP/2 frames
-	// Used by framer to read frame data sequentially
-	r := bytes.NewReader(p)
-
-	// Initialize HTTP/2 framer for decoding frames
-	// nil writer as we're only reading frames
-	framer := http2.NewFramer(nil, r)
-	frame, err := framer.ReadFrame()
-	if err != nil {
-		return // Skip processing if frame is invalid
-	}
+func Process(p []byte, src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16, seen time.Time, frame_num uint64) {
+	d := lookupDirection(src_ipaddr, dst_ipaddr, src_port, dst_port)
+	d.seen, d.frame = seen, frame_num
 
-	// Initialize map to store processed frame content
-	// Keys: header fields, Values: corresponding values
-	var message = make(map[string]string)
-
-	// Process frame based on its type (HEADERS or DATA)
-	if frame.Header().Type.String() == "HEADERS" {
-		// Process HEADERS frame using HPACK decoder
-		message = processHeader(frame, dst_ipaddr)
-	} else if frame.Header().Type.String() == "DATA" {
-		// Extract and process DATA frame payload
-		dataframe := frame.(*http2.DataFrame)
-		p = dataframe.Data()
-		data := formatPayload(p)
-		message = extractMsgContent(data, message)
+	// The client preface opens the connection, flow-control windows start now
+	if bytes.HasPrefix(p, preface) {
+		d.start()
+		p = p[len(preface):]
 	}
 
-	// Attempt to read next frame if present
-	// Handles multi-frame messages (e.g., HEADERS followed by DATA)
-	frame, err = framer.ReadFrame()
-	if err != nil {
-		// Continue with existing message if no more frames
-	} else if frame.Header().Type.String() == "DATA" {
-		// Process additional DATA frame
-		dataframe := (frame.(*http2.DataFrame))
-		p = dataframe.Data()
-		data := formatPayload(p)
-		message = extractMsgContent(data, message)
-	} else if frame.Header().Type.String() == "HEADERS" {
-		// Process additional HEADERS frame
-		message = processHeader(frame, src_ipaddr)
-	}
+	// Decode every frame in the data
+	for len(p) >= frameHeaderLen {
+		length := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		if len(p) < frameHeaderLen+length {
+			return // Truncated frame, reassembly only passes whole frames
+		}
+		raw := p[:frameHeaderLen+length]
+		p = p[frameHeaderLen+length:]
 
-	// Format JSON content if present in message
-	if content, ok := message["content"]; ok {
-		// Create buffer for formatted JSON
-		var formattedJSON bytes.Buffer
-		// Add indentation for readability
-		json.Indent(&formattedJSON, []byte(content), "", "  ")
-		// Update message with formatted JSON
-		message["content"] = formattedJSON.String()
-	}
+		// Frames of unknown types (ALTSVC, ORIGIN, extensions) are ignored (RFC 9113 section 5.5)
+		if http2.FrameType(raw[3]) > http2.FrameContinuation {
+			continue
+		}
+
+		message := d.processFrame(raw)
 
-	// Store processed frame data in database
-	if len(message) != 0 {
-		database.Insert(src_ipaddr, dst_ipaddr, "http", time, frame_num, message)
+		// Store processed frame data in database
+		if len(message) != 0 {
+			database.Insert(src_ipaddr, dst_ipaddr, "http", seen.Format(time.RFC3339), frame_num, message)
+		}
 	}
 }
 
-// Map to store HPACK decoders for different connections
-// Key: IP address identifies the connection
-// Value: HPACK decoder instance for that connection
-var hpackDecoder = make(map[string]*hpack.Decoder)
-
-// processHeader decodes HTTP/2 HEADERS frames using HPACK
+// processFrame decodes one frame and updates the connection state
 // Parameters:
-// - frame: HTTP/2 frame containing headers
-// - ip_addr: IP address for decoder lookup
-// Returns: Map of decoded headers
-func processHeader(frame http2.Frame, ip_addr string) map[string]string {
-	// Convert generic frame to HeadersFrame type
-	headersframe := frame.(*http2.HeadersFrame)
-
-	// Get existing decoder or create new one for this connection
-	decoder, ok := hpackDecoder[ip_addr]
-	if !ok {
-		// Initialize new decoder with 4KB dynamic table size
-		decoder = hpack.NewDecoder(4096, func(f hpack.HeaderField) {})
-		// Store decoder for future use
-		hpackDecoder[ip_addr] = decoder
-	}
+// - raw: Frame header and payload
+// Returns: Message fields, empty for frames that are part of a transaction
+func (d *direction) processFrame(raw []byte) map[string]string {
+	// A framer per frame, frame order is checked here across calls
+	framer := http2.NewFramer(nil, bytes.NewReader(raw))
+	framer.AllowIllegalReads = true
+	frame, err := framer.ReadFrame()
 
-	// Decode HPACK-encoded headers
-	// Returns error if header block is malformed
-	headerFields, err := decoder.DecodeFull(headersframe.HeaderBlockFragment())
+	header := http2.FrameHeader{
+		Type:     http2.FrameType(raw[3]),
+		Flags:    http2.Flags(raw[4]),
+		Length:   uint32(len(raw) - frameHeaderLen),
+		StreamID: binary.BigEndian.Uint32(raw[5:9]) & 0x7fffffff,
+	}
+	message := frameMessage(header)
 	if err != nil {
-		// Return empty map if decoding fails
-		return map[string]string{}
+		message["error"] = err.Error()
+		return message
 	}
 
-	// Convert decoded header fields to map
-	headers := make(map[string]string)
-	for _, hf := range headerFields {
-		headers[hf.Name] = hf.Value
+	// Only CONTINUATION may follow a header block without END_HEADERS
+	if d.block != nil && header.Type != http2.FrameContinuation {
+		message["error"] = fmt.Sprintf("%s frame interrupts the header block of stream %d", header.Type, d.block.streamID)
+		d.block = nil
 	}
 
-	return headers
-}
+	switch f := frame.(type) {
+	case *http2.HeadersFrame:
+		d.block = &pendingBlock{streamID: f.StreamID, message: message, endStream: f.StreamEnded()}
+		if f.HasPriority() {
+			addPriority(message, f.Priority)
+			d.block.priority = &f.Priority
+		}
+		return d.headerBlock(f.HeaderBlockFragment(), f.HeadersEnded())
 
-// formatPayload cleans and standardizes raw payload data
-// Parameters:
-// - p: Raw payload bytes to format
-// Returns: Formatted string with consistent spacing
-func formatPayload(p []byte) string {
-	// Keywords to search for
-	keywords := []string{":path", "content-type", "location"}
-
-	// Convert input []byte to string
-	str := string(p)
-
-	// Replace non-printable characters and control characters with newline
-	data := bytes.Map(func(r rune) rune {
-		if r < 32 || r > 126 {
-			return '\n'
+	case *http2.PushPromiseFrame:
+		message["promised_stream_id"] = strconv.FormatUint(uint64(f.PromiseID), 10)
+		d.block = &pendingBlock{streamID: f.StreamID, message: message, promisedID: f.PromiseID}
+		return d.headerBlock(f.HeaderBlockFragment(), f.HeadersEnded())
+
+	case *http2.ContinuationFrame:
+		if d.block == nil || d.block.streamID != f.StreamID {
+			message["error"] = "CONTINUATION frame without a header block"
+			d.block = nil
+			return message
+		}
+		return d.headerBlock(f.HeaderBlockFragment(), f.HeadersEnded())
+
+	case *http2.DataFrame:
+		stall := d.consume(f.StreamID, int64(f.Length), f.StreamEnded())
+		if d.onData(f.StreamID, f.Data(), f.StreamEnded(), stall) {
+			return nil
+		}
+
+		// Stream opened before the capture started, keep the frame on its own
+		if body := bodyText("", f.Data()); body != "" { // Content-Type is unknown
+			message["content"] = body
+		}
+		if stall != "" {
+			message["flow_control"] = stall
 		}
-		return r
-	}, []byte(str))
 
-	// Convert back to string
-	cleaned_str := string(data)
+	case *http2.SettingsFrame:
+		f.ForeachSetting(func(setting http2.Setting) error {
+			message["SETTINGS_"+setting.ID.String()] = strconv.FormatUint(uint64(setting.Val), 10)
+			return nil
+		})
+		if !f.IsAck() {
+			d.applySettings(f)
+		}
 
-	// Remove leading and trailing whitespace
-	trimmed_str := strings.TrimSpace(cleaned_str)
+	case *http2.WindowUpdateFrame:
+		message["window_increment"] = strconv.FormatUint(uint64(f.Increment), 10)
+		d.peerWindowUpdate(f.StreamID, int64(f.Increment))
 
-	// Split the string by newlines
-	lines := strings.Split(trimmed_str, "\n")
+	case *http2.RSTStreamFrame:
+		message["error_code"] = f.ErrCode.String()
+		d.closeStream(f.StreamID)
+		d.onReset(f.StreamID, f.ErrCode.String())
 
-	// Filter out empty lines
-	var non_empty_lines []string
-	for _, line := range lines {
-		if line != "" {
-			non_empty_lines = append(non_empty_lines, line)
+	case *http2.GoAwayFrame:
+		message["last_stream_id"] = strconv.FormatUint(uint64(f.LastStreamID), 10)
+		message["error_code"] = f.ErrCode.String()
+		if debug := f.DebugData(); len(debug) > 0 {
+			message["debug_data"] = string(debug)
 		}
+
+	case *http2.PingFrame:
+		message["opaque_data"] = hex.EncodeToString(f.Data[:])
+
+	case *http2.PriorityFrame:
+		addPriority(message, f.PriorityParam)
 	}
+	return message
+}
 
-	// Search for keywords and replace the next character with newline if a keyword is found
-	for i, line := range non_empty_lines {
-		for _, keyword := range keywords {
-			index := strings.Index(line, keyword)
-			if index != -1 && index+len(keyword) < len(line) {
-				if line[index+len(keyword)] != '\n' && line[index+len(keyword)] != ' ' {
-					non_empty_lines[i] = line[:index+len(keyword)] + "\n" + line[index+len(keyword)+1:]
-				}
-			}
-		}
+// headerBlock collects a header block split over CONTINUATION frames
+// The complete block opens or continues the transaction of its stream
+// Parameters:
+// - fragment: Header block fragment of the frame
+// - ended: END_HEADERS flag of the frame
+// Returns: Fields of the HEADERS or PUSH_PROMISE frame if HPACK decoding failed
+func (d *direction) headerBlock(fragment []byte, ended bool) map[string]string {
+	d.block.fragments = append(d.block.fragments, fragment...)
+	if !ended {
+		return nil // Wait for CONTINUATION
 	}
 
-	// Join the non-empty lines with new lines
-	result := strings.Join(non_empty_lines, "\n")
+	block := d.block
+	d.block = nil
+	fields, err := d.processHeader(block.fragments)
+	if block.promisedID != 0 {
+		d.onPushPromise(block.promisedID, fields)
+	} else {
+		d.onHeaders(block.streamID, fields, block.endStream, block.priority)
+	}
 
-	return result
+	// The dynamic table is out of step now, report the frame itself
+	if err != nil {
+		block.message["error"] = "HPACK: " + err.Error()
+		return block.message
+	}
+	return nil
 }
 
-// extractMsgContent processes a string payload to extract JSON content
-// Parameters:
-// - p: Input string containing potential JSON content
-// - result: Map to store extracted content
-// Returns: Updated map with JSON content if found
-func extractMsgContent(p string, result map[string]string) map[string]string {
-	// Find JSON objects or arrays in content
-	pattern := regexp.MustCompile(`(?s){[\s\S]*}|(?s)\[[\s\S]*\]`)
-	matches := pattern.FindString(p)
-
-	// Store valid JSON content
-	if len(matches) > 3 {
-		result["content"] = matches
+// frameMessage creates the message fields every frame has
+// Example: {"frame_type": "HEADERS", "stream_id": "1", "flags": "END_STREAM|END_HEADERS"}
+func frameMessage(header http2.FrameHeader) map[string]string {
+	message := map[string]string{
+		"frame_type": header.Type.String(),
+		"stream_id":  strconv.FormatUint(uint64(header.StreamID), 10),
+	}
+	if names := flagNames(header.Type, header.Flags); names != "" {
+		message["flags"] = names
+	}
+	return message
+}
+
+// addPriority adds stream priority fields of HEADERS and PRIORITY frames
+func addPriority(message map[string]string, priority http2.PriorityParam) {
+	message["stream_dependency"] = strconv.FormatUint(uint64(priority.StreamDep), 10)
+	message["weight"] = strconv.Itoa(int(priority.Weight) + 1) // Sent as weight-1
+	message["exclusive"] = strconv.FormatBool(priority.Exclusive)
+}
+
+// flagFrames names the flags defined per frame type (RFC 9113 section 6)
+var flagFrames = map[http2.FrameType][]struct {
+	flag http2.Flags
+	name string
+}{
+	http2.FrameData:         {{http2.FlagDataEndStream, "END_STREAM"}, {http2.FlagDataPadded, "PADDED"}},
+	http2.FrameHeaders:      {{http2.FlagHeadersEndStream, "END_STREAM"}, {http2.FlagHeadersEndHeaders, "END_HEADERS"}, {http2.FlagHeadersPadded, "PADDED"}, {http2.FlagHeadersPriority, "PRIORITY"}},
+	http2.FrameSettings:     {{http2.FlagSettingsAck, "ACK"}},
+	http2.FramePing:         {{http2.FlagPingAck, "ACK"}},
+	http2.FrameContinuation: {{http2.FlagContinuationEndHeaders, "END_HEADERS"}},
+	http2.FramePushPromise:  {{http2.FlagPushPromiseEndHeaders, "END_HEADERS"}, {http2.FlagPushPromisePadded, "PADDED"}},
+}
+
+// flagNames returns the set flags of a frame joined by "|"
+func flagNames(frameType http2.FrameType, flags http2.Flags) string {
+	var names []string
+	for _, f := range flagFrames[frameType] {
+		if flags.Has(f.flag) {
+			names = append(names, f.name)
+		}
 	}
-	return result
+	return strings.Join(names, "|")
+}
+
+// Reset discards the connection state of the previous capture
+// Called before a new set of captures is decoded
+func Reset() {
+	directions = make(map[string]*direction)
+}
+
+// processHeader decodes an HTTP/2 header block using HPACK
+// Each direction of a connection has its own dynamic table, so blocks must
+// be decoded in the order the endpoint sent them
+// Parameters:
+// - block: Complete header block of a HEADERS or PUSH_PROMISE frame
+// Returns: Decoded header fields in order, and the HPACK error that ended decoding
+func (d *direction) processHeader(block []byte) ([]hpack.HeaderField, error) {
+	// Decode HPACK-encoded headers
+	// Returns error if header block is malformed
+	return d.decoder.DecodeFull(block)
 }
@@ -0,0 +1,298 @@
+// http1.go
+// This file implements HTTP/1.x processing on reassembled TCP streams.
+// Core functionalities:
+// - Finds message boundaries (Content-Length, chunked encoding, close-delimited)
+// - Pairs pipelined requests and responses in order on a keep-alive connection
+// - Stores each exchange in the same transaction shape as HTTP/2
+//
+// Example scenarios:
+// 1. REST call:
+//    "GET /health HTTP/1.1" answered with "HTTP/1.1 200 OK" becomes
+//    {":method": "GET", ":path": "/health", ":status": "200", "version": "HTTP/1.1", ...}
+//
+// 2. Pipelining:
+//    Three requests sent back to back are answered in order, each response
+//    is joined with the oldest unanswered request
+//
+// 3. Chunked response:
+//    The body is decoded from its chunks before it is stored
+
+package decode_http
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// maxHTTP1Header bounds the start line and headers of a message
+// Streams without a header end within this size are not HTTP/1
+const maxHTTP1Header = 64 * 1024
+
+// http1Methods start HTTP/1 request lines
+var http1Methods = []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// LooksLikeHTTP1 reports whether data starts with an HTTP/1 request or status line
+// Returns false while data is too short to tell
+func LooksLikeHTTP1(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("HTTP/1.")) {
+		return true
+	}
+	for _, method := range http1Methods {
+		if bytes.HasPrefix(data, []byte(method)) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTP1Length returns the length of the HTTP/1 message at the start of data
+// 0 when more data is needed (including responses delimited by connection
+// close), -1 when data does not start with a message
+// Parameters:
+// - data: Reassembled bytes of one connection direction
+// - src_ipaddr, dst_ipaddr: IP addresses of the sending and receiving endpoint
+// - src_port, dst_port: TCP ports of the sending and receiving endpoint
+func HTTP1Length(data []byte, src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16) int {
+	if len(data) < 8 {
+		return 0
+	}
+	if !LooksLikeHTTP1(data) {
+		return -1
+	}
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(data) > maxHTTP1Header {
+			return -1
+		}
+		return 0
+	}
+	headerLen := end + 4
+
+	// Start line and the headers deciding the body length
+	lines := strings.Split(string(data[:end]), "\r\n")
+	response := strings.HasPrefix(lines[0], "HTTP/")
+	contentLength := -1
+	chunked := false
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				contentLength = n
+			}
+		case "transfer-encoding":
+			chunked = strings.Contains(strings.ToLower(value), "chunked")
+		}
+	}
+
+	// Responses without a body (RFC 9112 section 6.3)
+	if response {
+		fields := strings.Fields(lines[0])
+		status := ""
+		if len(fields) > 1 {
+			status = fields[1]
+		}
+		d := lookupDirection(src_ipaddr, dst_ipaddr, src_port, dst_port)
+		if strings.HasPrefix(status, "1") || status == "204" || status == "304" || d.peer.pendingMethod() == http.MethodHead {
+			return headerLen
+		}
+	}
+
+	switch {
+	case chunked:
+		n := chunkedLength(data[headerLen:])
+		if n <= 0 {
+			return n
+		}
+		return headerLen + n
+	case contentLength >= 0:
+		return headerLen + contentLength
+	case response:
+		return 0 // Body ends when the server closes the connection
+	}
+	return headerLen // Requests without length have no body
+}
+
+// chunkedLength returns the length of a chunked body including trailers
+// 0 when more data is needed, -1 for a malformed chunk size
+func chunkedLength(body []byte) int {
+	pos := 0
+	for {
+		lineEnd := bytes.Index(body[pos:], []byte("\r\n"))
+		if lineEnd < 0 {
+			return 0
+		}
+		sizeText, _, _ := strings.Cut(string(body[pos:pos+lineEnd]), ";") // Drop chunk extensions
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+		if err != nil || size < 0 {
+			return -1
+		}
+		pos += lineEnd + 2
+
+		if size == 0 {
+			// Trailer section ends with an empty line
+			for {
+				lineEnd = bytes.Index(body[pos:], []byte("\r\n"))
+				if lineEnd < 0 {
+					return 0
+				}
+				pos += lineEnd + 2
+				if lineEnd == 0 {
+					return pos
+				}
+			}
+		}
+
+		pos += int(size) + 2 // Chunk data and its CRLF
+		if pos > len(body) {
+			return 0
+		}
+	}
+}
+
+// ProcessHTTP1 decodes one HTTP/1 message of a connection direction
+// Requests are queued; a response completes the oldest unanswered request
+// Parameters:
+// - p: One whole request or response, as found by HTTP1Length
+// - src_ipaddr: Source IP (e.g., "192.168.1.1")
+// - dst_ipaddr: Destination IP (e.g., "10.0.0.1")
+// - src_port: Source TCP port
+// - dst_port: Destination TCP port
+// - seen: Capture time of the last byte
+// - frame_num: Sequential frame identifier
+func ProcessHTTP1(p []byte, src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16, seen time.Time, frame_num uint64) {
+	d := lookupDirection(src_ipaddr, dst_ipaddr, src_port, dst_port)
+	d.seen, d.frame = seen, frame_num
+	r := bufio.NewReader(bytes.NewReader(p))
+
+	// Request: queue it until its response arrives
+	if !bytes.HasPrefix(p, []byte("HTTP/")) {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return // Not a parsable request
+		}
+		body, _ := io.ReadAll(req.Body)
+
+		t := &transaction{version: req.Proto, hasRequest: true, lastSeen: seen, lastFrame: frame_num}
+		t.request = append([]hpack.HeaderField{
+			{Name: ":method", Value: req.Method},
+			{Name: ":path", Value: req.RequestURI},
+			{Name: ":authority", Value: req.Host},
+		}, http1Fields(req.Header)...)
+		t.reqBody = t.appendBody(nil, body)
+		t.reqTime, t.reqFrame = seen, frame_num
+		t.reqEnded = true
+		d.pipeline = append(d.pipeline, t)
+		return
+	}
+
+	// Response: answer the oldest request of the peer
+	resp, err := http.ReadResponse(r, &http.Request{Method: d.peer.pendingMethod()})
+	if err != nil {
+		return // Not a parsable response
+	}
+	body, _ := io.ReadAll(resp.Body)
+	status := strconv.Itoa(resp.StatusCode)
+	interim := resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols
+
+	if len(d.peer.pipeline) == 0 {
+		if interim {
+			return // Nothing waits for the final response
+		}
+		// Request sent before the capture started
+		d.peer.pipeline = append(d.peer.pipeline, &transaction{})
+	}
+	t := d.peer.pipeline[0]
+	t.touch(d)
+
+	if interim {
+		t.interim = append(t.interim, status) // e.g. 100 Continue, the final response follows
+		return
+	}
+	t.version = resp.Proto
+	t.response = append([]hpack.HeaderField{{Name: ":status", Value: status}}, http1Fields(resp.Header)...)
+	if reason := strings.TrimSpace(strings.TrimPrefix(resp.Status, status)); reason != "" {
+		t.response = append(t.response, hpack.HeaderField{Name: ":reason", Value: reason})
+	}
+	t.respBody = t.appendBody(nil, body)
+	t.respTime, t.respFrame = seen, frame_num
+
+	d.peer.pipeline = d.peer.pipeline[1:]
+	d.peer.complete(t, "complete")
+}
+
+// CloseHTTP1 ends an HTTP/1 connection direction
+// A response delimited by the connection close is decoded from the rest of the stream
+// Parameters:
+// - rest: Bytes left after the last whole message
+// - src_ipaddr, dst_ipaddr: IP addresses of the sending and receiving endpoint
+// - src_port, dst_port: TCP ports of the sending and receiving endpoint
+// - seen: Capture time of the last byte
+// - frame_num: Frame number of the last byte
+func CloseHTTP1(rest []byte, src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16, seen time.Time, frame_num uint64) {
+	if bytes.HasPrefix(rest, []byte("HTTP/1.")) {
+		ProcessHTTP1(rest, src_ipaddr, dst_ipaddr, src_port, dst_port, seen, frame_num)
+	}
+	Close(src_ipaddr, dst_ipaddr, src_port, dst_port)
+}
+
+// WebSocketUpgrade reports whether an HTTP/1 message switches its connection direction
+// to WebSocket: a request with "Upgrade: websocket" or a 101 response accepting it
+// Parameters:
+// - p: One whole request or response, as found by HTTP1Length
+func WebSocketUpgrade(p []byte) bool {
+	end := bytes.Index(p, []byte("\r\n\r\n"))
+	if end < 0 {
+		return false
+	}
+	lines := strings.Split(string(p[:end]), "\r\n")
+	if fields := strings.Fields(lines[0]); strings.HasPrefix(lines[0], "HTTP/") && (len(fields) < 2 || fields[1] != "101") {
+		return false // Only 101 Switching Protocols changes the response direction
+	}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "upgrade") &&
+			strings.EqualFold(strings.TrimSpace(value), "websocket") {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingMethod returns the method of the oldest unanswered request this endpoint sent
+func (d *direction) pendingMethod() string {
+	if len(d.pipeline) == 0 {
+		return ""
+	}
+	return headerValue(d.pipeline[0].request, ":method")
+}
+
+// http1Fields converts HTTP/1 headers to lowercase fields like HTTP/2 uses
+// Host is stored as :authority
+func http1Fields(header http.Header) []hpack.HeaderField {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []hpack.HeaderField
+	for _, name := range names {
+		for _, value := range header[name] {
+			fields = append(fields, hpack.HeaderField{Name: strings.ToLower(name), Value: value})
+		}
+	}
+	return fields
+}
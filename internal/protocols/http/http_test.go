@@ -0,0 +1,210 @@
+package decode_http
+
+import (
+	database "DeepPacketAI/internal/storage"
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// endpoint writes the frames one side of a test connection sends
+// Header blocks are encoded with the endpoint's own HPACK table
+type endpoint struct {
+	ip    string
+	port  uint16
+	frame *http2.Framer
+	out   bytes.Buffer
+	block bytes.Buffer
+	hpack *hpack.Encoder
+}
+
+func newEndpoint(ip string, port uint16) *endpoint {
+	e := &endpoint{ip: ip, port: port}
+	e.frame = http2.NewFramer(&e.out, nil)
+	e.hpack = hpack.NewEncoder(&e.block)
+	return e
+}
+
+// headers encodes fields as name/value pairs into a header block
+func (e *endpoint) headers(fields ...string) []byte {
+	e.block.Reset()
+	for i := 0; i+1 < len(fields); i += 2 {
+		e.hpack.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	return bytes.Clone(e.block.Bytes())
+}
+
+// send passes the frames written so far to Process as one segment to peer
+func (e *endpoint) send(peer *endpoint, seen time.Time, frame uint64) {
+	Process(e.out.Bytes(), e.ip, peer.ip, e.port, peer.port, seen, frame)
+	e.out.Reset()
+}
+
+// transactions flushes open streams and returns the stored HTTP records
+// Frame messages are an error unless their type is listed in frames
+func transactions(t *testing.T, frames ...string) []map[string]string {
+	t.Helper()
+	Flush()
+	var records []map[string]string
+	for _, m := range database.Take() {
+		if m.Protocol != "http" {
+			continue
+		}
+		if frameType, ok := m.Message["frame_type"]; ok {
+			if slices.Contains(frames, frameType) {
+				continue
+			}
+			t.Errorf("unexpected frame message %v", m.Message)
+			continue
+		}
+		records = append(records, m.Message)
+	}
+	return records
+}
+
+// TestHeadersPriority checks that the priority of a request HEADERS frame is kept on its transaction
+func TestHeadersPriority(t *testing.T) {
+	Reset()
+	database.Take()
+	client, server := newEndpoint("10.0.0.1", 40000), newEndpoint("10.0.0.2", 8080)
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	client.frame.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, EndStream: true, EndHeaders: true,
+		BlockFragment: client.headers(":method", "GET", ":path", "/nudm-sdm/v2/imsi-001010000000001/am-data"),
+		Priority:      http2.PriorityParam{StreamDep: 0, Weight: 255, Exclusive: true}})
+	client.frame.WriteHeaders(http2.HeadersFrameParam{StreamID: 3, EndStream: true, EndHeaders: true,
+		BlockFragment: client.headers(":method", "GET", ":path", "/nudm-sdm/v2/imsi-001010000000001/sm-data"),
+		Priority:      http2.PriorityParam{StreamDep: 1, Weight: 15}})
+	client.frame.WriteHeaders(http2.HeadersFrameParam{StreamID: 5, EndStream: true, EndHeaders: true,
+		BlockFragment: client.headers(":method", "GET", ":path", "/nudm-sdm/v2/imsi-001010000000001/nssai")})
+	client.send(server, start, 1)
+	for _, id := range []uint32{1, 3, 5} {
+		server.frame.WriteHeaders(http2.HeadersFrameParam{StreamID: id, EndStream: true, EndHeaders: true,
+			BlockFragment: server.headers(":status", "200")})
+	}
+	server.send(client, start.Add(time.Millisecond), 2)
+
+	want := map[string][3]string{ // stream_dependency, weight, exclusive
+		"1": {"0", "256", "true"},
+		"3": {"1", "16", "false"},
+		"5": {"", "", ""},
+	}
+	records := transactions(t)
+	if len(records) != len(want) {
+		t.Fatalf("%d transactions, want %d: %v", len(records), len(want), records)
+	}
+	for _, r := range records {
+		got := [3]string{r["stream_dependency"], r["weight"], r["exclusive"]}
+		if got != want[r["stream_id"]] {
+			t.Errorf("stream %s priority = %q, want %q", r["stream_id"], got, want[r["stream_id"]])
+		}
+	}
+}
+
+// http1Send cuts what one endpoint sent so far into messages like TCP reassembly
+// does and returns the bytes of the unfinished message
+func http1Send(from, to *endpoint, pending []byte, data string, seen time.Time, frame uint64) []byte {
+	pending = append(pending, data...)
+	for {
+		n := HTTP1Length(pending, from.ip, to.ip, from.port, to.port)
+		if n <= 0 || n > len(pending) {
+			return pending
+		}
+		ProcessHTTP1(pending[:n], from.ip, to.ip, from.port, to.port, seen, frame)
+		pending = pending[n:]
+	}
+}
+
+// TestHTTP1Messages sends HTTP/1.1 segments, one frame each, then closes the
+// connection and checks the stored exchanges in order
+func TestHTTP1Messages(t *testing.T) {
+	type segment struct {
+		fromServer bool
+		data       string
+	}
+	tests := []struct {
+		name     string
+		segments []segment
+		want     []string // Method, path, status, state, response frame and body
+	}{
+		{
+			name: "two pipelined requests",
+			segments: []segment{
+				{false, "GET /a HTTP/1.1\r\nHost: nrf\r\n\r\nGET /b HTTP/1.1\r\nHost: nrf\r\n\r\n"},
+				{true, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"},
+			},
+			want: []string{"GET /a 200 complete frame 2 {}", "GET /b 404 complete frame 2 "},
+		},
+		{
+			name: "response to a pipelined request split over segments",
+			segments: []segment{
+				{false, "GET /a HTTP/1.1\r\nHost: nrf\r\n\r\nPOST /b HTTP/1.1\r\nHost: nrf\r\nContent-Length: 5\r\n\r\nhel"},
+				{false, "lo"},
+				{true, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nfirst"},
+				{true, "!HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"},
+			},
+			want: []string{"GET /a 200 complete frame 4 first!", "POST /b 201 complete frame 4 "},
+		},
+		{
+			name: "chunked response with trailers",
+			segments: []segment{
+				{false, "GET /a HTTP/1.1\r\nHost: nrf\r\n\r\nGET /b HTTP/1.1\r\nHost: nrf\r\n\r\n"},
+				{true, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: Expires\r\n\r\n4\r\nWiki\r\n5;ext=1\r\npedia\r\n"},
+				{true, "0\r\nExpires: Fri, 01 Jan 2027 00:00:00 GMT\r\n"},
+				{true, "\r\nHTTP/1.1 204 No Content\r\n\r\n"},
+			},
+			want: []string{"GET /a 200 complete frame 4 Wikipedia", "GET /b 204 complete frame 4 "},
+		},
+		{
+			name: "response ending at connection close",
+			segments: []segment{
+				{false, "GET /a HTTP/1.0\r\n\r\n"},
+				{true, "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nsent until"},
+				{true, " the close"},
+			},
+			want: []string{"GET /a 200 complete frame 4 sent until the close"},
+		},
+		{
+			name: "response to HEAD without its body",
+			segments: []segment{
+				{false, "HEAD /a HTTP/1.1\r\nHost: nrf\r\n\r\nGET /b HTTP/1.1\r\nHost: nrf\r\n\r\n"},
+				{true, "HTTP/1.1 200 OK\r\nContent-Length: 120\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"},
+			},
+			want: []string{"HEAD /a 200 complete frame 2 ", "GET /b 200 complete frame 2 ok"},
+		},
+	}
+	for _, tt := range tests {
+		Reset()
+		database.Take()
+		client, server := newEndpoint("10.0.0.1", 40000), newEndpoint("10.0.0.2", 8080)
+		start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+		var toServer, toClient []byte
+		for i, s := range tt.segments {
+			seen := start.Add(time.Duration(i) * time.Millisecond)
+			if s.fromServer {
+				toClient = http1Send(server, client, toClient, s.data, seen, uint64(i+1))
+			} else {
+				toServer = http1Send(client, server, toServer, s.data, seen, uint64(i+1))
+			}
+		}
+		closed := start.Add(time.Duration(len(tt.segments)) * time.Millisecond)
+		frame := uint64(len(tt.segments) + 1) // FIN of the server
+		CloseHTTP1(toClient, server.ip, client.ip, server.port, client.port, closed, frame)
+		CloseHTTP1(toServer, client.ip, server.ip, client.port, server.port, closed, frame)
+
+		var got []string
+		for _, r := range transactions(t) {
+			got = append(got, fmt.Sprintf("%s %s %s %s frame %s %s",
+				r[":method"], r[":path"], r[":status"], r["state"], r["response_frame"], r["response_body"]))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// multipart.go
+// This file splits multipart/related bodies of 5G SBI messages into their parts.
+// Core functionalities:
+// - Separates the parts at the boundary of the Content-Type header
+// - Keeps the root JSON part as the structured body
+// - Hands binary N1 (5G NAS) and N2 (NGAP) parts to their decoders, other parts to hex
+// - Links each part to the JSON field referencing its Content-ID
+//
+// Example scenarios:
+// 1. Namf_Communication_N1N2MessageTransfer:
+//    JSON with n1MessageContainer and n2InfoContainer plus two binary parts becomes
+//    {"request_body": "{...}", "request_parts": "1: application/json; 2: application/vnd.3gpp.5gnas ...",
+//     "request_part_2": "5GSM PDU session establishment accept\n...", "request_part_2_ref": "n1MessageContent (SM)"}
+//
+// 2. Nsmf_PDUSession_UpdateSMContext response with N2 SM information:
+//    "response_part_2": "PDU_RES_SETUP_REQ\nIE 130 PDUSessionAggregateMaximumBitRate ..."
+
+package decode_http
+
+import (
+	decode_nas "DeepPacketAI/internal/protocols/nas"   // N1 message parts
+	decode_ngap "DeepPacketAI/internal/protocols/ngap" // N2 information parts
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// bodyPart is one part of a multipart body
+type bodyPart struct {
+	contentType string
+	contentID   string // Without the angle brackets
+	data        []byte
+}
+
+// partRef is the JSON field referencing a binary part by its Content-ID
+type partRef struct {
+	field  string // e.g. "n1SmMsg", "ngapData"
+	ieType string // ngapIeType, n2SmInfoType or n1MessageClass next to the reference
+}
+
+// bodyFields adds a request or response body to the stored fields
+// Content-Encoding is removed first; multipart bodies are split into parts,
+// other bodies are formatted by their Content-Type
+// Top-level scalar members of JSON bodies are added as fields, see jsonFields
+// Parameters:
+// - message: Stored fields of the transaction
+// - prefix: "request" or "response"
+// - headers: Headers of the request or response, for Content-Type and Content-Encoding
+// - body: Captured body
+func bodyFields(message map[string]string, prefix string, headers []hpack.HeaderField, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	data, encoding, err := contentDecode(headerValue(headers, "content-encoding"), body)
+	if encoding != "" {
+		message[prefix+"_encoding"] = encoding
+	}
+	if err != nil {
+		message[prefix+"_body_error"] = err.Error()
+	}
+
+	contentType := headerValue(headers, "content-type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		message[prefix+"_body"] = bodyText(contentType, data)
+		jsonFields(message, prefix, contentType, data)
+		return
+	}
+
+	parts, err := splitParts(data, params["boundary"])
+	if len(parts) == 0 {
+		message[prefix+"_body"] = textOrHex(data) // Not split at the announced boundary
+		return
+	}
+	if err != nil {
+		message[prefix+"_parts_error"] = err.Error() // e.g. body truncated inside a part
+	}
+
+	// Root part: named by the start parameter, the first part otherwise (RFC 2387)
+	root := 0
+	for i, part := range parts {
+		if params["start"] != "" && part.contentID == strings.Trim(params["start"], "<>") {
+			root = i
+		}
+	}
+	refs := make(map[string]partRef)
+	if isJSON(parts[root].contentType) {
+		var document any
+		if json.Unmarshal(parts[root].data, &document) == nil {
+			collectRefs(document, refs)
+		}
+	}
+	message[prefix+"_body"] = bodyText(parts[root].contentType, parts[root].data)
+	jsonFields(message, prefix, parts[root].contentType, parts[root].data)
+
+	var list []string
+	for i, part := range parts {
+		n := i + 1
+		entry := fmt.Sprintf("%d: %s", n, part.contentType)
+		if part.contentID != "" {
+			entry += fmt.Sprintf(" (%s, %d bytes)", part.contentID, len(part.data))
+		} else {
+			entry += fmt.Sprintf(" (%d bytes)", len(part.data))
+		}
+		list = append(list, entry)
+		if i == root {
+			continue
+		}
+
+		key := fmt.Sprintf("%s_part_%d", prefix, n)
+		ref, referenced := refs[part.contentID]
+		if referenced {
+			message[key+"_ref"] = ref.field
+			if ref.ieType != "" {
+				message[key+"_ref"] += " (" + ref.ieType + ")"
+			}
+		}
+		if text := describePart(part, ref.ieType); text != "" {
+			message[key] = text
+		}
+		if !isText(part) {
+			message[key+"_hex"] = hexDump(part.data)
+		}
+	}
+	message[prefix+"_parts"] = strings.Join(list, "; ")
+}
+
+// splitParts reads the parts of a multipart body
+// Returns the parts read so far and an error when the body ends early
+func splitParts(body []byte, boundary string) ([]bodyPart, error) {
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	var parts []bodyPart
+	for {
+		// Raw parts keep binary content as sent, without transfer decoding
+		p, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, fmt.Errorf("multipart body: %v", err)
+		}
+		data, err := io.ReadAll(p)
+		part := bodyPart{
+			contentType: p.Header.Get("Content-Type"),
+			contentID:   strings.Trim(p.Header.Get("Content-Id"), "<> "),
+			data:        data,
+		}
+		if part.contentType == "" {
+			part.contentType = "text/plain" // RFC 2046 section 5.1 default
+		}
+		parts = append(parts, part)
+		if err != nil {
+			return parts, fmt.Errorf("multipart part %d: %v", len(parts), err)
+		}
+	}
+}
+
+// describePart decodes a part by its content type
+// Returns an empty string when the content is not understood
+func describePart(part bodyPart, ieType string) string {
+	mediaType, _, _ := mime.ParseMediaType(part.contentType)
+	switch {
+	case mediaType == "application/vnd.3gpp.5gnas":
+		text, err := decode_nas.Describe(part.data)
+		if err != nil {
+			return "undecodable 5G NAS: " + err.Error()
+		}
+		return text
+	case mediaType == "application/vnd.3gpp.ngap":
+		text, err := decode_ngap.Describe(part.data, ieType)
+		if err != nil {
+			return "undecodable NGAP: " + err.Error()
+		}
+		return text
+	case isText(part):
+		return bodyText(part.contentType, part.data)
+	}
+	return ""
+}
+
+// collectRefs finds the JSON objects referencing binary parts, {"contentId": "..."}
+// The reference is named by the field holding it; the NGAP IE type or N1 message
+// class stored next to it describes the part
+func collectRefs(value any, refs map[string]partRef) {
+	switch v := value.(type) {
+	case map[string]any:
+		for field, child := range v {
+			if object, ok := child.(map[string]any); ok {
+				if id, ok := object["contentId"].(string); ok {
+					refs[id] = partRef{field: field, ieType: siblingType(v, field)}
+					continue
+				}
+			}
+			collectRefs(child, refs)
+		}
+	case []any:
+		for _, child := range v {
+			collectRefs(child, refs)
+		}
+	}
+}
+
+// siblingType returns the type attribute describing the reference in field
+// N1 references use n1MessageClass, N2 references the NGAP IE or message type
+func siblingType(object map[string]any, field string) string {
+	names := []string{"ngapIeType", "ngapMessageType", "n2SmInfoType", "n2InformationClass"}
+	if strings.HasPrefix(strings.ToLower(field), "n1") {
+		names = []string{"n1MessageClass"}
+	}
+	for _, name := range names {
+		if text, ok := object[name].(string); ok {
+			return text
+		}
+	}
+	return ""
+}
+
+// isText reports whether a part holds readable text
+func isText(part bodyPart) bool {
+	return strings.HasPrefix(part.contentType, "text/") || isJSON(part.contentType) || isReadable(part.data)
+}
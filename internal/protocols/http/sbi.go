@@ -0,0 +1,437 @@
+// sbi.go
+// This file recognizes the 5G core service-based interfaces (3GPP TS 29.500 series)
+// carried in HTTP transactions.
+// Core functionalities:
+// - Splits an SBI path into service, API version and resource
+// - Names the service operation, e.g. Nudm_SDM_Get or Nsmf_PDUSession_CreateSMContext
+// - Identifies the producer and consumer NF types from the service, :authority and user-agent
+// - Decodes ProblemDetails error bodies (TS 29.571)
+//
+// Example scenarios:
+// 1. Subscription data retrieval:
+//    GET /nudm-sdm/v2/imsi-001010000000001/am-data becomes
+//    {"sbi_service": "nudm-sdm", "sbi_operation": "Nudm_SDM_Get", "producer_nf": "UDM", ...}
+//
+// 2. PDU session setup from the AMF:
+//    POST /nsmf-pdusession/v1/sm-contexts with user-agent "AMF" becomes
+//    {"sbi_operation": "Nsmf_PDUSession_CreateSMContext", "consumer_nf": "AMF", "producer_nf": "SMF"}
+//
+// 3. Rejected request:
+//    A 403 with {"title": "Forbidden", "status": 403, "cause": "AUTHENTICATION_REJECTED"} adds
+//    {"problem_title": "Forbidden", "problem_cause": "AUTHENTICATION_REJECTED", ...}
+
+package decode_http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// sbiService describes one NF service API
+type sbiService struct {
+	nf   string // Producer NF type
+	name string // Service name used in operation names
+}
+
+// sbiServices maps the API name of an SBI path to its service (TS 29.5xx)
+var sbiServices = map[string]sbiService{
+	"nnrf-nfm":                  {"NRF", "Nnrf_NFManagement"},
+	"nnrf-disc":                 {"NRF", "Nnrf_NFDiscovery"},
+	"oauth2":                    {"NRF", "Nnrf_AccessToken"},
+	"nausf-auth":                {"AUSF", "Nausf_UEAuthentication"},
+	"nausf-sorprotection":       {"AUSF", "Nausf_SoRProtection"},
+	"nausf-upuprotection":       {"AUSF", "Nausf_UPUProtection"},
+	"nudm-sdm":                  {"UDM", "Nudm_SDM"},
+	"nudm-uecm":                 {"UDM", "Nudm_UECM"},
+	"nudm-ueau":                 {"UDM", "Nudm_UEAU"},
+	"nudm-ee":                   {"UDM", "Nudm_EE"},
+	"nudm-pp":                   {"UDM", "Nudm_PP"},
+	"nudm-mt":                   {"UDM", "Nudm_MT"},
+	"nudm-niddau":               {"UDM", "Nudm_NIDDAU"},
+	"nudr-dr":                   {"UDR", "Nudr_DataRepository"},
+	"nudr-group-id-map":         {"UDR", "Nudr_GroupIDmap"},
+	"namf-comm":                 {"AMF", "Namf_Communication"},
+	"namf-evts":                 {"AMF", "Namf_EventExposure"},
+	"namf-mt":                   {"AMF", "Namf_MT"},
+	"namf-loc":                  {"AMF", "Namf_Location"},
+	"nsmf-pdusession":           {"SMF", "Nsmf_PDUSession"},
+	"nsmf-event-exposure":       {"SMF", "Nsmf_EventExposure"},
+	"nsmf-nidd":                 {"SMF", "Nsmf_NIDD"},
+	"npcf-am-policy-control":    {"PCF", "Npcf_AMPolicyControl"},
+	"npcf-smpolicycontrol":      {"PCF", "Npcf_SMPolicyControl"},
+	"npcf-ue-policy-control":    {"PCF", "Npcf_UEPolicyControl"},
+	"npcf-policyauthorization":  {"PCF", "Npcf_PolicyAuthorization"},
+	"npcf-bdtpolicycontrol":     {"PCF", "Npcf_BDTPolicyControl"},
+	"npcf-eventexposure":        {"PCF", "Npcf_EventExposure"},
+	"nnssf-nsselection":         {"NSSF", "Nnssf_NSSelection"},
+	"nnssf-nssaiavailability":   {"NSSF", "Nnssf_NSSAIAvailability"},
+	"nsmsf-sms":                 {"SMSF", "Nsmsf_SMService"},
+	"nbsf-management":           {"BSF", "Nbsf_Management"},
+	"nchf-convergedcharging":    {"CHF", "Nchf_ConvergedCharging"},
+	"nchf-spendinglimitcontrol": {"CHF", "Nchf_SpendingLimitControl"},
+	"nnef-pfdmanagement":        {"NEF", "Nnef_PFDManagement"},
+	"nnef-eventexposure":        {"NEF", "Nnef_EventExposure"},
+	"nnwdaf-eventssubscription": {"NWDAF", "Nnwdaf_EventsSubscription"},
+	"nnwdaf-analyticsinfo":      {"NWDAF", "Nnwdaf_AnalyticsInfo"},
+	"nudsf-dr":                  {"UDSF", "Nudsf_DataRepository"},
+	"nlmf-loc":                  {"LMF", "Nlmf_Location"},
+	"ngmlc-loc":                 {"GMLC", "Ngmlc_Location"},
+	"n5g-eir-eic":               {"5G-EIR", "N5g-eir_EquipmentIdentityCheck"},
+}
+
+// sbiOperation names the service operation of a method on a resource
+// Resource patterns are matched segment by segment:
+// "*" matches one segment, a trailing "**" matches the rest of the path
+type sbiOperation struct {
+	service   string
+	method    string // Empty for any method
+	resource  string
+	operation string
+}
+
+// sbiOperations are tried in order, the first match names the operation
+// Specific resources come before the catch-all rules of a service
+var sbiOperations = []sbiOperation{
+	// NRF (TS 29.510)
+	{"nnrf-nfm", "PUT", "nf-instances/*", "NFRegister"},
+	{"nnrf-nfm", "PATCH", "nf-instances/*", "NFUpdate"},
+	{"nnrf-nfm", "DELETE", "nf-instances/*", "NFDeregister"},
+	{"nnrf-nfm", "GET", "nf-instances/*", "NFProfileRetrieval"},
+	{"nnrf-nfm", "GET", "nf-instances", "NFListRetrieval"},
+	{"nnrf-nfm", "POST", "subscriptions", "NFStatusSubscribe"},
+	{"nnrf-nfm", "PATCH", "subscriptions/*", "NFStatusSubscribe"},
+	{"nnrf-nfm", "DELETE", "subscriptions/*", "NFStatusUnsubscribe"},
+	{"nnrf-disc", "GET", "**", "NFDiscover"},
+	{"oauth2", "POST", "token", "Get"},
+
+	// AUSF (TS 29.509)
+	{"nausf-auth", "POST", "ue-authentications", "Authenticate"},
+	{"nausf-auth", "", "ue-authentications/**", "Authenticate"},
+	{"nausf-auth", "POST", "rg-authentications", "Authenticate"},
+	{"nausf-sorprotection", "POST", "*/ue-sor", "Protect"},
+	{"nausf-upuprotection", "POST", "*/ue-upu", "Protect"},
+
+	// UDM (TS 29.503)
+	{"nudm-sdm", "POST", "*/sdm-subscriptions", "Subscribe"},
+	{"nudm-sdm", "PATCH", "*/sdm-subscriptions/*", "ModifySubscription"},
+	{"nudm-sdm", "DELETE", "*/sdm-subscriptions/*", "Unsubscribe"},
+	{"nudm-sdm", "PUT", "**", "Info"},
+	{"nudm-sdm", "GET", "**", "Get"},
+	{"nudm-uecm", "PUT", "*/registrations/**", "Registration"},
+	{"nudm-uecm", "PATCH", "*/registrations/**", "Update"},
+	{"nudm-uecm", "DELETE", "*/registrations/**", "Deregistration"},
+	{"nudm-uecm", "POST", "*/registrations/amf-3gpp-access/dereg-amf", "DeregAMF"},
+	{"nudm-uecm", "GET", "**", "Get"},
+	{"nudm-ueau", "POST", "*/security-information/generate-auth-data", "Get"},
+	{"nudm-ueau", "POST", "*/auth-events", "ResultConfirmationInform"},
+	{"nudm-ueau", "PUT", "*/auth-events/*", "ResultConfirmationInform"},
+	{"nudm-ee", "POST", "*/ee-subscriptions", "Subscribe"},
+	{"nudm-ee", "DELETE", "*/ee-subscriptions/*", "Unsubscribe"},
+	{"nudm-pp", "PATCH", "*/pp-data", "Update"},
+
+	// UDR (TS 29.504)
+	{"nudr-dr", "POST", "**/sdm-subscriptions", "Subscribe"},
+	{"nudr-dr", "POST", "**/subs-to-notify", "Subscribe"},
+	{"nudr-dr", "DELETE", "**/subs-to-notify/*", "Unsubscribe"},
+	{"nudr-dr", "GET", "**", "Query"},
+	{"nudr-dr", "PUT", "**", "Create"},
+	{"nudr-dr", "POST", "**", "Create"},
+	{"nudr-dr", "PATCH", "**", "Update"},
+	{"nudr-dr", "DELETE", "**", "Delete"},
+
+	// AMF (TS 29.518)
+	{"namf-comm", "POST", "ue-contexts/*/n1-n2-messages", "N1N2MessageTransfer"},
+	{"namf-comm", "POST", "ue-contexts/*/n1-n2-messages/subscriptions", "N1N2MessageSubscribe"},
+	{"namf-comm", "DELETE", "ue-contexts/*/n1-n2-messages/subscriptions/*", "N1N2MessageUnSubscribe"},
+	{"namf-comm", "PUT", "ue-contexts/*", "CreateUEContext"},
+	{"namf-comm", "POST", "ue-contexts/*/release", "ReleaseUEContext"},
+	{"namf-comm", "POST", "ue-contexts/*/transfer", "UEContextTransfer"},
+	{"namf-comm", "POST", "ue-contexts/*/transfer-update", "RegistrationStatusUpdate"},
+	{"namf-comm", "POST", "ue-contexts/*/assign-ebi", "EBIAssignment"},
+	{"namf-comm", "POST", "non-ue-n2-messages/transfer", "NonUeN2MessageTransfer"},
+	{"namf-comm", "POST", "non-ue-n2-messages/subscriptions", "NonUeN2InfoSubscribe"},
+	{"namf-comm", "POST", "subscriptions", "AMFStatusChangeSubscribe"},
+	{"namf-comm", "PUT", "subscriptions/*", "AMFStatusChangeSubscribe"},
+	{"namf-comm", "DELETE", "subscriptions/*", "AMFStatusChangeUnSubscribe"},
+	{"namf-evts", "POST", "subscriptions", "Subscribe"},
+	{"namf-evts", "PATCH", "subscriptions/*", "ModifySubscription"},
+	{"namf-evts", "DELETE", "subscriptions/*", "Unsubscribe"},
+	{"namf-mt", "PUT", "ue-contexts/*/ue-reachind", "EnableUEReachability"},
+	{"namf-mt", "GET", "ue-contexts/*", "ProvideDomainSelectionInfo"},
+	{"namf-loc", "POST", "*/provide-pos-info", "ProvidePositioningInfo"},
+	{"namf-loc", "POST", "*/provide-loc-info", "ProvideLocationInfo"},
+
+	// SMF (TS 29.502, TS 29.508)
+	{"nsmf-pdusession", "POST", "sm-contexts", "CreateSMContext"},
+	{"nsmf-pdusession", "POST", "sm-contexts/*/modify", "UpdateSMContext"},
+	{"nsmf-pdusession", "POST", "sm-contexts/*/release", "ReleaseSMContext"},
+	{"nsmf-pdusession", "POST", "sm-contexts/*/retrieve", "RetrieveSMContext"},
+	{"nsmf-pdusession", "POST", "pdu-sessions", "Create"},
+	{"nsmf-pdusession", "POST", "pdu-sessions/*/modify", "Update"},
+	{"nsmf-pdusession", "POST", "pdu-sessions/*/release", "Release"},
+	{"nsmf-event-exposure", "POST", "subscriptions", "Subscribe"},
+	{"nsmf-event-exposure", "DELETE", "subscriptions/*", "Unsubscribe"},
+
+	// PCF (TS 29.507, TS 29.512, TS 29.514, TS 29.525)
+	{"npcf-am-policy-control", "POST", "policies", "Create"},
+	{"npcf-am-policy-control", "POST", "policies/*/update", "Update"},
+	{"npcf-am-policy-control", "DELETE", "policies/*", "Delete"},
+	{"npcf-am-policy-control", "GET", "policies/*", "Get"},
+	{"npcf-smpolicycontrol", "POST", "sm-policies", "Create"},
+	{"npcf-smpolicycontrol", "POST", "sm-policies/*/update", "Update"},
+	{"npcf-smpolicycontrol", "POST", "sm-policies/*/delete", "Delete"},
+	{"npcf-smpolicycontrol", "GET", "sm-policies/*", "Get"},
+	{"npcf-ue-policy-control", "POST", "policies", "Create"},
+	{"npcf-ue-policy-control", "POST", "policies/*/update", "Update"},
+	{"npcf-ue-policy-control", "DELETE", "policies/*", "Delete"},
+	{"npcf-ue-policy-control", "GET", "policies/*", "Get"},
+	{"npcf-policyauthorization", "POST", "app-sessions", "Create"},
+	{"npcf-policyauthorization", "PATCH", "app-sessions/*", "Update"},
+	{"npcf-policyauthorization", "POST", "app-sessions/*/delete", "Delete"},
+	{"npcf-policyauthorization", "PUT", "app-sessions/*/events-subscription", "Subscribe"},
+	{"npcf-policyauthorization", "DELETE", "app-sessions/*/events-subscription", "Unsubscribe"},
+
+	// NSSF (TS 29.531)
+	{"nnssf-nsselection", "GET", "network-slice-information", "Get"},
+	{"nnssf-nssaiavailability", "PUT", "nssai-availability/*", "Update"},
+	{"nnssf-nssaiavailability", "PATCH", "nssai-availability/*", "Update"},
+	{"nnssf-nssaiavailability", "DELETE", "nssai-availability/*", "Delete"},
+	{"nnssf-nssaiavailability", "POST", "nssai-availability/subscriptions", "Subscribe"},
+	{"nnssf-nssaiavailability", "DELETE", "nssai-availability/subscriptions/*", "Unsubscribe"},
+
+	// SMSF, BSF, CHF, 5G-EIR (TS 29.540, TS 29.521, TS 32.291, TS 29.511)
+	{"nsmsf-sms", "PUT", "ue-contexts/*", "Activate"},
+	{"nsmsf-sms", "DELETE", "ue-contexts/*", "Deactivate"},
+	{"nsmsf-sms", "POST", "ue-contexts/*/sendsms", "UplinkSMS"},
+	{"nbsf-management", "POST", "pcfBindings", "Register"},
+	{"nbsf-management", "DELETE", "pcfBindings/*", "Deregister"},
+	{"nbsf-management", "GET", "pcfBindings", "Discovery"},
+	{"nchf-convergedcharging", "POST", "chargingdata", "Create"},
+	{"nchf-convergedcharging", "POST", "chargingdata/*/update", "Update"},
+	{"nchf-convergedcharging", "POST", "chargingdata/*/release", "Release"},
+	{"n5g-eir-eic", "GET", "equipment-status", "CheckEquipmentIdentity"},
+}
+
+// nfTypes are the NF types recognized in :authority and user-agent (TS 29.510 NFType)
+// Longer names come first so "SMSF" is not taken for "SMF"
+var nfTypes = []string{
+	"5G-EIR", "NWDAF", "N3IWF", "SMSF", "AUSF", "NSSF", "UDSF", "GMLC", "SEPP",
+	"AMF", "SMF", "UPF", "UDM", "UDR", "PCF", "NRF", "NEF", "BSF", "CHF", "SCP", "LMF", "AF",
+}
+
+// sbiAPIPattern matches the API name and version at the start of an SBI path
+// Example: "nudm-sdm" and "v2" in "/nudm-sdm/v2/imsi-.../am-data"
+var sbiAPIPattern = regexp.MustCompile(`^/(n[0-9a-z]+-[0-9a-z-]+|oauth2)(?:/(v[0-9]+))?(/[^?]*)?`)
+
+// sbiFields adds the 5G SBI service, operation and NF types of a transaction
+// Transactions that are not SBI calls are left unchanged
+// Parameters:
+// - message: Stored fields of the transaction
+// - t: Transaction with request and response headers
+func sbiFields(message map[string]string, t *transaction) {
+	path := headerValue(t.request, ":path")
+	if u, err := url.Parse(path); err == nil && u.IsAbs() {
+		path = u.RequestURI() // Absolute form sent to a proxy or SCP
+	}
+	parts := sbiAPIPattern.FindStringSubmatch(path)
+	if parts == nil {
+		return
+	}
+	api, version, resource := parts[1], parts[2], strings.Trim(parts[3], "/")
+
+	// Notifications go to callback URIs the consumer chose, e.g. /namf-callback/v1/...
+	if nf, ok := strings.CutSuffix(api, "-callback"); ok {
+		message["sbi_service"] = api
+		message["sbi_operation"] = "Notify"
+		message["producer_nf"] = strings.ToUpper(strings.TrimPrefix(nf, "n")) // Receiver of the notification
+		message["sbi_resource"] = "/" + resource
+		consumerNF(message, t)
+		return
+	}
+
+	service, known := sbiServices[api]
+	if !known && version == "" {
+		return // Neither a known API nor an /{apiName}/{apiVersion} path
+	}
+	message["sbi_service"] = api
+	if version != "" {
+		message["sbi_api_version"] = version
+	}
+	message["sbi_resource"] = "/" + resource
+
+	method := headerValue(t.request, ":method")
+	if known {
+		message["producer_nf"] = service.nf
+		if op := operationName(api, method, resource); op != "" {
+			message["sbi_operation"] = service.name + "_" + op
+		}
+	} else if nf := nfFromAuthority(headerValue(t.request, ":authority")); nf != "" {
+		message["producer_nf"] = nf
+	}
+	consumerNF(message, t)
+}
+
+// operationName returns the operation of a method on a resource of an API
+// Example: ("nsmf-pdusession", "POST", "sm-contexts/abc/modify") returns "UpdateSMContext"
+func operationName(api string, method string, resource string) string {
+	segments := strings.Split(resource, "/")
+	for _, op := range sbiOperations {
+		if op.service != api || (op.method != "" && op.method != method) {
+			continue
+		}
+		if matchResource(strings.Split(op.resource, "/"), segments) {
+			return op.operation
+		}
+	}
+	// Notifications to subscription callbacks hosted under a service path
+	if method == "POST" && strings.Contains(strings.ToLower(resource), "notify") {
+		return "Notify"
+	}
+	return ""
+}
+
+// matchResource matches path segments against a resource pattern
+// "*" matches one segment, "**" matches any number of segments
+func matchResource(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchResource(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 || (pattern[0] != "*" && pattern[0] != segments[0]) {
+		return false
+	}
+	return matchResource(pattern[1:], segments[1:])
+}
+
+// consumerNF adds the NF type of the client of an SBI request
+// Taken from the user-agent (TS 29.500 section 5.2.2.2, e.g. "AMF" or "SMF-smf1.operator.com"),
+// the 3gpp-Sbi-Discovery requester header or the NRF discovery query
+func consumerNF(message map[string]string, t *transaction) {
+	userAgent := headerValue(t.request, "user-agent")
+	if nf := nfPrefix(userAgent); nf != "" {
+		message["consumer_nf"] = nf
+		return
+	}
+	if nf := headerValue(t.request, "3gpp-sbi-discovery-requester-nf-type"); nf != "" {
+		message["consumer_nf"] = strings.ToUpper(nf)
+		return
+	}
+	if u, err := url.Parse(headerValue(t.request, ":path")); err == nil {
+		query := u.Query()
+		if nf := query.Get("requester-nf-type"); nf != "" {
+			message["consumer_nf"] = strings.ToUpper(nf)
+		}
+		if nf := query.Get("target-nf-type"); nf != "" {
+			message["target_nf"] = strings.ToUpper(nf) // NF type the consumer looks for
+		}
+	}
+}
+
+// nfPrefix returns the NF type a user-agent starts with
+// The type is followed by the end, "-" or "_" so "AMFTool/1.0" is not taken for an AMF
+func nfPrefix(userAgent string) string {
+	upper := strings.ToUpper(userAgent)
+	for _, nf := range nfTypes {
+		rest, ok := strings.CutPrefix(upper, nf)
+		if ok && (rest == "" || rest[0] == '-' || rest[0] == '_' || rest[0] == ' ') {
+			return nf
+		}
+	}
+	return ""
+}
+
+// nfFromAuthority returns the NF type named in the host of :authority
+// Example: "udm1.5gc.mnc093.mcc208.3gppnetwork.org:8000" returns "UDM"
+func nfFromAuthority(authority string) string {
+	host := authority
+	if h, _, err := net.SplitHostPort(authority); err == nil {
+		host = h
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+	for _, label := range strings.FieldsFunc(host, func(r rune) bool { return r == '.' || r == '-' }) {
+		label = strings.ToUpper(strings.TrimRight(label, "0123456789")) // "amf1" is an AMF
+		for _, nf := range nfTypes {
+			if label == nf {
+				return nf
+			}
+		}
+	}
+	return ""
+}
+
+// problemFields adds the ProblemDetails of an error response (TS 29.571 section 5.2.4.1)
+// Example: {"problem_status": "404", "problem_cause": "USER_NOT_FOUND", "problem_detail": "..."}
+// Parameters:
+// - message: Stored fields of the transaction
+// - headers: Response headers
+// - body: Response body
+func problemFields(message map[string]string, headers []hpack.HeaderField, body []byte) {
+	contentType := strings.ToLower(headerValue(headers, "content-type"))
+	status, _ := strconv.Atoi(headerValue(headers, ":status"))
+	if !strings.Contains(contentType, "problem+json") && (status < 400 || !strings.Contains(contentType, "json")) {
+		return
+	}
+
+	body, _, err := contentDecode(headerValue(headers, "content-encoding"), body)
+	if err != nil {
+		return
+	}
+
+	var problem struct {
+		Type          string `json:"type"`
+		Title         string `json:"title"`
+		Status        int    `json:"status"`
+		Detail        string `json:"detail"`
+		Instance      string `json:"instance"`
+		Cause         string `json:"cause"`
+		InvalidParams []struct {
+			Param  string `json:"param"`
+			Reason string `json:"reason"`
+		} `json:"invalidParams"`
+	}
+	if json.Unmarshal(body, &problem) != nil {
+		return // Not a ProblemDetails document
+	}
+
+	fields := map[string]string{
+		"problem_type":     problem.Type,
+		"problem_title":    problem.Title,
+		"problem_detail":   problem.Detail,
+		"problem_instance": problem.Instance,
+		"problem_cause":    problem.Cause,
+	}
+	if problem.Status != 0 {
+		fields["problem_status"] = strconv.Itoa(problem.Status)
+	}
+	var invalid []string
+	for _, p := range problem.InvalidParams {
+		if p.Reason != "" {
+			invalid = append(invalid, fmt.Sprintf("%s (%s)", p.Param, p.Reason))
+		} else {
+			invalid = append(invalid, p.Param)
+		}
+	}
+	fields["problem_invalid_params"] = strings.Join(invalid, ", ")
+
+	for name, value := range fields {
+		if value != "" {
+			message[name] = value
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package decode_http
+
+import "testing"
+
+func TestNFPrefix(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"AMF", "AMF"},
+		{"AMF-amf1.cluster1.net2.amf.5gc.mnc012.mcc345.3gppnetwork.org", "AMF"},
+		{"smf_1", "SMF"},
+		{"SMSF-smsf1.operator.com", "SMSF"},
+		{"NWDAF nwdaf-1", "NWDAF"},
+		{"5G-EIR", "5G-EIR"},
+		{"5G-EIR-eir1.operator.com", "5G-EIR"},
+		{"AMFTool/1.0", ""},
+		{"curl/8.5.0", ""},
+		{"Go-http-client/2.0", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := nfPrefix(tt.userAgent); got != tt.want {
+			t.Errorf("nfPrefix(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
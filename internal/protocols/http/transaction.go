@@ -0,0 +1,328 @@
+// transaction.go
+// This file joins the frames of an HTTP/2 stream into one request/response record.
+// Core functionalities:
+// - Request headers and body from the endpoint opening the stream
+// - Stream dependency, weight and exclusive bit of a request HEADERS with the PRIORITY flag
+// - Response headers and body from the peer, with latency between the HEADERS frames
+// - Records stored when the response ends, the stream is reset or the capture ends
+//
+// Example scenarios:
+// 1. Slow SBI call:
+//    {":method": "POST", ":path": "/nausf-auth/v1/ue-authentications",
+//     ":status": "201", "latency_ms": "812.402", "state": "complete"}
+//
+// 2. Reset stream:
+//    {":method": "GET", ":path": "/nudm-sdm/v2/...", "state": "reset by client: CANCEL"}
+//
+// 3. Capture ends before the answer:
+//    {":method": "PUT", ":path": "/nudm-uecm/v1/...", "state": "no response"}
+
+package decode_http
+
+import (
+	database "DeepPacketAI/internal/storage" // Database operations for storing transactions
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// maxBodyBytes bounds the body kept per request or response
+const maxBodyBytes = 64 * 1024
+
+// transaction is one request/response exchange on an HTTP/2 stream or an HTTP/1 connection
+type transaction struct {
+	streamID   uint32 // 0 for HTTP/1
+	version    string // Protocol version, e.g. "HTTP/2" or "HTTP/1.1"
+	pushed     bool   // Request promised by the server with PUSH_PROMISE
+	request    []hpack.HeaderField
+	response   []hpack.HeaderField
+	interim    []string // 1xx status codes received before the response
+	reqBody    []byte
+	respBody   []byte
+	truncated  bool // A body exceeded maxBodyBytes
+	reqTime    time.Time
+	respTime   time.Time
+	reqFrame   uint64
+	respFrame  uint64
+	priority   *http2.PriorityParam // Stream priority sent with the request HEADERS, nil when absent
+	notes      []string             // Flow-control stalls and decoding errors
+	lastSeen   time.Time
+	lastFrame  uint64
+	reqEnded   bool // Request END_STREAM seen
+	hasRequest bool // Request HEADERS seen, false when the capture started mid-stream
+}
+
+// onHeaders handles a complete header block of a HEADERS frame
+// Parameters:
+// - streamID: Stream of the HEADERS frame
+// - fields: Decoded headers
+// - endStream: END_STREAM flag of the HEADERS frame
+// - priority: Priority of the HEADERS frame, nil without the PRIORITY flag
+func (d *direction) onHeaders(streamID uint32, fields []hpack.HeaderField, endStream bool, priority *http2.PriorityParam) {
+	// Response or response trailers to a request of the peer
+	if t, ok := d.peer.transactions[streamID]; ok {
+		t.touch(d)
+		status := headerValue(fields, ":status")
+		switch {
+		case t.response == nil && strings.HasPrefix(status, "1") && status != "101":
+			t.interim = append(t.interim, status) // e.g. 100 Continue, the final response follows
+		case t.response == nil:
+			t.response = fields
+			t.respTime, t.respFrame = d.seen, d.frame
+		default:
+			t.response = append(t.response, fields...) // Trailers
+		}
+		if endStream {
+			d.peer.complete(t, "complete")
+		}
+		return
+	}
+
+	// Trailers of a request this endpoint sent
+	if t, ok := d.transactions[streamID]; ok {
+		t.touch(d)
+		t.request = append(t.request, fields...)
+		t.reqEnded = t.reqEnded || endStream
+		return
+	}
+
+	// A response without its request, the capture started mid-stream
+	if headerValue(fields, ":status") != "" {
+		t := d.peer.open(streamID)
+		t.response = fields
+		t.respTime, t.respFrame = d.seen, d.frame
+		if endStream {
+			d.peer.complete(t, "complete")
+		}
+		return
+	}
+
+	// A new request
+	t := d.open(streamID)
+	t.hasRequest = true
+	t.request = fields
+	t.reqTime, t.reqFrame = d.seen, d.frame
+	t.reqEnded = endStream
+	t.priority = priority
+}
+
+// onPushPromise opens the transaction of a stream the server pushes
+// The promised request is sent by the server on behalf of the client
+func (d *direction) onPushPromise(promisedID uint32, fields []hpack.HeaderField) {
+	t := d.peer.open(promisedID)
+	t.pushed = true
+	t.hasRequest = true
+	t.request = fields
+	t.reqTime, t.reqFrame = d.seen, d.frame
+	t.reqEnded = true
+}
+
+// onData adds a DATA frame to the body of its transaction
+// Parameters:
+// - streamID: Stream of the DATA frame
+// - data: Payload without padding
+// - endStream: END_STREAM flag of the DATA frame
+// - note: Flow-control stall of the frame, empty when none
+//
+// Returns: false when the stream has no transaction (capture started mid-stream)
+func (d *direction) onData(streamID uint32, data []byte, endStream bool, note string) bool {
+	if t, ok := d.transactions[streamID]; ok {
+		t.touch(d)
+		t.reqBody = t.appendBody(t.reqBody, data)
+		t.reqEnded = t.reqEnded || endStream
+		t.note(note)
+		return true
+	}
+	if t, ok := d.peer.transactions[streamID]; ok {
+		t.touch(d)
+		t.respBody = t.appendBody(t.respBody, data)
+		t.note(note)
+		if endStream {
+			d.peer.complete(t, "complete")
+		}
+		return true
+	}
+	return false
+}
+
+// onReset ends the transaction of a stream reset by this endpoint
+func (d *direction) onReset(streamID uint32, code string) {
+	if t, ok := d.transactions[streamID]; ok {
+		t.touch(d)
+		d.complete(t, "reset by client: "+code)
+		return
+	}
+	if t, ok := d.peer.transactions[streamID]; ok {
+		t.touch(d)
+		d.peer.complete(t, "reset by server: "+code)
+	}
+}
+
+// open starts a transaction for a request this endpoint sends
+func (d *direction) open(streamID uint32) *transaction {
+	t := &transaction{streamID: streamID, version: "HTTP/2", lastSeen: d.seen, lastFrame: d.frame}
+	d.transactions[streamID] = t
+	return t
+}
+
+// complete stores a transaction of a request this endpoint sent
+// Parameters:
+// - t: Transaction to store
+// - state: How the exchange ended (e.g. "complete", "reset by server: CANCEL")
+func (d *direction) complete(t *transaction, state string) {
+	delete(d.transactions, t.streamID)
+	database.Insert(
+		d.src,                           // Client IP address
+		d.dst,                           // Server IP address
+		"http",                          // Protocol identifier
+		t.lastSeen.Format(time.RFC3339), // Time of the last frame
+		t.lastFrame,                     // Frame completing the exchange
+		t.record(d, state),              // Transaction fields
+	)
+}
+
+// flushTransactions stores the unfinished transactions of this endpoint's requests
+// Called when the connection closes or the capture ends
+func (d *direction) flushTransactions() {
+	pending := make([]*transaction, 0, len(d.transactions))
+	for _, t := range d.transactions {
+		pending = append(pending, t)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].streamID < pending[j].streamID })
+	pending = append(pending, d.pipeline...) // HTTP/1 requests in order
+	d.pipeline = nil
+
+	for _, t := range pending {
+		state := "no response"
+		if t.response != nil {
+			state = "response incomplete"
+		}
+		d.complete(t, state)
+	}
+}
+
+// touch records the latest frame of a transaction
+func (t *transaction) touch(d *direction) {
+	t.lastSeen, t.lastFrame = d.seen, d.frame
+}
+
+// note adds a remark to the transaction, skipping empty and repeated ones
+func (t *transaction) note(text string) {
+	if text == "" {
+		return
+	}
+	for _, n := range t.notes {
+		if n == text {
+			return
+		}
+	}
+	t.notes = append(t.notes, text)
+}
+
+// appendBody adds DATA to a body up to maxBodyBytes
+func (t *transaction) appendBody(body []byte, data []byte) []byte {
+	room := maxBodyBytes - len(body)
+	if len(data) > room {
+		t.truncated = true
+		data = data[:max(room, 0)]
+	}
+	return append(body, data...)
+}
+
+// record builds the stored fields of a transaction
+// Pseudo-headers are kept as fields of their own so tools can count :status
+// Example: {":method": "GET", ":status": "200", "latency_ms": "3.118", ...}
+func (t *transaction) record(client *direction, state string) map[string]string {
+	message := map[string]string{
+		"connection": fmt.Sprintf("%s:%d -> %s:%d", client.src, client.srcPort, client.dst, client.dstPort),
+		"state":      state,
+	}
+	if t.version != "" {
+		message["version"] = t.version
+	}
+	if t.streamID != 0 {
+		message["stream_id"] = strconv.FormatUint(uint64(t.streamID), 10)
+	}
+
+	// Request
+	for _, name := range []string{":method", ":path", ":authority", ":scheme"} {
+		if value := headerValue(t.request, name); value != "" {
+			message[name] = value
+		}
+	}
+	if headers := headerText(t.request); headers != "" {
+		message["request_headers"] = headers
+	}
+	bodyFields(message, "request", t.request, t.reqBody)
+	if t.hasRequest {
+		message["request_time"] = t.reqTime.Format(time.RFC3339Nano)
+		message["request_frame"] = strconv.FormatUint(t.reqFrame, 10)
+	} else {
+		message["request"] = "not captured"
+	}
+	if t.pushed {
+		message["pushed"] = "true"
+	}
+	if t.priority != nil {
+		addPriority(message, *t.priority)
+	}
+
+	// Response
+	if t.response != nil {
+		message[":status"] = headerValue(t.response, ":status")
+		if reason := headerValue(t.response, ":reason"); reason != "" {
+			message["reason"] = reason // HTTP/1 reason phrase
+		}
+		message["response_frame"] = strconv.FormatUint(t.respFrame, 10)
+		if t.hasRequest {
+			latency := t.respTime.Sub(t.reqTime)
+			message["latency_ms"] = strconv.FormatFloat(float64(latency)/float64(time.Millisecond), 'f', 3, 64)
+		}
+	}
+	if headers := headerText(t.response); headers != "" {
+		message["response_headers"] = headers
+	}
+	bodyFields(message, "response", t.response, t.respBody)
+	if len(t.interim) > 0 {
+		message["interim_status"] = strings.Join(t.interim, ", ")
+	}
+
+	// 5G service-based interface
+	sbiFields(message, t)
+	problemFields(message, t.response, t.respBody)
+
+	if t.truncated {
+		t.note(fmt.Sprintf("body truncated to %d bytes", maxBodyBytes))
+	}
+	if len(t.notes) > 0 {
+		message["notes"] = strings.Join(t.notes, "; ")
+	}
+	return message
+}
+
+// headerValue returns the value of the first header with the given name
+func headerValue(fields []hpack.HeaderField, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// headerText lists regular headers one per line, pseudo-headers are fields of their own
+// Example: "content-type: application/json\nlocation: http://..."
+func headerText(fields []hpack.HeaderField) string {
+	var lines []string
+	for _, f := range fields {
+		if !strings.HasPrefix(f.Name, ":") {
+			lines = append(lines, f.Name+": "+f.Value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
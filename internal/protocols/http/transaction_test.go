@@ -0,0 +1,141 @@
+package decode_http
+
+import (
+	database "DeepPacketAI/internal/storage"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// exchange is a test connection whose endpoints send what they wrote at a given time
+type exchange struct {
+	client, server *endpoint
+	start          time.Time
+	frame          uint64
+}
+
+// send passes the frames one side wrote to Process, at milliseconds after the start
+func (x *exchange) send(fromServer bool, at float64) {
+	x.frame++
+	seen := x.start.Add(time.Duration(at * float64(time.Millisecond)))
+	if fromServer {
+		x.server.send(x.client, seen, x.frame)
+	} else {
+		x.client.send(x.server, seen, x.frame)
+	}
+}
+
+// request writes a request HEADERS frame of the client
+func (x *exchange) request(streamID uint32, endStream bool, method string, path string) {
+	x.client.frame.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, EndStream: endStream, EndHeaders: true,
+		BlockFragment: x.client.headers(":method", method, ":path", path)})
+}
+
+// response writes a response HEADERS frame of the server
+func (x *exchange) response(streamID uint32, endStream bool, status string) {
+	x.server.frame.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, EndStream: endStream, EndHeaders: true,
+		BlockFragment: x.server.headers(":status", status, "content-type", "text/plain")})
+}
+
+// TestTransactions checks how the frames of HTTP/2 streams are joined into request/response records
+func TestTransactions(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(x *exchange)
+		want []string // Stored records in order
+	}{
+		{
+			name: "interleaved streams",
+			send: func(x *exchange) {
+				x.request(1, false, "POST", "/nausf-auth/v1/ue-authentications")
+				x.request(3, true, "GET", "/nudm-sdm/v2/imsi-001010000000001/am-data")
+				x.client.frame.WriteData(1, true, []byte("auth"))
+				x.send(false, 0)
+				x.response(3, false, "200")
+				x.response(1, false, "201")
+				x.server.frame.WriteData(1, false, []byte("created "))
+				x.send(true, 4.5)
+				x.server.frame.WriteData(3, true, []byte("am-data"))
+				x.server.frame.WriteData(1, true, []byte("ok"))
+				x.send(true, 12.25)
+			},
+			want: []string{
+				"stream 3 GET 200 complete latency 4.500 frames 1/2 body am-data",
+				"stream 1 POST 201 complete latency 4.500 frames 1/2 body created ok",
+			},
+		},
+		{
+			name: "latency from the request HEADERS to the response HEADERS",
+			send: func(x *exchange) {
+				x.request(1, false, "PUT", "/nudm-uecm/v1/imsi-001010000000001/registrations/amf-3gpp-access")
+				x.send(false, 0)
+				x.client.frame.WriteData(1, true, []byte("{}"))
+				x.send(false, 100)
+				x.response(1, true, "204")
+				x.send(true, 812.402)
+			},
+			want: []string{"stream 1 PUT 204 complete latency 812.402 frames 1/3 body "},
+		},
+		{
+			name: "stream reset by the client",
+			send: func(x *exchange) {
+				x.request(1, true, "GET", "/nudm-sdm/v2/imsi-001010000000001/nssai")
+				x.send(false, 0)
+				x.response(1, false, "200")
+				x.send(true, 3)
+				x.client.frame.WriteRSTStream(1, http2.ErrCodeCancel)
+				x.send(false, 4)
+			},
+			want: []string{"stream 1 GET 200 reset by client: CANCEL latency 3.000 frames 1/2 body "},
+		},
+		{
+			name: "stream refused by the server",
+			send: func(x *exchange) {
+				x.request(1, true, "GET", "/nudm-sdm/v2/imsi-001010000000001/nssai")
+				x.send(false, 0)
+				x.server.frame.WriteRSTStream(1, http2.ErrCodeRefusedStream)
+				x.send(true, 1)
+			},
+			want: []string{"stream 1 GET  reset by server: REFUSED_STREAM latency  frames 1/ body "},
+		},
+		{
+			name: "response with no request",
+			send: func(x *exchange) {
+				x.response(7, false, "200")
+				x.server.frame.WriteData(7, true, []byte("late"))
+				x.send(true, 0)
+			},
+			want: []string{"stream 7 request not captured 200 complete latency  frames /1 body late"},
+		},
+		{
+			name: "request with no response",
+			send: func(x *exchange) {
+				x.request(5, true, "DELETE", "/nudm-uecm/v1/imsi-001010000000001/registrations/smf-registrations/5")
+				x.send(false, 0)
+			},
+			want: []string{"stream 5 DELETE  no response latency  frames 1/ body "},
+		},
+	}
+	for _, tt := range tests {
+		Reset()
+		database.Take()
+		x := &exchange{client: newEndpoint("10.0.0.1", 40000), server: newEndpoint("10.0.0.2", 8080),
+			start: time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)}
+		tt.send(x)
+
+		var got []string
+		for _, r := range transactions(t, "RST_STREAM") {
+			method := r[":method"]
+			if method == "" {
+				method = "request " + r["request"]
+			}
+			got = append(got, fmt.Sprintf("stream %s %s %s %s latency %s frames %s/%s body %s", r["stream_id"], method,
+				r[":status"], r["state"], r["latency_ms"], r["request_frame"], r["response_frame"], r["response_body"]))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
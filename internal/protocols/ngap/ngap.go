@@ -0,0 +1,211 @@
+// ngap.go
+// This file describes NGAP content (3GPP TS 38.413) carried as N2 information in SBI bodies.
+// Core functionalities:
+// - Walks the aligned PER encoding of an NGAP-PDU down to its protocol IEs
+// - Walks IE containers sent alone, e.g. PDUSessionResourceSetupRequestTransfer
+// - Names procedures and common IEs, decodes UE NGAP IDs and embedded NAS-PDUs
+//
+// Example scenarios:
+// 1. N2 SM information of Nsmf_PDUSession_CreateSMContext (ngapIeType PDU_RES_SETUP_REQ):
+//    "IE 130 PDUSessionAggregateMaximumBitRate (9 bytes)\nIE 139 UL-NGU-UP-TNLInformation (10 bytes)..."
+//
+// 2. Full message in Namf_Communication_N1N2MessageTransfer:
+//    "initiatingMessage DownlinkNASTransport\nIE 10 AMF-UE-NGAP-ID: 1\nIE 38 NAS-PDU: 5GMM ..."
+
+package decode_ngap
+
+import (
+	decode_nas "DeepPacketAI/internal/protocols/nas" // NAS-PDU contents
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// pduTypes names the NGAP-PDU choice alternatives
+var pduTypes = []string{"initiatingMessage", "successfulOutcome", "unsuccessfulOutcome"}
+
+// procedures names the NGAP procedure codes (TS 38.413 section 9.4.7)
+var procedures = []string{
+	"AMFConfigurationUpdate", "AMFStatusIndication", "CellTrafficTrace", "DeactivateTrace",
+	"DownlinkNASTransport", "DownlinkNonUEAssociatedNRPPaTransport", "DownlinkRANConfigurationTransfer",
+	"DownlinkRANStatusTransfer", "DownlinkUEAssociatedNRPPaTransport", "ErrorIndication",
+	"HandoverCancel", "HandoverNotification", "HandoverPreparation", "HandoverResourceAllocation",
+	"InitialContextSetup", "InitialUEMessage", "LocationReportingControl",
+	"LocationReportingFailureIndication", "LocationReport", "NASNonDeliveryIndication", "NGReset",
+	"NGSetup", "OverloadStart", "OverloadStop", "Paging", "PathSwitchRequest",
+	"PDUSessionResourceModify", "PDUSessionResourceModifyIndication", "PDUSessionResourceRelease",
+	"PDUSessionResourceSetup", "PDUSessionResourceNotify", "PrivateMessage", "PWSCancel",
+	"PWSFailureIndication", "PWSRestartIndication", "RANConfigurationUpdate", "RerouteNASRequest",
+	"RRCInactiveTransitionReport", "TraceFailureIndication", "TraceStart", "UEContextModification",
+	"UEContextRelease", "UEContextReleaseRequest", "UERadioCapabilityCheck",
+	"UERadioCapabilityInfoIndication", "UETNLABindingRelease", "UplinkNASTransport",
+	"UplinkNonUEAssociatedNRPPaTransport", "UplinkRANConfigurationTransfer",
+	"UplinkRANStatusTransfer", "UplinkUEAssociatedNRPPaTransport", "WriteReplaceWarning",
+}
+
+// ieNames names common protocol IE identifiers (TS 38.413 section 9.4.7)
+var ieNames = map[uint16]string{
+	0:   "AllowedNSSAI",
+	10:  "AMF-UE-NGAP-ID",
+	15:  "Cause",
+	28:  "GUAMI",
+	38:  "NAS-PDU",
+	85:  "RAN-UE-NGAP-ID",
+	94:  "SecurityKey",
+	110: "UEAggregateMaximumBitRate",
+	119: "UESecurityCapabilities",
+	121: "UserLocationInformation",
+	127: "DataForwardingNotPossible",
+	129: "NetworkInstance",
+	130: "PDUSessionAggregateMaximumBitRate",
+	134: "PDUSessionType",
+	136: "QosFlowSetupRequestList",
+	138: "SecurityIndication",
+	139: "UL-NGU-UP-TNLInformation",
+}
+
+// pduSessionTypes names the PDUSessionType values
+var pduSessionTypes = []string{"ipv4", "ipv6", "ipv4v6", "ethernet", "unstructured"}
+
+// maxHexBytes bounds the IE values shown as hex
+const maxHexBytes = 32
+
+// Describe returns a readable summary of NGAP content
+// Parameters:
+// - p: A whole NGAP-PDU, or an IE container such as a ...Transfer structure
+// - ieType: ngapIeType or ngapMessageType from the SBI JSON, empty when unknown
+//
+// Returns: One line for the message and one per IE, or an error when p cannot be walked
+func Describe(p []byte, ieType string) (string, error) {
+	// An NGAP-PDU starts with the choice index and a known procedure code
+	if len(p) >= 4 && p[0]&0x9f == 0 && int(p[0]>>5) < len(pduTypes) && int(p[1]) < len(procedures) && p[2]&0x3f == 0 {
+		if lines, err := describePDU(p); err == nil {
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+
+	// N2 SM information is an IE container of its own, or a structure with
+	// fixed components (e.g. PDUSessionResourceSetupResponseTransfer) not walked here
+	lines, err := describeContainer(p)
+	if err != nil && ieType != "" {
+		return ieType + " (structure not decoded)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if ieType != "" {
+		lines = append([]string{ieType}, lines...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// describePDU walks an NGAP-PDU: choice, procedure code, criticality, open type value
+func describePDU(p []byte) ([]string, error) {
+	value, _, err := openType(p[3:])
+	if err != nil {
+		return nil, err
+	}
+	ies, err := describeContainer(value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{pduTypes[p[0]>>5] + " " + procedures[p[1]]}, ies...), nil
+}
+
+// describeContainer walks a SEQUENCE whose first component is a ProtocolIE-Container
+// Encoding: extension/optional bits in one octet, 16-bit IE count, then per IE a
+// 16-bit ID, one octet of criticality and the open type value
+func describeContainer(p []byte) ([]string, error) {
+	if len(p) < 3 {
+		return nil, fmt.Errorf("NGAP IE container too short (%d bytes)", len(p))
+	}
+	count := int(binary.BigEndian.Uint16(p[1:3]))
+	rest := p[3:]
+
+	var lines []string
+	for i := 0; i < count; i++ {
+		if len(rest) < 4 {
+			return nil, fmt.Errorf("NGAP IE %d of %d truncated", i+1, count)
+		}
+		id := binary.BigEndian.Uint16(rest[0:2])
+		value, next, err := openType(rest[3:])
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, describeIE(id, value))
+		rest = next
+	}
+	return lines, nil
+}
+
+// describeIE returns one line for a protocol IE, with the value for the IEs known here
+func describeIE(id uint16, value []byte) string {
+	name := fmt.Sprintf("IE %d", id)
+	if known, ok := ieNames[id]; ok {
+		name += " " + known
+	}
+
+	switch id {
+	case 10: // AMF-UE-NGAP-ID INTEGER (0..2^40-1): 3-bit length, then the octets
+		if n := int(value[0]>>5) + 1; len(value) > n {
+			return fmt.Sprintf("%s: %d", name, bigEndian(value[1:1+n]))
+		}
+	case 85: // RAN-UE-NGAP-ID INTEGER (0..2^32-1): 2-bit length, then the octets
+		if n := int(value[0]>>6) + 1; len(value) > n {
+			return fmt.Sprintf("%s: %d", name, bigEndian(value[1:1+n]))
+		}
+	case 38: // NAS-PDU OCTET STRING
+		if nas, _, err := openType(value); err == nil {
+			if text, err := decode_nas.Describe(nas); err == nil {
+				return name + ": " + strings.ReplaceAll(text, "\n", ", ")
+			}
+		}
+	case 134: // PDUSessionType ENUMERATED with extension marker
+		if index := int(value[0]>>4) & 0x07; index < len(pduSessionTypes) {
+			return name + ": " + pduSessionTypes[index]
+		}
+	}
+
+	shown := value
+	if len(shown) > maxHexBytes {
+		shown = shown[:maxHexBytes]
+	}
+	text := fmt.Sprintf("%s (%d bytes): %s", name, len(value), hex.EncodeToString(shown))
+	if len(shown) < len(value) {
+		text += "..."
+	}
+	return text
+}
+
+// openType reads an aligned PER length determinant and the value it covers
+// Returns the value and the bytes after it
+func openType(p []byte) ([]byte, []byte, error) {
+	if len(p) == 0 {
+		return nil, nil, fmt.Errorf("missing NGAP length")
+	}
+	length, header := int(p[0]), 1
+	switch {
+	case p[0]&0x80 == 0: // 0..127 in one octet
+	case p[0]&0xc0 == 0x80 && len(p) >= 2: // 128..16383 in two octets
+		length, header = int(binary.BigEndian.Uint16(p[0:2])&0x3fff), 2
+	default:
+		return nil, nil, fmt.Errorf("unsupported NGAP length determinant 0x%02x", p[0])
+	}
+	if length == 0 {
+		return nil, nil, fmt.Errorf("empty NGAP value")
+	}
+	if len(p) < header+length {
+		return nil, nil, fmt.Errorf("NGAP value of %d bytes truncated", length)
+	}
+	return p[header : header+length], p[header+length:], nil
+}
+
+// bigEndian returns the unsigned value of up to 8 octets
+func bigEndian(p []byte) uint64 {
+	var v uint64
+	for _, b := range p {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
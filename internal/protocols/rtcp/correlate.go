@@ -0,0 +1,87 @@
+// correlate.go
+// This file links RTCP reports to the RTP streams and SIP calls they describe.
+// Core functionalities:
+// - Notes sender reports so later receiver reports give a round-trip time
+// - Adds the stream, the reported loss and jitter in milliseconds, the round-trip
+//   time and the values measured at the capture point to each report block
+// - Adds the Call-ID of the call, so reports join the SIP dialog
+//
+// Example scenarios:
+// 1. Receiver report about a captured stream:
+//    RTCPMessage_1_Report_1_Stream: "192.168.56.9:19190 -> 192.168.56.56:30974"
+//    RTCPMessage_1_Report_1_LossPercent: "0.00", RTCPMessage_1_Report_1_LocalLost: "0"
+//    RTCPMessage_1_Report_1_JitterMs: "0.625", RTCPMessage_1_Report_1_LocalJitterMs: "0.185"
+//    RTCPMessage_1_Report_1_RoundTripMs: "1.204"
+//
+// 2. Report about a stream that was not captured:
+//    Only LossPercent and, with the sender report captured, RoundTripMs are added
+
+package decode_rtcp
+
+import (
+	decode_rtp "DeepPacketAI/internal/protocols/rtp" // Streams the reports are about
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// correlate adds stream, call and round-trip fields to a decoded RTCP message
+// Parameters:
+//   - message: Message built by parseRTCPMessage
+//   - packets: Packets of the compound RTCP packet
+//   - src_ipaddr: Address of the reporting endpoint
+//   - seen: Capture timestamp
+//   - callID: Call-ID of the SDP announcing the RTCP port, empty when unknown
+func correlate(message map[string]string, packets []rtcp.Packet, src_ipaddr string, seen time.Time, callID string) {
+	// Sender reports first, a compound packet may answer its own SR
+	for _, pkt := range packets {
+		if sr, ok := pkt.(*rtcp.SenderReport); ok {
+			decode_rtp.SenderReport(sr.SSRC, sr.NTPTime, seen)
+		}
+	}
+
+	for packetIndex, pkt := range packets {
+		var reports []rtcp.ReceptionReport
+		switch rtcpPkt := pkt.(type) {
+		case *rtcp.SenderReport:
+			reports = rtcpPkt.Reports
+		case *rtcp.ReceiverReport:
+			reports = rtcpPkt.Reports
+		}
+		for i, report := range reports {
+			prefix := fmt.Sprintf("RTCPMessage_%d_Report_%d_", packetIndex+1, i+1)
+			f := decode_rtp.Received(decode_rtp.Reception{
+				Reporter:     src_ipaddr,
+				SSRC:         report.SSRC,
+				FractionLost: report.FractionLost,
+				TotalLost:    report.TotalLost,
+				Jitter:       report.Jitter,
+				LastSR:       report.LastSenderReport,
+				DelaySinceSR: report.Delay,
+			}, seen)
+
+			message[prefix+"LossPercent"] = strconv.FormatFloat(f.LossPercent, 'f', 2, 64)
+			if f.RoundTripMs >= 0 {
+				message[prefix+"RoundTripMs"] = strconv.FormatFloat(f.RoundTripMs, 'f', 3, 64)
+			}
+			if !f.Found {
+				continue
+			}
+			message[prefix+"Stream"] = f.Stream
+			message[prefix+"LocalLost"] = strconv.FormatInt(f.LocalLost, 10)
+			message[prefix+"LocalJitterMs"] = strconv.FormatFloat(f.LocalJitterMs, 'f', 3, 64)
+			if f.JitterMs >= 0 {
+				message[prefix+"JitterMs"] = strconv.FormatFloat(f.JitterMs, 'f', 3, 64)
+			}
+			if callID == "" {
+				callID = f.CallID
+			}
+		}
+	}
+
+	if callID != "" {
+		message["Call-ID"] = callID // Header name, so the report joins the call
+	}
+}
@@ -0,0 +1,144 @@
+package decode_rtcp
+
+import (
+	decode_rtp "DeepPacketAI/internal/protocols/rtp"
+	database "DeepPacketAI/internal/storage"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// marshal encodes a compound RTCP packet
+func marshal(t *testing.T, packets ...rtcp.Packet) []byte {
+	t.Helper()
+	data, err := rtcp.Marshal(packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// lastMessage returns the fields of the latest stored RTCP message
+func lastMessage(t *testing.T) map[string]string {
+	t.Helper()
+	var last map[string]string
+	for _, m := range database.Take() {
+		if m.Protocol == "rtcp" {
+			last = m.Message
+		}
+	}
+	if last == nil {
+		t.Fatal("no RTCP message stored")
+	}
+	return last
+}
+
+// TestRoundTrip sends a sender report from 10.0.0.1 and a receiver report about it
+// from 10.0.0.2, and checks the round-trip time from LSR and DLSR
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		ntp   uint64        // NTP timestamp of the sender report
+		lsr   uint32        // LSR echoed by the receiver
+		dlsr  uint32        // DLSR in 1/65536 seconds
+		after time.Duration // Receiver report captured after the sender report
+		want  string        // RoundTripMs, empty when not computed
+	}{
+		{"whole seconds of DLSR", 0x83aa7e80_80000000, 0x7e808000, 81920, 1500 * time.Millisecond, "250.000"},
+		{"fraction of DLSR", 0x83aa7e80_80000000, 0x7e808000, 65536 + 655, 1020 * time.Millisecond, "10.005"},
+		{"largest LSR before the wrap", 0x0001ffff_ffff0000, 0xffffffff, 3277, 100 * time.Millisecond, "49.997"},
+		{"LSR after the wrap", 0x00020000_00010000, 0x00000001, 0, 30 * time.Millisecond, "30.000"},
+		{"LSR 0 means no sender report", 0x00020000_00000000, 0, 0, 30 * time.Millisecond, ""},
+		{"sender report not captured", 0x83aa7e80_80000000, 0x12345678, 0, 30 * time.Millisecond, ""},
+		{"DLSR longer than the capture shows", 0x83aa7e80_80000000, 0x7e808000, 2 * 65536, time.Second, ""},
+	}
+	for _, tt := range tests {
+		decode_rtp.Reset()
+		database.Take()
+		start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+		Process(marshal(t, &rtcp.SenderReport{SSRC: 0x1111, NTPTime: tt.ntp}), "10.0.0.1", "10.0.0.2", start, 1, "")
+		rr := &rtcp.ReceiverReport{SSRC: 0x2222, Reports: []rtcp.ReceptionReport{{SSRC: 0x1111, LastSenderReport: tt.lsr, Delay: tt.dlsr}}}
+		Process(marshal(t, rr), "10.0.0.2", "10.0.0.1", start.Add(tt.after), 2, "")
+
+		if got := lastMessage(t)["RTCPMessage_1_Report_1_RoundTripMs"]; got != tt.want {
+			t.Errorf("%s: RoundTripMs = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// rtpPacket builds a 20 ms PCMU packet
+func rtpPacket(seq uint16, ssrc uint32) []byte {
+	p := make([]byte, 12+160)
+	p[0] = 0x80 // Version 2
+	binary.BigEndian.PutUint16(p[2:4], seq)
+	binary.BigEndian.PutUint32(p[4:8], uint32(seq)*160)
+	binary.BigEndian.PutUint32(p[8:12], ssrc)
+	return p
+}
+
+// TestStreamJoin checks that report blocks join the captured stream they are
+// about, and that the stream record keeps what the receiver reported
+func TestStreamJoin(t *testing.T) {
+	decode_rtp.Reset()
+	database.Take()
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// The same SSRC relayed to two receivers, packet 5 lost on the way to 10.0.0.2
+	call := decode_rtp.Session{CallID: "a84b4c76e66710", Media: "audio"}
+	for seq := uint16(1); seq <= 10; seq++ {
+		seen := start.Add(time.Duration(seq) * 20 * time.Millisecond)
+		if seq != 5 {
+			decode_rtp.Process(rtpPacket(seq, 0x1111), "10.0.0.1", "10.0.0.2", 41000, 52000, seen, uint64(seq), call)
+		}
+		decode_rtp.Process(rtpPacket(seq, 0x1111), "10.0.0.1", "10.0.0.3", 41000, 53000, seen, uint64(seq), decode_rtp.Session{})
+	}
+
+	reports := []rtcp.ReceptionReport{
+		{SSRC: 0x1111, FractionLost: 64, TotalLost: 3, Jitter: 80},
+		{SSRC: 0x9999, FractionLost: 128}, // Stream not captured
+	}
+	Process(marshal(t, &rtcp.ReceiverReport{SSRC: 0x2222, Reports: reports}), "10.0.0.2", "10.0.0.1", start.Add(time.Second), 20, "")
+
+	m := lastMessage(t)
+	want := map[string]string{
+		"RTCPMessage_1_Report_1_Stream":        "10.0.0.1:41000 -> 10.0.0.2:52000",
+		"RTCPMessage_1_Report_1_LossPercent":   "25.00",
+		"RTCPMessage_1_Report_1_LocalLost":     "1",
+		"RTCPMessage_1_Report_1_JitterMs":      "10.000", // 80 units at 8000 Hz
+		"RTCPMessage_1_Report_1_LocalJitterMs": "0.000",
+		"RTCPMessage_1_Report_2_Stream":        "",
+		"RTCPMessage_1_Report_2_LossPercent":   "50.00",
+		"Call-ID":                              "a84b4c76e66710",
+	}
+	for field, value := range want {
+		if m[field] != value {
+			t.Errorf("%s = %q, want %q", field, m[field], value)
+		}
+	}
+
+	// A report from the other receiver is about its own copy of the stream
+	Process(marshal(t, &rtcp.ReceiverReport{SSRC: 0x3333, Reports: reports[:1]}), "10.0.0.3", "10.0.0.1", start.Add(time.Second), 21, "")
+	if got := lastMessage(t)["RTCPMessage_1_Report_1_Stream"]; got != "10.0.0.1:41000 -> 10.0.0.3:53000" {
+		t.Errorf("Stream of the second receiver = %q", got)
+	}
+
+	decode_rtp.Flush()
+	records := 0
+	for _, s := range database.Take() {
+		if s.Protocol != "rtp_stream" || s.Dst_IpAddr != "10.0.0.2" {
+			continue
+		}
+		records++
+		for field, value := range map[string]string{"rtcp_reports": "1", "remote_lost": "3", "remote_loss_percent": "25.00", "remote_jitter_ms": "10.000", "lost": "1"} {
+			if s.Message[field] != value {
+				t.Errorf("stream record %s = %q, want %q", field, s.Message[field], value)
+			}
+		}
+	}
+	if records != 1 {
+		t.Errorf("%d records of the stream to 10.0.0.2, want 1", records)
+	}
+}
@@ -5,26 +5,36 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pion/rtcp"
 )
 
-func Process(p []byte, src_ipaddr string, dst_ipaddr string, time string, frame_num uint64) {
+// Process decodes a compound RTCP packet and links its reports to their streams
+// Parameters:
+//   - p: UDP payload holding the RTCP packet
+//   - src_ipaddr: Source IP address
+//   - dst_ipaddr: Destination IP address
+//   - seen: Packet capture timestamp
+//   - frame_num: Sequential frame number
+//   - callID: Call-ID of the SDP announcing the port, empty when unknown
+func Process(p []byte, src_ipaddr string, dst_ipaddr string, seen time.Time, frame_num uint64, callID string) {
 	rtcpPacket, err := rtcp.Unmarshal(p)
 	if err != nil {
 		return // Skip to the next packet if decoding fails
 	}
 	message := parseRTCPMessage(rtcpPacket)
+	correlate(message, rtcpPacket, src_ipaddr, seen, callID)
 
 	// Store processed message in database
 	// Includes packet metadata and parsed content
 	database.Insert(
-		src_ipaddr, // Source IP address
-		dst_ipaddr, // Destination IP address
-		"rtcp",     // Protocol identifier
-		time,       // Packet timestamp
-		frame_num,  // Frame sequence number
-		message,    // Parsed message content
+		src_ipaddr,                // Source IP address
+		dst_ipaddr,                // Destination IP address
+		"rtcp",                    // Protocol identifier
+		seen.Format(time.RFC3339), // Packet timestamp
+		frame_num,                 // Frame sequence number
+		message,                   // Parsed message content
 	)
 }
 
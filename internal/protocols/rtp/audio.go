@@ -0,0 +1,365 @@
+// audio.go
+// This file turns the packets of an RTP stream back into the audio a receiver played.
+// Core functionalities:
+// - Decodes G.711 µ-law (PCMU), G.711 A-law (PCMA) and G.722 payloads
+// - Simulates a fixed jitter buffer: packets arriving after their playout time are
+//   discarded while the buffer still holds audio, as the receiving phone would have
+//   done; when it runs empty, playout starts over with the next packet
+// - Fills lost and discarded packets and silence suppression with silence
+// - Writes the result as a 16-bit mono WAV file, 8000 Hz or 16000 Hz for G.722
+//
+// Example scenarios:
+// 1. Choppy audio complaint:
+//    With the default 60 ms buffer the stream plays cleanly, with a 20 ms buffer
+//    "late" counts the packets the jitter made the phone discard
+//
+// 2. One-way audio:
+//    The WAV file of the direction the caller did not hear holds the expected
+//    speech, so the media reached the capture point and was lost beyond it
+//
+// Opus and the other codecs of StaticPayloadTypes are not decoded; their packets are
+// counted as skipped and played as silence. DTMF events (telephone-event) and comfort
+// noise are skipped the same way.
+
+package decode_rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultJitterBuffer is the playout delay of the jitter buffer simulation
+// Typical of desk phones, which start between 40 and 80 ms
+const DefaultJitterBuffer = 60 * time.Millisecond
+
+// Playback describes how the packets of a stream were played out
+type Playback struct {
+	Codec      string        // Encoding of the played packets, e.g. "PCMA/8000"
+	SampleRate int           // Samples per second of the audio
+	Packets    int           // RTP packets of the stream
+	Played     int           // Packets decoded into the audio
+	Late       int           // Arrived after their playout time and discarded
+	Underruns  int           // Times the buffer ran empty and playout started over
+	Duplicates int           // Received more than once, played once
+	Skipped    int           // Events, comfort noise and codecs that are not decoded
+	Duration   time.Duration // Length of the audio
+}
+
+// Recorder collects the packets of one RTP stream for playout
+type Recorder struct {
+	ssrc    uint32
+	codecs  map[uint8]string
+	depth   float64 // Jitter buffer delay, seconds
+	first   time.Time
+	packets []recorded
+
+	// Extension of the 16-bit sequence numbers and 32-bit timestamps
+	lastSeq       uint16
+	lastTimestamp uint32
+	seq           int64
+	timestamp     int64
+}
+
+// recorded is a packet waiting for playout
+type recorded struct {
+	arrival   float64 // Seconds since the first packet
+	seq       int64   // Extended sequence number
+	timestamp int64   // Extended RTP timestamp
+	marker    bool
+	codec     string
+	payload   []byte
+	playout   float64 // Seconds since the first packet, set by schedule
+}
+
+// NewRecorder returns a recorder for the packets of one SSRC
+// Parameters:
+//   - ssrc: Synchronisation source of the stream
+//   - codecs: Payload types by number as negotiated, static types are added
+//   - depth: Playout delay of the jitter buffer, DefaultJitterBuffer when 0
+func NewRecorder(ssrc uint32, codecs map[uint8]string, depth time.Duration) *Recorder {
+	if depth <= 0 {
+		depth = DefaultJitterBuffer
+	}
+	return &Recorder{ssrc: ssrc, codecs: codecs, depth: depth.Seconds()}
+}
+
+// Add takes one packet of the stream in the order it was captured
+// Parameters:
+//   - p: UDP payload holding the RTP packet
+//   - seen: Capture timestamp, taken as the arrival time at the receiver
+//
+// Returns false when p is not an RTP packet of the recorder's SSRC
+func (r *Recorder) Add(p []byte, seen time.Time) bool {
+	h, ok := parseHeader(p)
+	if !ok || h.ssrc != r.ssrc {
+		return false
+	}
+	if len(r.packets) == 0 {
+		r.first = seen
+		r.seq, r.timestamp = int64(h.sequenceNumber), int64(h.timestamp)
+	} else {
+		r.seq += int64(int16(h.sequenceNumber - r.lastSeq))
+		r.timestamp += int64(int32(h.timestamp - r.lastTimestamp))
+	}
+	r.lastSeq, r.lastTimestamp = h.sequenceNumber, h.timestamp
+
+	r.packets = append(r.packets, recorded{
+		arrival:   seen.Sub(r.first).Seconds(),
+		seq:       r.seq,
+		timestamp: r.timestamp,
+		marker:    h.marker,
+		codec:     r.codecName(h.payloadType),
+		payload:   append([]byte(nil), h.payload...),
+	})
+	return true
+}
+
+// WriteWAV plays out the packets through the jitter buffer and writes the audio
+// Parameters:
+//   - w: Destination of the WAV file
+//
+// Returns how the stream was played out, or an error when no packet could be decoded
+// or writing failed
+func (r *Recorder) WriteWAV(w io.Writer) (Playback, error) {
+	played, playback := r.schedule()
+	if len(played) == 0 {
+		return playback, fmt.Errorf("no packets of a supported codec (PCMU, PCMA, G722) to play")
+	}
+
+	samples := r.render(played, playback.SampleRate)
+	playback.Duration = time.Duration(len(samples)) * time.Second / time.Duration(playback.SampleRate)
+	return playback, writeWAV(w, samples, playback.SampleRate)
+}
+
+// schedule runs the jitter buffer over the packets in arrival order
+// The playout delay is set again at the start of each talkspurt, as adaptive
+// buffers do, and after the buffer ran empty, e.g. when the sender's clock is
+// slower than the receiver's, without moving audio that was already played
+// Returns the packets to play, in playout order
+func (r *Recorder) schedule() ([]recorded, Playback) {
+	playback := Playback{Packets: len(r.packets), SampleRate: 8000}
+	var played []recorded
+	seen := make(map[int64]bool)
+	var offset, end, lastArrival float64
+	anchored := false
+	counts := make(map[string]int)
+
+	for _, p := range r.packets {
+		rate := clockRate(p.codec)
+		if !decodable(p.codec) || rate == 0 {
+			playback.Skipped++
+			continue
+		}
+		if seen[p.seq] {
+			playback.Duplicates++
+			continue
+		}
+		at := float64(p.timestamp) / rate
+		underrun := anchored && p.arrival > offset+at && p.arrival >= end
+		if underrun {
+			playback.Underruns++
+		}
+		if !anchored || underrun || p.marker || p.arrival-lastArrival > pauseGap.Seconds() {
+			offset = p.arrival + r.depth - at
+			if anchored && offset+at < end {
+				offset = end - at // Keep what the buffer already holds
+			}
+			anchored = true
+		}
+		lastArrival = p.arrival
+		p.playout = offset + at
+		if p.arrival > p.playout {
+			playback.Late++
+			continue
+		}
+		seen[p.seq] = true
+		played = append(played, p)
+		end = max(end, p.playout+float64(samplesOf(p))/float64(sampleRate(p.codec)))
+		counts[p.codec]++
+		if encoding(p.codec) == "g722" {
+			playback.SampleRate = 16000
+		}
+	}
+
+	playback.Played = len(played)
+	for codec, n := range counts {
+		if n > counts[playback.Codec] || (n == counts[playback.Codec] && codec < playback.Codec) {
+			playback.Codec = codec
+		}
+	}
+	sort.SliceStable(played, func(i, j int) bool { return played[i].playout < played[j].playout })
+	return played, playback
+}
+
+// render decodes the scheduled packets into one signal
+// Gaps between packets are silence, overlapping samples are dropped
+func (r *Recorder) render(played []recorded, rate int) []int16 {
+	var samples []int16
+	g722 := newG722Decoder()
+	start := played[0].playout
+	for _, p := range played {
+		var decoded []int16
+		switch encoding(p.codec) {
+		case "pcmu":
+			decoded = decodeG711(p.payload, ulaw)
+		case "pcma":
+			decoded = decodeG711(p.payload, alaw)
+		case "g722":
+			decoded = g722.decode(p.payload)
+		}
+		decoded = resample(decoded, sampleRate(p.codec), rate)
+
+		at := int(math.Round((p.playout - start) * float64(rate)))
+		if at > len(samples) {
+			samples = append(samples, make([]int16, at-len(samples))...)
+		}
+		if skip := len(samples) - at; skip > 0 {
+			decoded = decoded[min(skip, len(decoded)):]
+		}
+		samples = append(samples, decoded...)
+	}
+	return samples
+}
+
+// codecName returns the encoding of a payload type, e.g. "PCMA/8000"
+func (r *Recorder) codecName(pt uint8) string {
+	if name, ok := r.codecs[pt]; ok {
+		return name
+	}
+	if name, ok := StaticPayloadTypes[pt]; ok {
+		return name
+	}
+	return strconv.Itoa(int(pt))
+}
+
+// PayloadTypes reads the payload types of an "rtp_stream" record
+// Example: "0 PCMU/8000, 101 telephone-event/8000" gives 0: "PCMU/8000", 101: "telephone-event/8000"
+func PayloadTypes(list string) map[uint8]string {
+	codecs := make(map[uint8]string)
+	for _, item := range strings.Split(list, ",") {
+		number, name, found := strings.Cut(strings.TrimSpace(item), " ")
+		pt, err := strconv.ParseUint(number, 10, 7)
+		if found && err == nil {
+			codecs[uint8(pt)] = name
+		}
+	}
+	return codecs
+}
+
+// decodable tells whether the payloads of an encoding can be played
+func decodable(codec string) bool {
+	switch encoding(codec) {
+	case "pcmu", "pcma", "g722":
+		return true
+	}
+	return false
+}
+
+// sampleRate returns the rate the decoder of a codec produces samples at
+// G.722 is sampled at 16000 Hz although its RTP clock is 8000 Hz
+func sampleRate(codec string) int {
+	if encoding(codec) == "g722" {
+		return 16000
+	}
+	return 8000
+}
+
+// samplesOf returns the number of samples a packet decodes to
+func samplesOf(p recorded) int {
+	if encoding(p.codec) == "g722" {
+		return 2 * len(p.payload)
+	}
+	return len(p.payload)
+}
+
+// resample converts between 8000 and 16000 Hz for streams switching codecs
+// Samples are repeated or dropped; the switch is rare enough for the quality to do
+func resample(samples []int16, from int, to int) []int16 {
+	switch {
+	case from == to:
+		return samples
+	case from < to:
+		out := make([]int16, 0, 2*len(samples))
+		for _, s := range samples {
+			out = append(out, s, s)
+		}
+		return out
+	default:
+		out := make([]int16, 0, len(samples)/2)
+		for i := 0; i < len(samples); i += 2 {
+			out = append(out, samples[i])
+		}
+		return out
+	}
+}
+
+// decodeG711 expands G.711 octets to 16-bit samples
+func decodeG711(payload []byte, expand func(byte) int16) []int16 {
+	out := make([]int16, len(payload))
+	for i, b := range payload {
+		out[i] = expand(b)
+	}
+	return out
+}
+
+// ulaw expands a µ-law octet (ITU-T G.711)
+func ulaw(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0f)<<3 + 0x84) << ((u & 0x70) >> 4)
+	if u&0x80 != 0 {
+		return int16(0x84 - t)
+	}
+	return int16(t - 0x84)
+}
+
+// alaw expands an A-law octet (ITU-T G.711)
+func alaw(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0f) << 4
+	switch segment := (a & 0x70) >> 4; segment {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t = (t + 0x108) << (segment - 1)
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+// writeWAV writes 16-bit mono samples as a RIFF WAVE file
+func writeWAV(w io.Writer, samples []int16, rate int) error {
+	size := 2 * len(samples)
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(36+size))
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)           // Format chunk size
+	binary.LittleEndian.PutUint16(header[20:], 1)            // PCM
+	binary.LittleEndian.PutUint16(header[22:], 1)            // Mono
+	binary.LittleEndian.PutUint32(header[24:], uint32(rate)) // Sample rate
+	binary.LittleEndian.PutUint32(header[28:], uint32(2*rate))
+	binary.LittleEndian.PutUint16(header[32:], 2)  // Block align
+	binary.LittleEndian.PutUint16(header[34:], 16) // Bits per sample
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(size))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	data := make([]byte, size)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(s))
+	}
+	_, err := w.Write(data)
+	return err
+}
@@ -0,0 +1,221 @@
+package decode_rtp
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestG711 expands octets whose linear values are given by the G.711 tables
+func TestG711(t *testing.T) {
+	tests := []struct {
+		name   string
+		expand func(byte) int16
+		octet  byte
+		want   int16
+	}{
+		{"ulaw", ulaw, 0x00, -32124},
+		{"ulaw", ulaw, 0x0f, -16764},
+		{"ulaw", ulaw, 0x1f, -8316},
+		{"ulaw", ulaw, 0x3f, -1980},
+		{"ulaw", ulaw, 0x5a, -556},
+		{"ulaw", ulaw, 0x7e, -8},
+		{"ulaw", ulaw, 0x7f, 0},
+		{"ulaw", ulaw, 0x80, 32124},
+		{"ulaw", ulaw, 0x8f, 16764},
+		{"ulaw", ulaw, 0xa7, 6140},
+		{"ulaw", ulaw, 0xdb, 524},
+		{"ulaw", ulaw, 0xfe, 8},
+		{"ulaw", ulaw, 0xff, 0},
+		{"alaw", alaw, 0x00, -5504},
+		{"alaw", alaw, 0x2a, -32256},
+		{"alaw", alaw, 0x54, -24},
+		{"alaw", alaw, 0x55, -8},
+		{"alaw", alaw, 0x7f, -848},
+		{"alaw", alaw, 0x80, 5504},
+		{"alaw", alaw, 0xaa, 32256},
+		{"alaw", alaw, 0xd4, 24},
+		{"alaw", alaw, 0xd5, 8},
+		{"alaw", alaw, 0xff, 848},
+	}
+	for _, tt := range tests {
+		if got := tt.expand(tt.octet); got != tt.want {
+			t.Errorf("%s(0x%02x) = %d, want %d", tt.name, tt.octet, got, tt.want)
+		}
+	}
+}
+
+// g722Encode encodes 16 kHz samples with the transmit side of G.722 (blocks 1L to 2H),
+// sharing the band state and adaptation of the decoder
+func g722Encode(samples []int16) []byte {
+	var (
+		q6  = [32]int{0, 35, 72, 110, 150, 190, 233, 276, 323, 370, 422, 473, 530, 587, 650, 714, 786, 858, 940, 1023, 1121, 1219, 1339, 1458, 1612, 1765, 1980, 2195, 2557, 2919, 0, 0}
+		iln = [32]int{0, 63, 62, 31, 30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 0}
+		ilp = [32]int{0, 61, 60, 59, 58, 57, 56, 55, 54, 53, 52, 51, 50, 49, 48, 47, 46, 45, 44, 43, 42, 41, 40, 39, 38, 37, 36, 35, 34, 33, 32, 0}
+		ihn = [3]int{0, 1, 0}
+		ihp = [3]int{0, 3, 2}
+	)
+	g := newG722Decoder()
+	var out []byte
+	for j := 0; j+1 < len(samples); j += 2 {
+		// Transmit QMF
+		copy(g.x[:22], g.x[2:])
+		g.x[22], g.x[23] = int(samples[j]), int(samples[j+1])
+		var odd, even int
+		for i := 0; i < 12; i++ {
+			even += g.x[2*i] * g722QMF[i]
+			odd += g.x[2*i+1] * g722QMF[11-i]
+		}
+		xlow, xhigh := (odd+even)>>14, (odd-even)>>14
+
+		// Low band quantiser
+		lb := &g.band[0]
+		el := saturate(xlow - lb.s)
+		wd := el
+		if el < 0 {
+			wd = -(el + 1)
+		}
+		i := 1
+		for ; i < 30 && wd >= (q6[i]*lb.det)>>12; i++ {
+		}
+		low := ilp[i]
+		if el < 0 {
+			low = iln[i]
+		}
+		dlow := (lb.det * g722QM4[low>>2]) >> 15
+		lb.nb = limit((lb.nb*127)>>7+g722WL[g722RL42[low>>2]], 0, 18432)
+		lb.det = scale(lb.nb, 8)
+		lb.adapt(dlow)
+
+		// High band quantiser
+		hb := &g.band[1]
+		eh := saturate(xhigh - hb.s)
+		wd = eh
+		if eh < 0 {
+			wd = -(eh + 1)
+		}
+		level := 1
+		if wd >= (564*hb.det)>>12 {
+			level = 2
+		}
+		high := ihp[level]
+		if eh < 0 {
+			high = ihn[level]
+		}
+		dhigh := (hb.det * g722QM2[high]) >> 15
+		hb.nb = limit((hb.nb*127)>>7+g722WH[g722RH2[high]], 0, 22528)
+		hb.det = scale(hb.nb, 10)
+		hb.adapt(dhigh)
+
+		out = append(out, byte(high<<6|low))
+	}
+	return out
+}
+
+// TestG722 encodes one second of a tone in each band and checks that the decoder
+// gives it back, after the delay of the two QMFs, with the quality of 64 kbit/s G.722
+func TestG722(t *testing.T) {
+	const delay = 22 // Samples of delay of the transmit and receive QMF
+	tests := []struct {
+		frequency float64
+		minSNR    float64 // dB
+	}{
+		{300, 40},
+		{1000, 40},
+		{3000, 30},
+		{6000, 20},
+	}
+	for _, tt := range tests {
+		tone := make([]int16, 16000)
+		for i := range tone {
+			tone[i] = int16(8000 * math.Sin(2*math.Pi*tt.frequency*float64(i)/16000))
+		}
+		payload := g722Encode(tone)
+		decoded := newG722Decoder().decode(payload)
+		if len(decoded) != 2*len(payload) {
+			t.Fatalf("%.0f Hz: %d samples from %d octets", tt.frequency, len(decoded), len(payload))
+		}
+
+		// Skip the adaptation at the start
+		var signal, noise float64
+		for i := 1000; i < len(tone)-delay; i++ {
+			e := float64(decoded[i+delay]) - float64(tone[i])
+			signal += float64(tone[i]) * float64(tone[i])
+			noise += e * e
+		}
+		if snr := 10 * math.Log10(signal/noise); snr < tt.minSNR {
+			t.Errorf("%.0f Hz: SNR %.1f dB, want at least %.0f dB", tt.frequency, snr, tt.minSNR)
+		}
+	}
+}
+
+// pcmuPacket builds a 20 ms PCMU packet of SSRC 7 with the sequence number seq
+func pcmuPacket(seq uint16) []byte {
+	p := make([]byte, 12+160)
+	p[0] = 0x80 // Version 2
+	binary.BigEndian.PutUint16(p[2:4], seq)
+	binary.BigEndian.PutUint32(p[4:8], uint32(seq)*160)
+	binary.BigEndian.PutUint32(p[8:12], 7)
+	return p
+}
+
+// TestSchedule plays packet sequences through the default 60 ms jitter buffer
+func TestSchedule(t *testing.T) {
+	type arrival struct {
+		seq uint16
+		at  int // Milliseconds after the first packet
+	}
+	tests := []struct {
+		name    string
+		packets []arrival
+		want    Playback
+	}{
+		{
+			name:    "on time",
+			packets: []arrival{{0, 0}, {1, 20}, {2, 40}, {3, 60}},
+			want:    Playback{Packets: 4, Played: 4},
+		},
+		{
+			// Packet 1 is due at 80 ms, 60 ms after its nominal arrival
+			name:    "within the buffer",
+			packets: []arrival{{0, 0}, {1, 75}, {2, 76}, {3, 77}},
+			want:    Playback{Packets: 4, Played: 4},
+		},
+		{
+			// Packet 3 is due at 120 ms, packet 4 keeps the buffer filled until 160 ms
+			name:    "late packet",
+			packets: []arrival{{0, 0}, {1, 20}, {2, 40}, {4, 80}, {3, 130}, {5, 135}},
+			want:    Playback{Packets: 6, Played: 5, Late: 1},
+		},
+		{
+			// The buffer runs empty at 120 ms; playout starts over with packet 3
+			name:    "underrun",
+			packets: []arrival{{0, 0}, {1, 20}, {2, 40}, {3, 300}, {4, 320}, {5, 340}},
+			want:    Playback{Packets: 6, Played: 6, Underruns: 1},
+		},
+		{
+			name: "late packet and underrun",
+			packets: []arrival{{0, 0}, {1, 20}, {3, 60}, {2, 110}, {4, 115},
+				{5, 300}, {6, 320}},
+			want: Playback{Packets: 7, Played: 6, Late: 1, Underruns: 1},
+		},
+		{
+			name:    "duplicate",
+			packets: []arrival{{0, 0}, {1, 20}, {1, 21}, {2, 40}},
+			want:    Playback{Packets: 4, Played: 3, Duplicates: 1},
+		},
+	}
+	for _, tt := range tests {
+		r := NewRecorder(7, nil, 0)
+		start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+		for _, p := range tt.packets {
+			r.Add(pcmuPacket(p.seq), start.Add(time.Duration(p.at)*time.Millisecond))
+		}
+		_, got := r.schedule()
+		tt.want.Codec, tt.want.SampleRate = "PCMU/8000", 8000
+		if got != tt.want {
+			t.Errorf("%s: playback = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// g722.go
+// This file decodes G.722 wideband speech (ITU-T G.722, 64 kbit/s mode).
+// Core functionalities:
+// - Splits each octet into a 6-bit low band and a 2-bit high band ADPCM code
+// - Adapts the quantiser scale and pole-zero predictor of each band (blocks 1 to 6)
+// - Recombines both bands with the receive QMF into 16 kHz samples
+//
+// Example scenarios:
+// 1. HD voice call leg with "9 G722/8000":
+//    A 20 ms packet of 160 octets gives 320 samples at 16000 Hz
+//
+// The RTP clock of G.722 runs at 8000 Hz for historical reasons (RFC 3551
+// section 4.5.2), although the signal is sampled at 16000 Hz
+
+package decode_rtp
+
+// Quantiser and scale tables of G.722
+var (
+	g722WL   = [8]int{-60, -30, 58, 172, 334, 538, 1198, 3042}
+	g722RL42 = [16]int{0, 7, 6, 5, 4, 3, 2, 1, 7, 6, 5, 4, 3, 2, 1, 0}
+	g722ILB  = [32]int{
+		2048, 2093, 2139, 2186, 2233, 2282, 2332, 2383,
+		2435, 2489, 2543, 2599, 2656, 2714, 2774, 2834,
+		2896, 2960, 3025, 3091, 3158, 3228, 3298, 3371,
+		3444, 3520, 3597, 3676, 3756, 3838, 3922, 4008,
+	}
+	g722WH  = [3]int{0, -214, 798}
+	g722RH2 = [4]int{2, 1, 2, 1}
+	g722QM2 = [4]int{-7408, -1616, 7408, 1616}
+	g722QM4 = [16]int{
+		0, -20456, -12896, -8968, -6288, -4240, -2584, -1200,
+		20456, 12896, 8968, 6288, 4240, 2584, 1200, 0,
+	}
+	g722QM6 = [64]int{
+		-136, -136, -136, -136, -24808, -21904, -19008, -16704,
+		-14984, -13512, -12280, -11192, -10232, -9360, -8576, -7856,
+		-7192, -6576, -6000, -5456, -4944, -4464, -4008, -3576,
+		-3168, -2776, -2400, -2032, -1688, -1360, -1040, -728,
+		24808, 21904, 19008, 16704, 14984, 13512, 12280, 11192,
+		10232, 9360, 8576, 7856, 7192, 6576, 6000, 5456,
+		4944, 4464, 4008, 3576, 3168, 2776, 2400, 2032,
+		1688, 1360, 1040, 728, 432, 136, -432, -136,
+	}
+	g722QMF = [12]int{3, -11, 12, 32, -210, 951, 3876, -805, 362, -156, 53, -11}
+)
+
+// g722Band is the ADPCM state of the low or the high band
+type g722Band struct {
+	s, sp, sz int
+	r, a, ap  [3]int
+	p         [3]int
+	d, b, bp  [7]int
+	sg        [7]int
+	nb, det   int
+}
+
+// g722Decoder is the state of one G.722 stream
+// The state carries over from packet to packet, so a decoder serves one stream only
+type g722Decoder struct {
+	band [2]g722Band
+	x    [24]int // Receive QMF delay line
+}
+
+// newG722Decoder returns a decoder in the reset state of G.722
+func newG722Decoder() *g722Decoder {
+	d := &g722Decoder{}
+	d.band[0].det = 32
+	d.band[1].det = 8
+	return d
+}
+
+// decode converts G.722 octets to 16-bit samples at 16000 Hz, two per octet
+func (g *g722Decoder) decode(payload []byte) []int16 {
+	out := make([]int16, 0, 2*len(payload))
+	for _, code := range payload {
+		low := int(code) & 0x3f
+		high := int(code>>6) & 0x03
+
+		// Low band: inverse quantiser, reconstruction, scale adaptation
+		lb := &g.band[0]
+		rlow := lb.s + (lb.det*g722QM6[low])>>15
+		rlow = limit(rlow, -16384, 16383)
+		dlow := (lb.det * g722QM4[low>>2]) >> 15
+		nb := (lb.nb*127)>>7 + g722WL[g722RL42[low>>2]]
+		lb.nb = limit(nb, 0, 18432)
+		lb.det = scale(lb.nb, 8)
+		lb.adapt(dlow)
+
+		// High band
+		hb := &g.band[1]
+		dhigh := (hb.det * g722QM2[high]) >> 15
+		rhigh := limit(dhigh+hb.s, -16384, 16383)
+		nb = (hb.nb*127)>>7 + g722WH[g722RH2[high]]
+		hb.nb = limit(nb, 0, 22528)
+		hb.det = scale(hb.nb, 10)
+		hb.adapt(dhigh)
+
+		// Receive QMF: two output samples from the sum and difference of the bands
+		copy(g.x[:22], g.x[2:])
+		g.x[22] = rlow + rhigh
+		g.x[23] = rlow - rhigh
+		var out1, out2 int
+		for i := 0; i < 12; i++ {
+			out2 += g.x[2*i] * g722QMF[i]
+			out1 += g.x[2*i+1] * g722QMF[11-i]
+		}
+		out = append(out, int16(saturate(out1>>11)), int16(saturate(out2>>11)))
+	}
+	return out
+}
+
+// adapt updates the predictor of a band with the quantised difference (block 4)
+func (b *g722Band) adapt(d int) {
+	b.d[0] = d
+	b.r[0] = saturate(b.s + d)
+	b.p[0] = saturate(b.sz + d)
+
+	// Second pole coefficient
+	for i := 0; i < 3; i++ {
+		b.sg[i] = b.p[i] >> 15
+	}
+	wd1 := saturate(b.a[1] * 4)
+	wd2 := wd1
+	if b.sg[0] == b.sg[1] {
+		wd2 = -wd1
+	}
+	if wd2 > 32767 {
+		wd2 = 32767
+	}
+	wd3 := -128
+	if b.sg[0] == b.sg[2] {
+		wd3 = 128
+	}
+	wd3 += wd2 >> 7
+	wd3 += (b.a[2] * 32512) >> 15
+	b.ap[2] = limit(wd3, -12288, 12288)
+
+	// First pole coefficient
+	b.sg[0] = b.p[0] >> 15
+	b.sg[1] = b.p[1] >> 15
+	wd1 = -192
+	if b.sg[0] == b.sg[1] {
+		wd1 = 192
+	}
+	b.ap[1] = saturate(wd1 + (b.a[1]*32640)>>15)
+	bound := saturate(15360 - b.ap[2])
+	b.ap[1] = limit(b.ap[1], -bound, bound)
+
+	// Zero coefficients
+	wd1 = 128
+	if d == 0 {
+		wd1 = 0
+	}
+	b.sg[0] = d >> 15
+	for i := 1; i < 7; i++ {
+		b.sg[i] = b.d[i] >> 15
+		wd2 = -wd1
+		if b.sg[i] == b.sg[0] {
+			wd2 = wd1
+		}
+		b.bp[i] = saturate(wd2 + (b.b[i]*32640)>>15)
+	}
+
+	// Delay lines
+	for i := 6; i > 0; i-- {
+		b.d[i] = b.d[i-1]
+		b.b[i] = b.bp[i]
+	}
+	for i := 2; i > 0; i-- {
+		b.r[i] = b.r[i-1]
+		b.p[i] = b.p[i-1]
+		b.a[i] = b.ap[i]
+	}
+
+	// Pole and zero section outputs, and the signal estimate
+	wd1 = (b.a[1] * saturate(b.r[1]+b.r[1])) >> 15
+	wd2 = (b.a[2] * saturate(b.r[2]+b.r[2])) >> 15
+	b.sp = saturate(wd1 + wd2)
+	b.sz = 0
+	for i := 6; i > 0; i-- {
+		b.sz += (b.b[i] * saturate(b.d[i]+b.d[i])) >> 15
+	}
+	b.sz = saturate(b.sz)
+	b.s = saturate(b.sp + b.sz)
+}
+
+// scale converts the logarithmic scale factor of a band to its linear value (blocks 3L and 3H)
+func scale(nb int, shift int) int {
+	wd1 := (nb >> 6) & 31
+	wd2 := shift - (nb >> 11)
+	if wd2 < 0 {
+		return (g722ILB[wd1] << -wd2) << 2
+	}
+	return (g722ILB[wd1] >> wd2) << 2
+}
+
+// limit clamps v to [lo, hi]
+func limit(v int, lo int, hi int) int {
+	return max(lo, min(hi, v))
+}
+
+// saturate clamps v to the 16-bit sample range
+func saturate(v int) int {
+	return limit(v, -32768, 32767)
+}
@@ -0,0 +1,141 @@
+// report.go
+// This file joins RTCP sender and receiver reports to the RTP streams they describe.
+// Core functionalities:
+// - Remembers when each sender report was captured, by SSRC and NTP timestamp
+// - Finds the stream a reception report block is about, by SSRC and receiver address
+// - Computes the round-trip time from LSR and DLSR (RFC 3550 section 6.4.1)
+// - Keeps the loss and jitter the receiver reported for the stream record
+//
+// Example scenarios:
+// 1. Asymmetric loss:
+//    The stream record shows "lost": "0" measured at the capture point next to
+//    "remote_lost": "187", so packets were lost between the capture point and the receiver
+//
+// 2. One-way audio:
+//    Reports from one phone show its peer's stream with "remote_loss_percent": "100.00"
+//    while the stream record of the opposite direction has packets, or is missing
+//
+// Round-trip times are measured from the capture point: the time between capturing
+// the sender report and capturing the receiver report that refers to it, less the
+// delay the receiver reported. Captured at the sender, this is the full round trip.
+
+package decode_rtp
+
+import "time"
+
+// senderReportAge is how long a sender report is kept for round-trip times
+// Receivers report at least every few seconds (RFC 3550 section 6.2)
+const senderReportAge = time.Minute
+
+// senderReports holds the capture times of sender reports by SSRC and the
+// middle 32 bits of their NTP timestamp, the LSR value receivers echo
+// Replaced by Reset before each capture
+var senderReports = make(map[uint64]time.Time)
+
+// Reception is one reception report block of an SR or RR (RFC 3550 section 6.4.1)
+type Reception struct {
+	Reporter     string // Address of the receiver sending the report
+	SSRC         uint32 // Source whose stream is reported on
+	FractionLost uint8  // Lost since the previous report, in 1/256
+	TotalLost    uint32 // Lost since the start of reception
+	Jitter       uint32 // Interarrival jitter in timestamp units
+	LastSR       uint32 // LSR: middle 32 bits of the NTP timestamp of the last SR received
+	DelaySinceSR uint32 // DLSR: delay since receiving that SR, in 1/65536 seconds
+}
+
+// Feedback is what a reception report tells about a captured stream
+type Feedback struct {
+	Found         bool    // The stream was captured, the fields below are set
+	CallID        string  // Call of the stream, empty when unknown
+	Stream        string  // "source -> destination" of the stream
+	LossPercent   float64 // Fraction lost as reported by the receiver
+	JitterMs      float64 // Reported jitter, -1 when the clock rate is unknown
+	RoundTripMs   float64 // -1 when the sender report was not captured
+	LocalLost     int64   // Lost packets measured at the capture point
+	LocalJitterMs float64 // Jitter measured at the capture point
+}
+
+// SenderReport notes the capture time of a sender report
+// Parameters:
+//   - ssrc: SSRC of the sender
+//   - ntpTime: NTP timestamp of the report
+//   - seen: Capture timestamp
+func SenderReport(ssrc uint32, ntpTime uint64, seen time.Time) {
+	for key, at := range senderReports {
+		if seen.Sub(at) > senderReportAge {
+			delete(senderReports, key)
+		}
+	}
+	middle := uint32(ntpTime >> 16)
+	senderReports[uint64(ssrc)<<32|uint64(middle)] = seen
+}
+
+// Received joins a reception report block to its stream
+// The stream keeps the latest report and the round-trip times for its record
+// Parameters:
+//   - r: Report block
+//   - seen: Capture timestamp of the report
+//
+// Returns what the report tells; Feedback.Found is false when the stream was not
+// captured or has already been stored, the loss and round trip are set regardless
+func Received(r Reception, seen time.Time) Feedback {
+	f := Feedback{
+		LossPercent: float64(r.FractionLost) / 256 * 100,
+		JitterMs:    -1,
+		RoundTripMs: -1,
+	}
+
+	// Round trip: capture of the RR, less capture of the SR, less the receiver's delay
+	if r.LastSR != 0 {
+		if sent, ok := senderReports[uint64(r.SSRC)<<32|uint64(r.LastSR)]; ok {
+			rtt := seen.Sub(sent) - time.Duration(float64(r.DelaySinceSR)/65536*float64(time.Second))
+			if rtt >= 0 {
+				f.RoundTripMs = float64(rtt) / float64(time.Millisecond)
+			}
+		}
+	}
+
+	s := reported(r.SSRC, r.Reporter)
+	if s == nil {
+		return f
+	}
+	f.Found = true
+	f.CallID = s.session.CallID
+	f.Stream = address(s.src, s.srcPort) + " -> " + address(s.dst, s.dstPort)
+	_, f.LocalLost, _ = s.counts()
+	f.LocalJitterMs = s.jitterMs()
+	if s.clock > 0 {
+		f.JitterMs = float64(r.Jitter) / s.clock * 1000
+	}
+
+	s.reports++
+	s.remoteFraction = f.LossPercent
+	s.remoteLost = int64(int32(r.TotalLost<<8) >> 8) // Signed 24 bits, negative with duplicates
+	s.remoteJitterMs = f.JitterMs
+	if f.RoundTripMs >= 0 {
+		s.roundTrips++
+		s.roundTripSum += f.RoundTripMs
+		if f.RoundTripMs > s.maxRoundTrip {
+			s.maxRoundTrip = f.RoundTripMs
+		}
+	}
+	return f
+}
+
+// reported returns the open stream with an SSRC, preferring the one sent to the reporter
+// The same SSRC may be relayed to several receivers, e.g. by a conference bridge
+func reported(ssrc uint32, reporter string) *stream {
+	var found *stream
+	for _, s := range streams {
+		if s.ssrc != ssrc {
+			continue
+		}
+		if s.dst == reporter {
+			return s
+		}
+		if found == nil || s.firstFrame < found.firstFrame {
+			found = s
+		}
+	}
+	return found
+}
@@ -1,61 +1,123 @@
 package decode_rtp
 
 import (
-	database "DeepPacketAI/internal/storage"
-	"encoding/hex"
+	"encoding/binary"
 	"strconv"
-
-	"github.com/google/gopacket"
-	"github.com/sipcapture/heplify/ownlayers"
+	"strings"
+	"time"
 )
 
-// Create a decoding layer parser and add your custom decoder
-var parser *gopacket.DecodingLayerParser
-
-func Process(l gopacket.Layer, src_ipaddr string, dst_ipaddr string, time string, frame_num uint64) {
-	rtp, _ := l.(*ownlayers.RTP)
-	message := parseRTPMessage(rtp)
-	database.Insert(
-		src_ipaddr, // Source IP address
-		dst_ipaddr, // Destination IP address
-		"rtp",      // Protocol identifier
-		time,       // Packet timestamp
-		frame_num,  // Frame sequence number
-		message,    // Parsed message content
-	)
-
-}
-
-// Function to convert RTP struct to map[string]string
-func parseRTPMessage(rtp *ownlayers.RTP) map[string]string {
-	rtpData := make(map[string]string)
-
-	// Convert numerical values to strings
-	rtpData["Version"] = strconv.Itoa(int(rtp.Version))
-	rtpData["Padding"] = strconv.Itoa(int(rtp.Padding))
-	rtpData["Extension"] = strconv.Itoa(int(rtp.Extension))
-	rtpData["CC"] = strconv.Itoa(int(rtp.CC))
-	rtpData["Marker"] = strconv.Itoa(int(rtp.Marker))
-	rtpData["PayloadType"] = strconv.Itoa(int(rtp.PayloadType))
-	rtpData["SequenceNumber"] = strconv.Itoa(int(rtp.SequenceNumber))
-	rtpData["Timestamp"] = strconv.Itoa(int(rtp.Timestamp))
-	rtpData["Ssrc"] = strconv.Itoa(int(rtp.Ssrc))
-
-	// Convert CSRC (array) to string
-	var csrcStr string
-	for _, csrc := range rtp.Csrc {
-		csrcStr += strconv.Itoa(int(csrc)) + " "
-	}
-	rtpData["Csrc"] = csrcStr
-
-	// Convert Extension Headers
-	rtpData["ExtensionHeaderID"] = strconv.Itoa(int(rtp.ExtensionHeaderID))
-	rtpData["ExtensionHeaderLength"] = strconv.Itoa(int(rtp.ExtensionHeaderLength))
-	rtpData["ExtensionHeader"] = hex.EncodeToString(rtp.ExtensionHeader)
-
-	// Convert Payload and Contents to Hex format for readability
-	rtpData["Payload"] = hex.EncodeToString(rtp.Payload)
-	rtpData["Contents"] = hex.EncodeToString(rtp.Contents)
-
-	return rtpData
+// Session describes what the signaling negotiated for a media endpoint
+// The zero value stands for RTP found on a mapped port without SDP
+type Session struct {
+	CallID string           // Call-ID of the SIP call
+	Media  string           // Media type, e.g. "audio"
+	Codecs map[uint8]string // Payload types by number, e.g. 8: "PCMA/8000"
+}
+
+// StaticPayloadTypes names the payload types with a fixed meaning (RFC 3551 section 6)
+var StaticPayloadTypes = map[uint8]string{
+	0:  "PCMU/8000",
+	3:  "GSM/8000",
+	4:  "G723/8000",
+	8:  "PCMA/8000",
+	9:  "G722/8000",
+	13: "CN/8000",
+	18: "G729/8000",
+	26: "JPEG/90000",
+	31: "H261/90000",
+	34: "H263/90000",
+}
+
+// header is the fixed RTP header (RFC 3550 section 5.1)
+type header struct {
+	marker         bool
+	payloadType    uint8
+	sequenceNumber uint16
+	timestamp      uint32
+	ssrc           uint32
+	payload        []byte // After CSRCs and header extension, without padding
+}
+
+// parseHeader reads the RTP header of a UDP payload
+// Returns false when data is not a version 2 RTP packet
+func parseHeader(data []byte) (header, bool) {
+	if len(data) < 12 || data[0]>>6 != 2 {
+		return header{}, false
+	}
+	h := header{
+		marker:         data[1]&0x80 != 0,
+		payloadType:    data[1] & 0x7f,
+		sequenceNumber: binary.BigEndian.Uint16(data[2:4]),
+		timestamp:      binary.BigEndian.Uint32(data[4:8]),
+		ssrc:           binary.BigEndian.Uint32(data[8:12]),
+	}
+	offset := 12 + 4*int(data[0]&0x0f) // CSRC list
+	if data[0]&0x10 != 0 {
+		// Header extension: profile-defined word, then its length in 32-bit words
+		if len(data) < offset+4 {
+			return header{}, false
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+	}
+	end := len(data)
+	if data[0]&0x20 != 0 && end > offset {
+		end -= int(data[end-1]) // The last octet counts the padding
+	}
+	if offset > end {
+		return header{}, false
+	}
+	h.payload = data[offset:end]
+	return h, true
+}
+
+// Process adds an RTP packet to the statistics of its stream
+// Packets are not stored one by one; each stream is stored as one "rtp_stream"
+// record when it goes quiet or the capture ends
+// Parameters:
+//   - p: UDP payload holding the RTP packet
+//   - src_ipaddr, dst_ipaddr: IP addresses of the sender and receiver
+//   - src_port, dst_port: UDP ports of the sender and receiver
+//   - seen: Packet capture timestamp
+//   - frame_num: Sequential frame number
+//   - session: What SDP announced for the endpoint
+func Process(p []byte, src_ipaddr string, dst_ipaddr string, src_port uint16, dst_port uint16, seen time.Time, frame_num uint64, session Session) {
+	h, ok := parseHeader(p)
+	if !ok {
+		return
+	}
+	s := find(h.ssrc, src_ipaddr, dst_ipaddr, src_port, dst_port, seen, frame_num, session)
+	s.add(h, seen, frame_num)
+}
+
+// codecName returns the encoding of a payload type, e.g. "PCMA/8000"
+// Dynamic payload types without an a=rtpmap are returned as their number
+func (s *stream) codecName(pt uint8) string {
+	if name, ok := s.session.Codecs[pt]; ok {
+		return name
+	}
+	if name, ok := StaticPayloadTypes[pt]; ok {
+		return name
+	}
+	return strconv.Itoa(int(pt))
+}
+
+// clockRate returns the RTP clock rate of an encoding, 0 when unknown
+// Example: "opus/48000/2" gives 48000
+func clockRate(codec string) float64 {
+	parts := strings.Split(codec, "/")
+	if len(parts) < 2 {
+		return 0
+	}
+	rate, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || rate <= 0 {
+		return 0
+	}
+	return rate
+}
+
+// encoding returns the lower-case encoding name of a codec, e.g. "pcma"
+func encoding(codec string) string {
+	name, _, _ := strings.Cut(codec, "/")
+	return strings.ToLower(name)
 }
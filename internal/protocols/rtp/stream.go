@@ -0,0 +1,423 @@
+// stream.go
+// This file aggregates RTP packets into streams and measures their quality.
+// Core functionalities:
+// - Groups packets by SSRC and the addresses and ports of sender and receiver
+// - Counts lost, duplicate and out-of-order packets from the sequence numbers (RFC 3550 A.1, A.3)
+// - Computes the interarrival jitter (RFC 3550 A.8) and the largest gap between packets
+// - Notes the payload types used and how often the sender switched between them
+// - Stores one "rtp_stream" record per stream, with an estimated R-factor and MOS
+//
+// Example scenarios:
+// 1. Clean G.711 call leg:
+//    {"Call-ID": "a84b4c76e66710", "ssrc": "0x5a1b2c3d", "codec": "PCMA/8000", "packets": "3150",
+//     "lost": "0", "jitter_ms": "0.412", "max_delta_ms": "20.931", "mos": "4.39"}
+//
+// 2. Lossy Wi-Fi leg:
+//    {"lost": "214", "loss_percent": "6.79", "sequence_errors": "97", "jitter_ms": "38.120",
+//     "max_delta_ms": "412.554", "r_factor": "70.2", "mos": "3.61"}
+
+package decode_rtp
+
+import (
+	database "DeepPacketAI/internal/storage" // Stream records are stored like messages
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sequence number limits of RFC 3550 appendix A.1
+const (
+	maxDropout  = 3000 // Larger jumps forward restart the sequence
+	maxMisorder = 100  // Larger jumps back restart the sequence
+)
+
+// pauseGap is a silence between packets taken as a pause of the sender, e.g. on
+// hold, rather than as network delay; the jitter calculation starts over after it
+const pauseGap = time.Second
+
+// streamTimeout ends a stream that sent nothing for this long
+// A stream resuming afterwards, e.g. after a long hold, is stored as a new one
+const streamTimeout = 30 * time.Second
+
+// expireInterval is how often idle streams are looked for, in capture time
+const expireInterval = time.Second
+
+// stream is the state of one RTP stream
+type stream struct {
+	ssrc             uint32
+	src, dst         string // IP addresses
+	srcPort, dstPort uint16
+	session          Session
+	first, last      time.Time
+	firstFrame       uint64
+	lastFrame        uint64
+	packets          int
+	bytes            int // Payload bytes
+
+	// Sequence numbers, extended with the wrap-around count
+	baseSeq       int64
+	maxSeq        int64
+	received      []uint64 // Bit per sequence number from baseSeq
+	unique        int64    // Distinct sequence numbers since baseSeq
+	expectedDone  int64    // Expected packets before the last restart
+	uniqueDone    int64    // Distinct packets before the last restart
+	duplicates    int
+	outOfOrder    int
+	sequenceError int // Gaps, late packets, duplicates and restarts
+
+	// Timing in RTP timestamp units of the current payload type
+	clock         float64 // Clock rate, 0 when unknown
+	lastArrival   float64 // Arrival of the previous packet, seconds since first
+	lastTimestamp uint32
+	timed         bool // lastArrival and lastTimestamp are set
+	jitter        float64
+	maxJitter     float64
+	maxDelta      time.Duration
+	maxDeltaFrame uint64
+
+	// Latest reception report about the stream, see Received
+	reports        int
+	remoteFraction float64 // Loss since the previous report, percent
+	remoteLost     int64   // Cumulative
+	remoteJitterMs float64 // -1 when the clock rate is unknown
+	roundTrips     int
+	roundTripSum   float64
+	maxRoundTrip   float64
+
+	// Payload types in the order they were first used
+	payloadTypes []uint8
+	perType      map[uint8]int
+	lastType     uint8
+	typeChanges  int
+}
+
+// streams holds the RTP streams of the current capture
+// Replaced by Reset before each capture
+var streams = make(map[string]*stream)
+
+// lastExpire is the capture time idle streams were last looked for
+var lastExpire time.Time
+
+// Reset discards the streams of the previous capture
+// Called before a new set of captures is decoded
+func Reset() {
+	streams = make(map[string]*stream)
+	senderReports = make(map[uint64]time.Time)
+	lastExpire = time.Time{}
+}
+
+// Flush stores the streams still open when the capture ends, in the order they started
+func Flush() {
+	open := make([]*stream, 0, len(streams))
+	for _, s := range streams {
+		open = append(open, s)
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].firstFrame < open[j].firstFrame })
+	for _, s := range open {
+		s.store()
+	}
+	streams = make(map[string]*stream)
+}
+
+// find returns the stream of a packet, starting a new one when needed
+// Streams idle for streamTimeout are stored first
+func find(ssrc uint32, src string, dst string, srcPort uint16, dstPort uint16, seen time.Time, frame uint64, session Session) *stream {
+	expire(seen)
+	key := fmt.Sprintf("%08x %s %s", ssrc, address(src, srcPort), address(dst, dstPort))
+	s, ok := streams[key]
+	if !ok {
+		s = &stream{
+			ssrc:       ssrc,
+			src:        src,
+			dst:        dst,
+			srcPort:    srcPort,
+			dstPort:    dstPort,
+			session:    session,
+			first:      seen,
+			firstFrame: frame,
+			perType:    make(map[uint8]int),
+		}
+		streams[key] = s
+	}
+	if s.session.CallID == "" && session.CallID != "" {
+		s.session = session // SDP seen after the first packets, e.g. early media
+	}
+	return s
+}
+
+// expire stores the streams that sent nothing for streamTimeout before now
+// Runs at most once per expireInterval, not for every packet
+func expire(now time.Time) {
+	if since := now.Sub(lastExpire); since >= 0 && since < expireInterval {
+		return
+	}
+	lastExpire = now
+
+	var idle []string
+	for key, s := range streams {
+		if now.Sub(s.last) > streamTimeout {
+			idle = append(idle, key)
+		}
+	}
+	sort.Strings(idle)
+	for _, key := range idle {
+		streams[key].store()
+		delete(streams, key)
+	}
+}
+
+// add updates the statistics with one packet
+func (s *stream) add(h header, seen time.Time, frame uint64) {
+	s.packets++
+	s.bytes += len(h.payload)
+	if s.packets > 1 {
+		delta := seen.Sub(s.last)
+		if delta > s.maxDelta {
+			s.maxDelta, s.maxDeltaFrame = delta, frame
+		}
+		if delta > pauseGap || h.marker {
+			s.timed = false // Start of a talkspurt, the timestamps jump
+		}
+	}
+	s.last, s.lastFrame = seen, frame
+
+	s.sequence(h.sequenceNumber)
+	s.payloadType(h.payloadType)
+	s.timing(h.payloadType, h.timestamp, seen)
+}
+
+// sequence counts a sequence number (RFC 3550 appendix A.1)
+func (s *stream) sequence(seq uint16) {
+	if s.packets == 1 {
+		s.restart(int64(seq))
+		return
+	}
+	delta := int64(int16(seq - uint16(s.maxSeq))) // Signed distance, across the wrap-around
+	extended := s.maxSeq + delta
+	switch {
+	case delta == 1:
+	case delta > 1 && delta <= maxDropout:
+		s.sequenceError++ // Gap, the missing packets count as lost
+	case delta <= 0 && delta >= -maxMisorder:
+		s.sequenceError++
+		if extended < s.baseSeq {
+			s.rebase(extended) // Overtaken by the first packets of the stream
+		} else if s.has(extended) {
+			s.duplicates++
+			return
+		}
+		s.outOfOrder++
+	default:
+		// The sender restarted its sequence, e.g. after a re-INVITE
+		s.sequenceError++
+		s.expectedDone += s.maxSeq - s.baseSeq + 1
+		s.uniqueDone += s.unique
+		s.restart(int64(seq))
+		return
+	}
+	if extended > s.maxSeq {
+		s.maxSeq = extended
+	}
+	s.mark(extended)
+}
+
+// restart counts sequence numbers from seq
+func (s *stream) restart(seq int64) {
+	s.baseSeq, s.maxSeq = seq, seq
+	s.received = s.received[:0]
+	s.unique = 0
+	s.mark(seq)
+}
+
+// rebase moves baseSeq back to an earlier sequence number
+// Only happens early in a stream, within maxMisorder of its first packet
+func (s *stream) rebase(seq int64) {
+	base, received := s.baseSeq, s.received
+	s.baseSeq, s.received, s.unique = seq, nil, 0
+	for i := int64(0); i < int64(len(received))*64; i++ {
+		if received[i/64]&(1<<(i%64)) != 0 {
+			s.mark(base + i)
+		}
+	}
+}
+
+// has reports whether a sequence number since baseSeq was received
+func (s *stream) has(seq int64) bool {
+	i := seq - s.baseSeq
+	return int(i/64) < len(s.received) && s.received[i/64]&(1<<(i%64)) != 0
+}
+
+// mark notes a received sequence number since baseSeq
+func (s *stream) mark(seq int64) {
+	i := seq - s.baseSeq
+	for int(i/64) >= len(s.received) {
+		s.received = append(s.received, 0)
+	}
+	s.received[i/64] |= 1 << (i % 64)
+	s.unique++
+}
+
+// payloadType notes the payload types and the switches between them
+func (s *stream) payloadType(pt uint8) {
+	if s.perType[pt] == 0 {
+		s.payloadTypes = append(s.payloadTypes, pt)
+	}
+	s.perType[pt]++
+	if s.packets > 1 && pt != s.lastType {
+		s.typeChanges++
+	}
+	s.lastType = pt
+}
+
+// timing updates the interarrival jitter (RFC 3550 appendix A.8)
+// Telephone events and comfort noise keep their timestamp over several packets
+// and are left out, a switch of payload type starts over with its clock rate
+func (s *stream) timing(pt uint8, timestamp uint32, seen time.Time) {
+	codec := s.codecName(pt)
+	switch encoding(codec) {
+	case "telephone-event", "cn":
+		return
+	}
+	if clock := clockRate(codec); clock != s.clock {
+		s.clock, s.timed = clock, false
+	}
+	if s.clock == 0 {
+		return
+	}
+
+	arrival := seen.Sub(s.first).Seconds()
+	if s.timed {
+		// Difference of the relative transit times, in timestamp units
+		d := (arrival-s.lastArrival)*s.clock - float64(int32(timestamp-s.lastTimestamp))
+		s.jitter += (math.Abs(d) - s.jitter) / 16
+		if jitter := s.jitterMs(); jitter > s.maxJitter {
+			s.maxJitter = jitter
+		}
+	}
+	s.lastArrival, s.lastTimestamp, s.timed = arrival, timestamp, true
+}
+
+// jitterMs returns the current jitter in milliseconds
+func (s *stream) jitterMs() float64 {
+	if s.clock == 0 {
+		return 0
+	}
+	return s.jitter / s.clock * 1000
+}
+
+// counts returns the expected, lost and loss percentage of the stream
+// Duplicates do not make up for lost packets
+func (s *stream) counts() (expected int64, lost int64, percent float64) {
+	expected = s.expectedDone + s.maxSeq - s.baseSeq + 1
+	lost = expected - s.uniqueDone - s.unique
+	if lost < 0 {
+		lost = 0
+	}
+	if expected > 0 {
+		percent = float64(lost) / float64(expected) * 100
+	}
+	return expected, lost, percent
+}
+
+// mainType returns the payload type carried by most packets
+func (s *stream) mainType() uint8 {
+	best := s.payloadTypes[0]
+	for _, pt := range s.payloadTypes {
+		if s.perType[pt] > s.perType[best] {
+			best = pt
+		}
+	}
+	return best
+}
+
+// store writes the stream record from the sender to the receiver
+func (s *stream) store() {
+	expected, lost, percent := s.counts()
+	codec := s.codecName(s.mainType())
+
+	var types []string
+	for _, pt := range s.payloadTypes {
+		types = append(types, fmt.Sprintf("%d %s", pt, s.codecName(pt)))
+	}
+	record := map[string]string{
+		"ssrc":            fmt.Sprintf("0x%08x", s.ssrc),
+		"source":          address(s.src, s.srcPort),
+		"destination":     address(s.dst, s.dstPort),
+		"codec":           codec,
+		"payload_types":   strings.Join(types, ", "),
+		"packets":         strconv.Itoa(s.packets),
+		"payload_bytes":   strconv.Itoa(s.bytes),
+		"expected":        strconv.FormatInt(expected, 10),
+		"lost":            strconv.FormatInt(lost, 10),
+		"loss_percent":    strconv.FormatFloat(percent, 'f', 2, 64),
+		"sequence_errors": strconv.Itoa(s.sequenceError),
+		"max_delta_ms":    milliseconds(s.maxDelta),
+		"start_time":      s.first.Format(time.RFC3339Nano),
+		"first_frame":     strconv.FormatUint(s.firstFrame, 10),
+		"duration_s":      strconv.FormatFloat(s.last.Sub(s.first).Seconds(), 'f', 3, 64),
+	}
+	if s.session.CallID != "" {
+		record["Call-ID"] = s.session.CallID // Header name, so the record joins the call
+	}
+	if s.session.Media != "" {
+		record["media"] = s.session.Media
+	}
+	if s.duplicates > 0 {
+		record["duplicates"] = strconv.Itoa(s.duplicates)
+	}
+	if s.outOfOrder > 0 {
+		record["out_of_order"] = strconv.Itoa(s.outOfOrder)
+	}
+	if s.typeChanges > 0 {
+		record["payload_type_changes"] = strconv.Itoa(s.typeChanges)
+	}
+	if s.maxDelta > 0 {
+		record["max_delta_frame"] = strconv.FormatUint(s.maxDeltaFrame, 10)
+	}
+	if s.clock > 0 {
+		record["jitter_ms"] = strconv.FormatFloat(s.jitterMs(), 'f', 3, 64)
+		record["max_jitter_ms"] = strconv.FormatFloat(s.maxJitter, 'f', 3, 64)
+	}
+	roundTrip := 0.0
+	if s.reports > 0 {
+		record["rtcp_reports"] = strconv.Itoa(s.reports)
+		record["remote_loss_percent"] = strconv.FormatFloat(s.remoteFraction, 'f', 2, 64)
+		record["remote_lost"] = strconv.FormatInt(s.remoteLost, 10)
+		if s.remoteJitterMs >= 0 {
+			record["remote_jitter_ms"] = strconv.FormatFloat(s.remoteJitterMs, 'f', 3, 64)
+		}
+	}
+	if s.roundTrips > 0 {
+		roundTrip = s.roundTripSum / float64(s.roundTrips)
+		record["rtt_ms"] = strconv.FormatFloat(roundTrip, 'f', 3, 64)
+		record["max_rtt_ms"] = strconv.FormatFloat(s.maxRoundTrip, 'f', 3, 64)
+	}
+	if s.session.Media == "" || s.session.Media == "audio" {
+		r := rFactor(codec, s.jitterMs(), percent, roundTrip/2)
+		record["r_factor"] = strconv.FormatFloat(r, 'f', 1, 64)
+		record["mos"] = strconv.FormatFloat(mos(r), 'f', 2, 64)
+	}
+
+	database.Insert(
+		s.src,        // Address of the sender
+		s.dst,        // Address of the receiver
+		"rtp_stream", // Protocol identifier of stream records
+		s.last.Format(time.RFC3339),
+		s.lastFrame, // Last packet of the stream
+		record,
+	)
+}
+
+// address formats an IP address and port, e.g. "192.168.56.9:19190" or "[2001:db8::1]:5004"
+func address(ip string, port uint16) string {
+	return net.JoinHostPort(ip, strconv.Itoa(int(port)))
+}
+
+// milliseconds formats a duration like the SIP call timings, e.g. "20.931"
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
@@ -0,0 +1,118 @@
+package decode_rtp
+
+import (
+	database "DeepPacketAI/internal/storage"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// arrival is a packet of a test stream, sent 20 ms after the previous sequence number
+type arrival struct {
+	seq uint16
+	at  int // Milliseconds after the first packet
+}
+
+// steady returns packets from seq on, arriving every 20 ms
+func steady(seq uint16, count int, at int) []arrival {
+	packets := make([]arrival, count)
+	for i := range packets {
+		packets[i] = arrival{seq + uint16(i), at + 20*i}
+	}
+	return packets
+}
+
+// TestStreamStatistics sends PCMU streams of SSRC 7 and checks the stored stream record
+func TestStreamStatistics(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets []arrival
+		want    string // expected, lost, duplicates, out of order and sequence errors
+		jitter  string // jitter_ms, not checked when empty
+		mos     string // mos, not checked when empty
+	}{
+		{
+			name:    "no loss",
+			packets: steady(100, 50, 0),
+			want:    "expected 50 lost 0 duplicates  out_of_order  sequence_errors 0",
+			jitter:  "0.000", mos: "4.39",
+		},
+		{
+			name:    "gap",
+			packets: append(steady(100, 3, 0), steady(105, 5, 100)...),
+			want:    "expected 10 lost 2 duplicates  out_of_order  sequence_errors 1",
+			jitter:  "0.000", mos: "2.58",
+		},
+		{
+			name:    "second packet overtaken by the third",
+			packets: []arrival{{101, 0}, {100, 1}, {102, 20}, {103, 40}},
+			want:    "expected 4 lost 0 duplicates  out_of_order 1 sequence_errors 1",
+		},
+		{
+			name:    "reordered mid-stream",
+			packets: []arrival{{100, 0}, {101, 20}, {103, 40}, {102, 41}, {104, 80}},
+			want:    "expected 5 lost 0 duplicates  out_of_order 1 sequence_errors 2", // The gap and the late packet
+		},
+		{
+			name:    "duplicate",
+			packets: []arrival{{100, 0}, {101, 20}, {101, 21}, {102, 40}},
+			want:    "expected 3 lost 0 duplicates 1 out_of_order  sequence_errors 1",
+		},
+		{
+			name:    "wrap-around",
+			packets: steady(65534, 4, 0),
+			want:    "expected 4 lost 0 duplicates  out_of_order  sequence_errors 0",
+			jitter:  "0.000",
+		},
+		{
+			name:    "loss across the wrap-around",
+			packets: []arrival{{65534, 0}, {0, 40}, {1, 60}},
+			want:    "expected 4 lost 1 duplicates  out_of_order  sequence_errors 1",
+		},
+		{
+			name:    "restart",
+			packets: append(steady(100, 3, 0), steady(40000, 3, 60)...),
+			want:    "expected 6 lost 0 duplicates  out_of_order  sequence_errors 1",
+		},
+		{
+			// 102 arrives 15 ms late: transit differences of +15, -15 and 0 ms (RFC 3550 A.8)
+			name:    "one late packet",
+			packets: []arrival{{100, 0}, {101, 20}, {102, 55}, {103, 60}, {104, 80}},
+			want:    "expected 5 lost 0 duplicates  out_of_order  sequence_errors 0",
+			jitter:  "1.703",
+		},
+	}
+	for _, tt := range tests {
+		Reset()
+		database.Take()
+		start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+		for i, a := range tt.packets {
+			p := make([]byte, 12+160)
+			p[0] = 0x80 // Version 2, PCMU
+			binary.BigEndian.PutUint16(p[2:4], a.seq)
+			binary.BigEndian.PutUint32(p[4:8], 160*uint32(a.seq-tt.packets[0].seq))
+			binary.BigEndian.PutUint32(p[8:12], 7)
+			Process(p, "10.0.0.1", "10.0.0.2", 41000, 52000, start.Add(time.Duration(a.at)*time.Millisecond), uint64(i+1), Session{})
+		}
+		Flush()
+
+		records := database.Take()
+		if len(records) != 1 {
+			t.Errorf("%s: %d records, want 1", tt.name, len(records))
+			continue
+		}
+		r := records[0].Message
+		got := fmt.Sprintf("expected %s lost %s duplicates %s out_of_order %s sequence_errors %s",
+			r["expected"], r["lost"], r["duplicates"], r["out_of_order"], r["sequence_errors"])
+		if got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+		if tt.jitter != "" && r["jitter_ms"] != tt.jitter {
+			t.Errorf("%s: jitter_ms = %s, want %s", tt.name, r["jitter_ms"], tt.jitter)
+		}
+		if tt.mos != "" && r["mos"] != tt.mos {
+			t.Errorf("%s: mos = %s, want %s", tt.name, r["mos"], tt.mos)
+		}
+	}
+}
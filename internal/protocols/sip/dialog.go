@@ -0,0 +1,460 @@
+// dialog.go
+// This file follows SIP calls from the first INVITE to the end of the dialog.
+// Core functionalities:
+// - Groups the messages of a call by Call-ID and the From and To tags
+// - Follows each early dialog of a forked INVITE and rolls them up into the call
+// - Follows the INVITE dialog: 1xx, 2xx, ACK, BYE, CANCEL, re-INVITE and failures
+// - Measures post-dial delay, setup time and call duration
+// - Stores one "sip_call" record per call with its disposition and message flow
+//
+// Example scenarios:
+// 1. Answered call:
+//    {"Call-ID": "a84b4c76e66710", "disposition": "answered", "post_dial_delay_ms": "412.006",
+//     "setup_time_ms": "5210.337", "duration_s": "63.200", "released_by": "callee",
+//     "flow": "INVITE, 100, 180, 200, ACK, BYE, 200"}
+//
+// 2. Busy callee after authentication:
+//    {"disposition": "busy", "final_response": "486 Busy Here", "attempts": "2",
+//     "flow": "INVITE, 401, ACK, INVITE, 100, 486"}
+//
+// 3. Caller hangs up while ringing:
+//    {"disposition": "cancelled", "flow": "INVITE, 100, 180, CANCEL, 200, 487"}
+//
+// 4. Forked INVITE ringing two phones, the desk phone answers:
+//    {"disposition": "answered", "tags": "a1 / desk", "dialogs": "desk terminated, mobile terminated",
+//     "flow": "INVITE, 100, 180, 180, 200, ACK, 487, BYE, 200"}
+
+package decode_sip
+
+import (
+	database "DeepPacketAI/internal/storage" // Call records are stored like messages
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialog states of a call (RFC 3261 sections 12 and 13)
+const (
+	stateCalling    = "calling"    // INVITE sent, no response yet
+	stateProceeding = "proceeding" // 100 Trying received
+	stateEarly      = "early"      // Provisional response with a To tag, e.g. 180 Ringing
+	stateChallenged = "challenged" // 401/407 received, the caller may retry with credentials
+	stateConfirmed  = "confirmed"  // 2xx received, the call is up
+	stateTerminated = "terminated" // Failed, cancelled or released
+)
+
+// callLinger keeps an ended call to absorb its late ACK and retransmissions
+// 32 seconds is Timer B/Timer H of RFC 3261 with the default T1
+const callLinger = 32 * time.Second
+
+// dialog is one dialog of a call, created by a response to the INVITE with a
+// To tag; a forked INVITE creates one per device that responded
+type dialog struct {
+	tag   string // To tag of the callee
+	state string // stateEarly, stateConfirmed or stateTerminated
+}
+
+// call is the state of one INVITE and the dialogs it created
+type call struct {
+	callID      string
+	from, to    string    // From and To headers of the first INVITE
+	callerTag   string    // From tag of the caller
+	calleeTag   string    // To tag of the answer
+	dialogs     []*dialog // In the order of their first response
+	caller      string    // Address sending the INVITE
+	callee      string    // Address receiving the INVITE
+	inviteCSeq  string    // CSeq number of the latest initial INVITE
+	state       string
+	attempts    int // Initial INVITEs, more than one after an authentication challenge
+	reinvites   int
+	messages    int
+	flow        []string
+	invited     time.Time // First INVITE
+	progressed  time.Time // First provisional response other than 100
+	answered    time.Time // 2xx to the INVITE
+	released    time.Time // BYE or CANCEL
+	lastSeen    time.Time
+	lastFrame   uint64
+	inviteFrame uint64
+	answerFrame uint64
+	finalCode   int // Final response to the initial INVITE
+	finalReason string
+	cancelled   bool
+	releasedBy  string // "caller" or "callee"
+	ended       bool   // Final state reached, the summary record is due
+	stored      bool   // Summary record written
+}
+
+// calls holds the calls of the current capture by Call-ID and caller tag, see callKey
+// Replaced by Reset before each capture
+var calls = make(map[string]*call)
+
+// Reset discards the calls and media endpoints of the previous capture
+// Called before a new set of captures is decoded
+func Reset() {
+	calls = make(map[string]*call)
+	media = make(map[string]Media)
+}
+
+// Flush stores the calls still open when the capture ends
+// Calls without a final state are stored with the state they reached, in the
+// order they started
+func Flush() {
+	var open []*call
+	for _, c := range calls {
+		if !c.stored {
+			open = append(open, c)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].inviteFrame < open[j].inviteFrame })
+	for _, c := range open {
+		c.store()
+	}
+	calls = make(map[string]*call)
+}
+
+// track adds a decoded message to its call and notes the dialog state on the message
+// Only dialogs created by INVITE are followed; REGISTER, OPTIONS and other
+// transactions are left as single messages
+// Parameters:
+//   - message: Decoded SIP message
+//   - src_ipaddr: Source IP address
+//   - dst_ipaddr: Destination IP address
+//   - seen: Capture timestamp
+//   - frame_num: Frame carrying the message
+//
+// Returns: The call when this message ended it, so its record follows the message
+func track(message map[string]string, src_ipaddr string, dst_ipaddr string, seen time.Time, frame_num uint64) *call {
+	callID := message["Call-ID"]
+	number, method, _ := strings.Cut(message["CSeq"], " ")
+	status := message["Status"]
+	if callID == "" || method == "" || status == "" {
+		return nil
+	}
+	code := responseCode(status)
+	request := code == 0
+	if request {
+		method, _, _ = strings.Cut(status, " ") // Request line method
+	}
+
+	fromTag, toTag := tag(message["From"]), tag(message["To"])
+	c, key, dialogTag := lookup(callID, fromTag, toTag)
+	switch {
+	case c == nil && request && method == "INVITE" && toTag == "":
+		c = &call{
+			callID:      callID,
+			from:        message["From"],
+			to:          message["To"],
+			callerTag:   fromTag,
+			caller:      src_ipaddr,
+			callee:      dst_ipaddr,
+			invited:     seen,
+			inviteFrame: frame_num,
+		}
+		calls[key] = c
+		expire(seen)
+	case c == nil:
+		return nil // Not part of a known INVITE dialog
+	case c.stored && request && method == "INVITE" && toTag == "" && number != c.inviteCSeq:
+		// A new call reusing the Call-ID of an ended one, e.g. after a redirect
+		delete(calls, key)
+		return track(message, src_ipaddr, dst_ipaddr, seen, frame_num)
+	}
+
+	c.messages++
+	c.lastSeen, c.lastFrame = seen, frame_num
+	if request {
+		c.flow = append(c.flow, method)
+		c.request(method, number, dialogTag, fromTag == c.callerTag, seen)
+	} else {
+		c.flow = append(c.flow, strconv.Itoa(code))
+		if method == "INVITE" {
+			c.inviteResponse(code, status, number, dialogTag, seen, frame_num)
+		} else if method == "BYE" && code >= 200 && c.state == stateTerminated {
+			c.ended = true // BYE answered, the dialog is over
+		}
+	}
+	message["dialog_state"] = c.state
+	if c.ended && !c.stored {
+		return c
+	}
+	return nil
+}
+
+// lookup returns the call of a message, its key in calls and the callee tag of its dialog
+// Requests of the caller and all responses carry the caller tag in From,
+// requests of the callee (e.g. BYE) carry it in To
+// Returns a nil call and the key of a new call when none is known
+func lookup(callID string, fromTag string, toTag string) (*call, string, string) {
+	if c, ok := calls[callKey(callID, fromTag)]; ok {
+		return c, callKey(callID, fromTag), toTag
+	}
+	if c, ok := calls[callKey(callID, toTag)]; ok && toTag != "" {
+		return c, callKey(callID, toTag), fromTag
+	}
+	return nil, callKey(callID, fromTag), toTag
+}
+
+// callKey identifies a call by its Call-ID and the From tag of the caller
+func callKey(callID string, callerTag string) string {
+	return callID + ";" + callerTag
+}
+
+// dialog returns the dialog of a callee tag, adding it on its first response
+func (c *call) dialog(calleeTag string) *dialog {
+	for _, d := range c.dialogs {
+		if d.tag == calleeTag {
+			return d
+		}
+	}
+	d := &dialog{tag: calleeTag}
+	c.dialogs = append(c.dialogs, d)
+	return d
+}
+
+// endDialogs terminates the dialogs of a call that failed or was released
+func (c *call) endDialogs() {
+	for _, d := range c.dialogs {
+		d.state = stateTerminated
+	}
+}
+
+// request follows a request within a call
+// Parameters:
+//   - method: Request method
+//   - number: CSeq number
+//   - dialogTag: Callee tag of the dialog, empty outside a dialog
+//   - fromCaller: The caller sent the request
+//   - seen: Capture timestamp
+func (c *call) request(method string, number string, dialogTag string, fromCaller bool, seen time.Time) {
+	switch method {
+	case "INVITE":
+		switch {
+		case dialogTag != "":
+			if number != c.inviteCSeq && c.state == stateConfirmed {
+				c.reinvites++ // Hold, resume or codec change in the dialog
+			}
+		case number != c.inviteCSeq:
+			// Initial INVITE, again with credentials after a challenge
+			c.attempts++
+			c.inviteCSeq = number
+			c.state = stateCalling
+			c.finalCode, c.finalReason = 0, ""
+		}
+	case "CANCEL":
+		if c.state != stateConfirmed && c.state != stateTerminated {
+			c.cancelled = true
+			c.released = seen
+			c.releasedBy = "caller"
+		}
+	case "BYE":
+		if dialogTag != "" && dialogTag != c.calleeTag && c.calleeTag != "" {
+			// A second phone answered the forked INVITE, the caller hangs it up
+			c.dialog(dialogTag).state = stateTerminated
+			return
+		}
+		if c.state == stateTerminated {
+			return // Retransmission
+		}
+		c.released = seen
+		c.releasedBy = "callee"
+		if fromCaller {
+			c.releasedBy = "caller"
+		}
+		c.state = stateTerminated
+		c.endDialogs()
+	}
+}
+
+// inviteResponse follows a response to an initial INVITE or a re-INVITE
+// Provisional and 2xx responses with a To tag create or confirm the dialog of
+// the responding phone, the first 2xx answers the call; a failure response ends
+// the early dialog of its phone, e.g. 487 to the fork cancelled after the answer
+func (c *call) inviteResponse(code int, status string, number string, dialogTag string, seen time.Time, frame_num uint64) {
+	if number != c.inviteCSeq {
+		return // Re-INVITE transaction, the dialog state stays
+	}
+	switch {
+	case dialogTag == "" || code == 100:
+	case code >= 300:
+		for _, d := range c.dialogs {
+			if d.tag == dialogTag && d.state == stateEarly {
+				d.state = stateTerminated
+			}
+		}
+	case code >= 200:
+		if d := c.dialog(dialogTag); d.state != stateTerminated {
+			d.state = stateConfirmed
+		}
+	default:
+		if d := c.dialog(dialogTag); d.state == "" {
+			d.state = stateEarly
+		}
+	}
+	if c.state == stateConfirmed || c.state == stateTerminated {
+		return // Retransmission, or another fork answering too late
+	}
+	switch {
+	case code < 200:
+		if code == 100 {
+			if c.state == stateCalling {
+				c.state = stateProceeding
+			}
+			return
+		}
+		if c.progressed.IsZero() {
+			c.progressed = seen // Ringing or session progress
+		}
+		c.state = stateEarly
+	case code < 300:
+		c.state = stateConfirmed
+		c.answered = seen
+		c.answerFrame = frame_num
+		c.calleeTag = dialogTag
+	default:
+		c.finalCode = code
+		c.finalReason = reasonPhrase(status)
+		if code == 401 || code == 407 {
+			c.state = stateChallenged // The caller may try again with credentials
+			return
+		}
+		c.state = stateTerminated
+		c.ended = true
+		c.endDialogs()
+	}
+}
+
+// disposition returns the outcome of a call
+func (c *call) disposition() string {
+	switch {
+	case !c.answered.IsZero():
+		return "answered"
+	case c.cancelled || c.finalCode == 487:
+		return "cancelled"
+	case c.finalCode == 486 || c.finalCode == 600:
+		return "busy"
+	case c.finalCode == 408 || c.finalCode == 480:
+		return "no answer"
+	case c.finalCode >= 300 && c.finalCode < 400:
+		return "redirected"
+	case c.finalCode == 401 || c.finalCode == 407:
+		return "authentication failed"
+	case c.finalCode >= 400 && c.finalCode < 500, c.finalCode == 603:
+		return "rejected"
+	case c.finalCode >= 500:
+		return "failed"
+	case c.state == stateEarly:
+		return "ringing when the capture ended"
+	}
+	return "no final response"
+}
+
+// store writes the call summary record from the caller to the callee
+func (c *call) store() {
+	c.stored = true
+	record := map[string]string{
+		"Call-ID":      c.callID, // Header name, so the record joins its messages
+		"from":         c.from,
+		"to":           c.to,
+		"state":        c.state,
+		"disposition":  c.disposition(),
+		"messages":     strconv.Itoa(c.messages),
+		"flow":         strings.Join(c.flow, ", "),
+		"invite_time":  c.invited.Format(time.RFC3339Nano),
+		"invite_frame": strconv.FormatUint(c.inviteFrame, 10),
+	}
+	if c.finalCode != 0 {
+		record["final_response"] = strings.TrimSpace(fmt.Sprintf("%d %s", c.finalCode, c.finalReason))
+	}
+	if c.attempts > 1 {
+		record["attempts"] = strconv.Itoa(c.attempts)
+	}
+	if c.reinvites > 0 {
+		record["reinvites"] = strconv.Itoa(c.reinvites)
+	}
+	if c.calleeTag != "" {
+		record["tags"] = c.callerTag + " / " + c.calleeTag
+	}
+	if len(c.dialogs) > 1 {
+		// Forked INVITE, e.g. "desk confirmed, mobile terminated"
+		var dialogs []string
+		for _, d := range c.dialogs {
+			dialogs = append(dialogs, d.tag+" "+d.state)
+		}
+		record["dialogs"] = strings.Join(dialogs, ", ")
+	}
+
+	// Post-dial delay: INVITE to ringing (RFC 6076 section 4.2)
+	if !c.progressed.IsZero() {
+		record["post_dial_delay_ms"] = milliseconds(c.progressed.Sub(c.invited))
+	}
+	if !c.answered.IsZero() {
+		record["setup_time_ms"] = milliseconds(c.answered.Sub(c.invited))
+		record["answer_frame"] = strconv.FormatUint(c.answerFrame, 10)
+		if !c.released.IsZero() {
+			record["duration_s"] = strconv.FormatFloat(c.released.Sub(c.answered).Seconds(), 'f', 3, 64)
+		}
+	}
+	if c.releasedBy != "" {
+		record["released_by"] = c.releasedBy
+	}
+
+	database.Insert(
+		c.caller,   // Address of the caller
+		c.callee,   // Address the INVITE was sent to
+		"sip_call", // Protocol identifier of call records
+		c.lastSeen.Format(time.RFC3339),
+		c.lastFrame, // Last message of the call
+		record,
+	)
+}
+
+// expire drops calls stored more than callLinger before now
+func expire(now time.Time) {
+	for id, c := range calls {
+		if c.stored && now.Sub(c.lastSeen) > callLinger {
+			delete(calls, id)
+		}
+	}
+}
+
+// responseCode returns the status code of a status line, 0 for requests
+// Example: "SIP/2.0 486 Busy Here" gives 486
+func responseCode(status string) int {
+	rest, found := strings.CutPrefix(status, "SIP/2.0 ")
+	if !found {
+		return 0
+	}
+	code, _, _ := strings.Cut(rest, " ")
+	n, _ := strconv.Atoi(code)
+	return n
+}
+
+// reasonPhrase returns the reason of a status line
+// Example: "SIP/2.0 486 Busy Here" gives "Busy Here"
+func reasonPhrase(status string) string {
+	parts := strings.SplitN(status, " ", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return strings.TrimSpace(parts[2])
+}
+
+// tag returns the tag parameter of a From or To header
+// Example: "<sip:bob@example.com>;tag=a6c85cf" gives "a6c85cf"
+func tag(header string) string {
+	for _, param := range strings.Split(header, ";")[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(name, "tag") {
+			return value
+		}
+	}
+	return ""
+}
+
+// milliseconds formats a duration like the HTTP latency, e.g. "412.006"
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
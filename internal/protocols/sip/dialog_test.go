@@ -0,0 +1,147 @@
+package decode_sip
+
+import (
+	database "DeepPacketAI/internal/storage"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Addresses of the test calls
+const (
+	alice = "<sip:alice@example.com>;tag=a1"
+	proxy = "10.0.0.2"
+)
+
+// bob returns the To header of the callee, with the tag of the responding phone
+func bob(tag string) string {
+	if tag == "" {
+		return "<sip:bob@example.com>"
+	}
+	return "<sip:bob@example.com>;tag=" + tag
+}
+
+// step is one message of a test call between the caller at 10.0.0.1 and the proxy
+type step struct {
+	start    string // Request or status line
+	from, to string
+	cseq     string
+}
+
+// sipText builds a SIP message of the test call
+func (s step) sipText() []byte {
+	return fmt.Appendf(nil, "%s\r\nVia: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds\r\n"+
+		"From: %s\r\nTo: %s\r\nCall-ID: a84b4c76e66710\r\nCSeq: %s\r\nContent-Length: 0\r\n\r\n",
+		s.start, s.from, s.to, s.cseq)
+}
+
+// TestForkedCalls checks the call records of forked and cancelled INVITEs
+func TestForkedCalls(t *testing.T) {
+	const (
+		invite = "INVITE sip:bob@example.com SIP/2.0"
+		ack    = "ACK sip:bob@example.com SIP/2.0"
+		cancel = "CANCEL sip:bob@example.com SIP/2.0"
+	)
+	tests := []struct {
+		name  string
+		steps []step
+		want  map[string]string
+	}{
+		{
+			name: "forked INVITE answered by one phone",
+			steps: []step{
+				{invite, alice, bob(""), "1 INVITE"},
+				{"SIP/2.0 100 Trying", alice, bob(""), "1 INVITE"},
+				{"SIP/2.0 180 Ringing", alice, bob("desk"), "1 INVITE"},
+				{"SIP/2.0 180 Ringing", alice, bob("mobile"), "1 INVITE"},
+				{"SIP/2.0 200 OK", alice, bob("desk"), "1 INVITE"},
+				{ack, alice, bob("desk"), "1 ACK"},
+				{"SIP/2.0 487 Request Terminated", alice, bob("mobile"), "1 INVITE"}, // Proxy cancelled the other phone
+				{"BYE sip:alice@example.com SIP/2.0", bob("desk"), alice, "1 BYE"},
+				{"SIP/2.0 200 OK", bob("desk"), alice, "1 BYE"},
+			},
+			want: map[string]string{
+				"disposition": "answered",
+				"state":       "terminated",
+				"released_by": "callee",
+				"tags":        "a1 / desk",
+				"dialogs":     "desk terminated, mobile terminated",
+				"flow":        "INVITE, 100, 180, 180, 200, ACK, 487, BYE, 200",
+			},
+		},
+		{
+			name: "forked INVITE answered by both phones",
+			steps: []step{
+				{invite, alice, bob(""), "1 INVITE"},
+				{"SIP/2.0 180 Ringing", alice, bob("desk"), "1 INVITE"},
+				{"SIP/2.0 180 Ringing", alice, bob("mobile"), "1 INVITE"},
+				{"SIP/2.0 200 OK", alice, bob("desk"), "1 INVITE"},
+				{"SIP/2.0 200 OK", alice, bob("mobile"), "1 INVITE"},
+				{ack, alice, bob("desk"), "1 ACK"},
+				{ack, alice, bob("mobile"), "1 ACK"},
+				{"BYE sip:bob@example.com SIP/2.0", alice, bob("mobile"), "2 BYE"}, // The caller keeps one call
+				{"SIP/2.0 200 OK", alice, bob("mobile"), "2 BYE"},
+			},
+			want: map[string]string{
+				"disposition": "answered",
+				"state":       "confirmed",
+				"released_by": "",
+				"tags":        "a1 / desk",
+				"dialogs":     "desk confirmed, mobile terminated",
+				"flow":        "INVITE, 180, 180, 200, 200, ACK, ACK, BYE, 200",
+			},
+		},
+		{
+			name: "cancelled while ringing",
+			steps: []step{
+				{invite, alice, bob(""), "1 INVITE"},
+				{"SIP/2.0 100 Trying", alice, bob(""), "1 INVITE"},
+				{"SIP/2.0 180 Ringing", alice, bob("b1"), "1 INVITE"},
+				{cancel, alice, bob(""), "1 CANCEL"},
+				{"SIP/2.0 200 OK", alice, bob("b1"), "1 CANCEL"},
+				{"SIP/2.0 487 Request Terminated", alice, bob("b1"), "1 INVITE"},
+				{ack, alice, bob("b1"), "1 ACK"},
+			},
+			want: map[string]string{
+				"disposition":    "cancelled",
+				"state":          "terminated",
+				"released_by":    "caller",
+				"final_response": "487 Request Terminated",
+				"tags":           "",
+				"dialogs":        "",
+				"flow":           "INVITE, 100, 180, CANCEL, 200, 487",
+			},
+		},
+	}
+	for _, tt := range tests {
+		Reset()
+		database.Take()
+		start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+		for i, s := range tt.steps {
+			// Requests of the caller and responses to the callee come from the caller
+			src, dst := "10.0.0.1", proxy
+			if strings.HasPrefix(s.start, "SIP/2.0 ") == (s.from == alice) {
+				src, dst = dst, src
+			}
+			Process(s.sipText(), src, dst, start.Add(time.Duration(i)*100*time.Millisecond), uint64(i+1))
+		}
+		Flush()
+
+		var records []map[string]string
+		for _, m := range database.Take() {
+			if m.Protocol == "sip_call" {
+				records = append(records, m.Message)
+			}
+		}
+		if len(records) != 1 {
+			t.Errorf("%s: %d call records, want 1: %v", tt.name, len(records), records)
+			continue
+		}
+		for field, want := range tt.want {
+			if got := records[0][field]; got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, field, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// media.go
+// This file learns the RTP and RTCP endpoints negotiated in SDP offers and answers.
+// Core functionalities:
+// - Reads the connection address and m= lines of session descriptions
+// - Takes the RTCP port from a=rtcp (RFC 3605), RTP port + 1 otherwise,
+//   and the RTP port itself with a=rtcp-mux (RFC 5761)
+// - Keeps the payload type names of a=rtpmap and the static payload types
+// - Answers which endpoint of a UDP packet was announced, and by which call
+//
+// Example scenarios:
+// 1. Offer from a phone:
+//    "c=IN IP4 192.168.56.56", "m=audio 30974 RTP/AVP 0 8 101" announces RTP on
+//    192.168.56.56:30974 and RTCP on 192.168.56.56:30975
+//
+// 2. WebRTC offer with a=rtcp-mux:
+//    RTP and RTCP share one port and are told apart by the packet type
+
+package decode_sip
+
+import (
+	decode_rtp "DeepPacketAI/internal/protocols/rtp" // Static RTP payload types
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Media is an RTP or RTCP endpoint announced in SDP
+type Media struct {
+	Kind   string           // "rtp" or "rtcp"
+	CallID string           // Call-ID of the SIP message carrying the SDP
+	Type   string           // Media type of the m= line, e.g. "audio" or "video"
+	Codecs map[uint8]string // RTP payload types by number, e.g. 8: "PCMA/8000"
+}
+
+// media holds the announced endpoints of the current capture by "address:port"
+// Replaced by Reset before each capture; a later offer or answer for the same
+// endpoint replaces the earlier one
+var media = make(map[string]Media)
+
+// MediaAt returns the SDP announcement of a UDP endpoint
+// Parameters:
+//   - ip: IP address of the endpoint
+//   - port: UDP port of the endpoint
+//
+// Returns the announcement and whether there was one
+func MediaAt(ip string, port uint16) (Media, bool) {
+	m, ok := media[endpoint(ip, port)]
+	return m, ok
+}
+
+// endpoint returns the key of an address and port, with the address in canonical form
+func endpoint(ip string, port uint16) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(int(port)))
+}
+
+// announcement is one m= section being read
+type announcement struct {
+	mediaType string
+	address   string // Connection address, from the session when the section has none
+	port      uint16
+	rtp       bool // RTP profile, e.g. RTP/AVP, RTP/SAVPF, UDP/TLS/RTP/SAVPF
+	codecs    map[uint8]string
+	rtcpPort  uint16 // From a=rtcp, 0 when absent
+	rtcpAddr  string // From a=rtcp, empty when absent
+	mux       bool   // a=rtcp-mux
+}
+
+// learnMedia stores the RTP and RTCP endpoints of a session description
+// Parameters:
+//   - callID: Call-ID of the SIP message
+//   - body: SIP message body, ignored unless it is SDP
+func learnMedia(callID string, body []byte) {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("v=")) {
+		return
+	}
+	var session string
+	var current *announcement
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		name, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+		switch name {
+		case "c":
+			if current == nil {
+				session = connectionAddress(value)
+			} else {
+				current.address = connectionAddress(value)
+			}
+		case "m":
+			current.store(callID)
+			current = newAnnouncement(value, session)
+		case "a":
+			current.attribute(value)
+		}
+	}
+	current.store(callID)
+}
+
+// newAnnouncement reads an m= line
+// Example: "audio 49170 RTP/AVP 0 8 97"; a port count such as "49170/2" is ignored
+func newAnnouncement(value string, session string) *announcement {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil
+	}
+	port, _, _ := strings.Cut(fields[1], "/")
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil
+	}
+	a := &announcement{
+		mediaType: fields[0],
+		address:   session,
+		port:      uint16(number),
+		rtp:       strings.Contains(fields[2], "RTP/"),
+		codecs:    make(map[uint8]string),
+	}
+	for _, format := range fields[3:] {
+		if pt, err := strconv.ParseUint(format, 10, 7); err == nil {
+			if name, static := decode_rtp.StaticPayloadTypes[uint8(pt)]; static {
+				a.codecs[uint8(pt)] = name
+			}
+		}
+	}
+	return a
+}
+
+// attribute reads the a= lines describing RTP and RTCP of an m= section
+// Examples: "rtpmap:97 opus/48000/2", "rtcp:53021 IN IP4 126.16.64.4", "rtcp-mux"
+func (a *announcement) attribute(value string) {
+	if a == nil {
+		return // Session-level attribute
+	}
+	name, rest, _ := strings.Cut(value, ":")
+	switch name {
+	case "rtpmap":
+		format, encoding, _ := strings.Cut(rest, " ")
+		if pt, err := strconv.ParseUint(format, 10, 7); err == nil && encoding != "" {
+			a.codecs[uint8(pt)] = strings.TrimSpace(encoding)
+		}
+	case "rtcp":
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return
+		}
+		if port, err := strconv.ParseUint(fields[0], 10, 16); err == nil {
+			a.rtcpPort = uint16(port)
+		}
+		if len(fields) >= 4 {
+			a.rtcpAddr = connectionAddress(strings.Join(fields[1:], " "))
+		}
+	case "rtcp-mux":
+		a.mux = true
+	}
+}
+
+// store adds the endpoints of a finished m= section
+// Rejected streams (port 0), held streams (address 0.0.0.0) and non-RTP media are skipped
+func (a *announcement) store(callID string) {
+	if a == nil || !a.rtp || a.port == 0 || a.address == "" || a.address == "0.0.0.0" {
+		return
+	}
+	media[endpoint(a.address, a.port)] = Media{Kind: "rtp", CallID: callID, Type: a.mediaType, Codecs: a.codecs}
+	if a.mux {
+		return // RTCP on the RTP port
+	}
+
+	port, address := a.port+1, a.address
+	if a.rtcpPort != 0 {
+		port = a.rtcpPort
+	}
+	if a.rtcpAddr != "" {
+		address = a.rtcpAddr
+	}
+	media[endpoint(address, port)] = Media{Kind: "rtcp", CallID: callID, Type: a.mediaType, Codecs: a.codecs}
+}
+
+// connectionAddress returns the address of a c= value
+// Example: "IN IP4 224.2.1.1/127" gives "224.2.1.1"
+func connectionAddress(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return ""
+	}
+	address, _, _ := strings.Cut(fields[2], "/")
+	return address
+}
@@ -5,29 +5,67 @@ package decode_sip
 // Required imports for SIP protocol analysis
 import (
 	database "DeepPacketAI/internal/storage" // Data persistence layer
+	"bytes"                                  // Message framing on streams
 	"strings"                                // String manipulation utilities
+	"time"                                   // Message timestamps
 
-	"github.com/google/gopacket" // Packet capture and analysis
-	"github.com/jart/gosip/sdp"  // SDP protocol parser
-	"github.com/jart/gosip/sip"  // SIP protocol parser
+	"github.com/jart/gosip/sdp" // SDP protocol parser
+	"github.com/jart/gosip/sip" // SIP protocol parser
 )
 
 // Process analyzes a SIP packet and extracts relevant information
 // Parameters:
-//   - l: Layer containing SIP packet data
+//   - p: UDP payload holding one SIP message
 //   - src_ipaddr: Source IP address
 //   - dst_ipaddr: Destination IP address
-//   - time: Packet capture timestamp
+//   - seen: Packet capture timestamp
 //   - frame_num: Sequential frame number
-func Process(l gopacket.Layer, src_ipaddr string, dst_ipaddr string, time string, frame_num uint64) {
+func Process(p []byte, src_ipaddr string, dst_ipaddr string, seen time.Time, frame_num uint64) {
+	store(decodeMessage(p), src_ipaddr, dst_ipaddr, seen, frame_num)
+}
+
+// ProcessStream analyzes one complete SIP message taken from a reassembled stream
+// Parameters:
+//   - p: Start line, headers and body of the message
+//   - transport: Transport carrying the message (e.g. "TCP", "WebSocket")
+//   - src_ipaddr: Source IP address
+//   - dst_ipaddr: Destination IP address
+//   - seen: Capture timestamp of the last byte
+//   - frame_num: Frame carrying the last byte
+func ProcessStream(p []byte, transport string, src_ipaddr string, dst_ipaddr string, seen time.Time, frame_num uint64) {
+	message := decodeMessage(p)
+	message["Transport"] = transport
+	store(message, src_ipaddr, dst_ipaddr, seen, frame_num)
+}
+
+// LooksLikeSIP reports whether data starts with a SIP request or status line
+// Example: "INVITE sip:bob@example.com SIP/2.0" or "SIP/2.0 180 Ringing"
+func LooksLikeSIP(data []byte) bool {
+	line, _, found := bytes.Cut(data, []byte("\r\n"))
+	if !found {
+		return false
+	}
+	return bytes.HasPrefix(line, []byte("SIP/2.0 ")) || bytes.HasSuffix(line, []byte(" SIP/2.0"))
+}
+
+// decodeMessage parses the headers and SDP body of a SIP message
+// Parameters:
+//   - p: Whole message
+func decodeMessage(p []byte) map[string]string {
+	// Body follows the empty line after the headers, some senders end lines with LF only
+	var body []byte
+	if end := bytes.Index(p, []byte("\r\n\r\n")); end >= 0 {
+		body = p[end+4:]
+	} else if end := bytes.Index(p, []byte("\n\n")); end >= 0 {
+		body = p[end+2:]
+	}
 
 	// Parse SIP message header from packet contents
-	// Combines header contents and payload for complete message
-	msgHeader, _ := sip.ParseMsg(append(l.LayerContents(), l.LayerPayload()...))
+	msgHeader, _ := sip.ParseMsg(p)
 
 	// Parse SDP body if present
 	// Contains media session information
-	msgBody, _ := sdp.Parse(string(l.LayerPayload()))
+	msgBody, _ := sdp.Parse(string(body))
 
 	// Process SIP headers into structured format
 	// Extracts individual header fields and values
@@ -37,16 +75,30 @@ func Process(l gopacket.Layer, src_ipaddr string, dst_ipaddr string, time string
 	// Includes codec, media, and network information
 	message["Message Body"] = msgBody.String()
 
+	// Note the media endpoints of offers and answers, so their RTP and RTCP are recognised
+	learnMedia(message["Call-ID"], body)
+	return message
+}
+
+// store saves a decoded SIP message
+// Messages of INVITE dialogs are added to their call first, and the call
+// record follows the message that ended the call
+func store(message map[string]string, src_ipaddr string, dst_ipaddr string, seen time.Time, frame_num uint64) {
+	ended := track(message, src_ipaddr, dst_ipaddr, seen, frame_num)
+
 	// Store processed message in database
 	// Includes packet metadata and parsed content
 	database.Insert(
-		src_ipaddr, // Source IP address
-		dst_ipaddr, // Destination IP address
-		"sip",      // Protocol identifier
-		time,       // Packet timestamp
-		frame_num,  // Frame sequence number
-		message,    // Parsed message content
+		src_ipaddr,                // Source IP address
+		dst_ipaddr,                // Destination IP address
+		"sip",                     // Protocol identifier
+		seen.Format(time.RFC3339), // Packet timestamp
+		frame_num,                 // Frame sequence number
+		message,                   // Parsed message content
 	)
+	if ended != nil {
+		ended.store()
+	}
 }
 
 // parseSIPMessage extracts header fields from SIP message
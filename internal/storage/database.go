@@ -9,12 +9,17 @@
 // Package database provides functionality for storing and managing processed network packets
 package database
 
-// AI_Input is a slice that stores all processed network messages
+import "sync"
+
+// pending collects the messages of the capture currently being decoded
 // Example: When a HTTP/2 frame is processed, its details are appended to this slice
-// Usage: AI_Input[0].Message might contain {"method": "GET", "path": "/api"}
-var AI_Input []ProcessedMessage
+// Usage: Take()[0].Message might contain {"method": "GET", "path": "/api"}
+var (
+	pendingMu sync.Mutex
+	pending   []ProcessedMessage
+)
 
-// Insert adds a new processed message to the AI_Input slice
+// Insert adds a new processed message to the pending messages
 // Parameters:
 //   - Src_IpAddr: Source IP address (e.g., "192.168.1.1")
 //   - Dst_IpAddr: Destination IP address (e.g., "10.0.0.1")
@@ -23,8 +28,9 @@ var AI_Input []ProcessedMessage
 //   - Message: Map containing decoded packet content
 //     Example: {"method": "GET", "path": "/api", "content": "request data"}
 func Insert(src_addr, dst_addr, protocol, time string, frame uint64, message map[string]string) {
-	//fmt.Println("Inserting into database")
-	AI_Input = append(AI_Input, ProcessedMessage{
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pending = append(pending, ProcessedMessage{
 		Src_IpAddr:   src_addr,
 		Dst_IpAddr:   dst_addr,
 		Protocol:     protocol,
@@ -33,3 +39,13 @@ func Insert(src_addr, dst_addr, protocol, time string, frame uint64, message map
 		Message:      message,
 	})
 }
+
+// Take returns the pending messages and starts a new, empty collection
+// Called by the analyzer once all captures of a request are decoded
+func Take() []ProcessedMessage {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	messages := pending
+	pending = nil
+	return messages
+}
@@ -0,0 +1,110 @@
+// memory.go
+// This file implements the in-memory Store used when no database file is configured.
+// Captures live until the process exits; the oldest ones are evicted once
+// maxMemoryCaptures are held so a long-running server does not grow without bound.
+
+package database
+
+import (
+	"sort"
+	"sync"
+)
+
+// maxMemoryCaptures is the number of captures kept by the in-memory store
+const maxMemoryCaptures = 8
+
+// memoryCapture is a capture held in memory
+type memoryCapture struct {
+	info     Capture
+	messages []ProcessedMessage
+}
+
+// MemoryStore keeps decoded captures in process memory
+type MemoryStore struct {
+	mu       sync.RWMutex
+	captures map[string]*memoryCapture
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{captures: make(map[string]*memoryCapture)}
+}
+
+// Save stores the messages of a capture, evicting the oldest capture if full
+func (s *MemoryStore) Save(capture Capture, messages []ProcessedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capture.Messages = len(messages)
+	s.captures[capture.ID] = &memoryCapture{info: capture, messages: messages}
+
+	for len(s.captures) > maxMemoryCaptures {
+		var oldest *memoryCapture
+		for _, c := range s.captures {
+			if oldest == nil || c.info.Created.Before(oldest.info.Created) {
+				oldest = c
+			}
+		}
+		delete(s.captures, oldest.info.ID)
+	}
+	return nil
+}
+
+// Load returns all messages of a capture
+func (s *MemoryStore) Load(id string) ([]ProcessedMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.captures[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c.messages, nil
+}
+
+// Query returns the messages of a capture matching the filter
+func (s *MemoryStore) Query(id string, filter Filter) ([]ProcessedMessage, error) {
+	messages, err := s.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ProcessedMessage
+	for _, m := range messages {
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+		if filter.Matches(m) {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+// Captures lists the stored captures, newest first
+func (s *MemoryStore) Captures() ([]Capture, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	captures := make([]Capture, 0, len(s.captures))
+	for _, c := range s.captures {
+		captures = append(captures, c.info)
+	}
+	sort.Slice(captures, func(i, j int) bool {
+		return captures[i].Created.After(captures[j].Created)
+	})
+	return captures, nil
+}
+
+// Delete removes a capture
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.captures, id)
+	return nil
+}
+
+// Close is a no-op for the in-memory store
+func (s *MemoryStore) Close() error {
+	return nil
+}
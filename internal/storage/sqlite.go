@@ -0,0 +1,247 @@
+// sqlite.go
+// This file implements the SQLite Store so decoded captures survive restarts.
+// Core functionalities:
+// - One row per ProcessedMessage, grouped by capture
+// - Indexes on protocol, source/destination IP, frame number and timestamp
+// - Message content kept as a JSON object
+// - Decodes of an older DecoderVersion dropped on open
+//
+// Example scenarios:
+// 1. Persisting a capture:
+//    -db ./deeppacketai.db stores every decoded capture in that file
+//
+// 2. Querying stored messages:
+//    Query(id, Filter{Protocol: "sip"}) uses idx_messages_protocol
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
+)
+
+// sqliteSchema creates the tables and indexes on first use
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS captures (
+	id       TEXT PRIMARY KEY,
+	files    TEXT NOT NULL,
+	messages INTEGER NOT NULL,
+	created  INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	capture_id TEXT NOT NULL REFERENCES captures(id) ON DELETE CASCADE,
+	seq        INTEGER NOT NULL,
+	protocol   TEXT NOT NULL,
+	src_ip     TEXT NOT NULL,
+	dst_ip     TEXT NOT NULL,
+	frame      INTEGER NOT NULL,
+	time_stamp TEXT NOT NULL,
+	time_unix  INTEGER NOT NULL,
+	message    TEXT NOT NULL,
+	PRIMARY KEY (capture_id, seq)
+);
+CREATE INDEX IF NOT EXISTS idx_messages_protocol ON messages(capture_id, protocol);
+CREATE INDEX IF NOT EXISTS idx_messages_src_ip ON messages(capture_id, src_ip);
+CREATE INDEX IF NOT EXISTS idx_messages_dst_ip ON messages(capture_id, dst_ip);
+CREATE INDEX IF NOT EXISTS idx_messages_frame ON messages(capture_id, frame);
+CREATE INDEX IF NOT EXISTS idx_messages_time ON messages(capture_id, time_unix);
+`
+
+// SQLiteStore keeps decoded captures in an SQLite database file
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the database file at path
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating database schema: %v", err)
+	}
+	if err := dropStaleCaptures(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error checking decoder version: %v", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// dropStaleCaptures deletes the captures decoded by another DecoderVersion
+// The version of the stored decodes is kept in the user_version pragma
+func dropStaleCaptures(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version == DecoderVersion {
+		return nil
+	}
+	if _, err := db.Exec(`DELETE FROM captures`); err != nil { // Messages go with them
+		return err
+	}
+	_, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, DecoderVersion))
+	return err
+}
+
+// Save stores the messages of a capture in one transaction
+func (s *SQLiteStore) Save(capture Capture, messages []ProcessedMessage) error {
+	files, err := json.Marshal(capture.Files)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	// Replace any previous copy, messages go with it
+	if _, err := tx.Exec(`DELETE FROM captures WHERE id = ?`, capture.ID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO captures (id, files, messages, created) VALUES (?, ?, ?, ?)`,
+		capture.ID, string(files), len(messages), capture.Created.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO messages
+		(capture_id, seq, protocol, src_ip, dst_ip, frame, time_stamp, time_unix, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, m := range messages {
+		content, err := json.Marshal(m.Message)
+		if err != nil {
+			return err
+		}
+		var unix int64
+		if ts, err := time.Parse(time.RFC3339, m.Time_Stamp); err == nil {
+			unix = ts.UnixNano()
+		}
+		_, err = stmt.Exec(capture.ID, i, m.Protocol, m.Src_IpAddr, m.Dst_IpAddr,
+			int64(m.Frame_Number), m.Time_Stamp, unix, string(content))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Load returns all messages of a capture
+func (s *SQLiteStore) Load(id string) ([]ProcessedMessage, error) {
+	var exists int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM captures WHERE id = ?`, id).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, ErrNotFound
+	}
+	return s.Query(id, Filter{})
+}
+
+// Query returns the messages of a capture matching the filter
+func (s *SQLiteStore) Query(id string, filter Filter) ([]ProcessedMessage, error) {
+	where := []string{"capture_id = ?"}
+	args := []any{id}
+
+	if filter.Protocol != "" {
+		where = append(where, "protocol = ?")
+		args = append(args, filter.Protocol)
+	}
+	if filter.IP != "" {
+		where = append(where, "(src_ip = ? OR dst_ip = ?)")
+		args = append(args, filter.IP, filter.IP)
+	}
+	if filter.FromFrame != 0 {
+		where = append(where, "frame >= ?")
+		args = append(args, int64(filter.FromFrame))
+	}
+	if filter.ToFrame != 0 {
+		where = append(where, "frame <= ?")
+		args = append(args, int64(filter.ToFrame))
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "time_unix >= ?")
+		args = append(args, filter.From.UnixNano())
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "time_unix <= ?")
+		args = append(args, filter.To.UnixNano())
+	}
+
+	query := `SELECT protocol, src_ip, dst_ip, frame, time_stamp, message FROM messages WHERE ` +
+		strings.Join(where, " AND ") + ` ORDER BY seq`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ProcessedMessage
+	for rows.Next() {
+		var m ProcessedMessage
+		var frame int64
+		var content string
+		if err := rows.Scan(&m.Protocol, &m.Src_IpAddr, &m.Dst_IpAddr, &frame, &m.Time_Stamp, &content); err != nil {
+			return nil, err
+		}
+		m.Frame_Number = uint64(frame)
+		if err := json.Unmarshal([]byte(content), &m.Message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// Captures lists the stored captures, newest first
+func (s *SQLiteStore) Captures() ([]Capture, error) {
+	rows, err := s.db.Query(`SELECT id, files, messages, created FROM captures ORDER BY created DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var captures []Capture
+	for rows.Next() {
+		var c Capture
+		var files string
+		var created int64
+		if err := rows.Scan(&c.ID, &files, &c.Messages, &created); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(files), &c.Files)
+		c.Created = time.Unix(0, created)
+		captures = append(captures, c)
+	}
+	return captures, rows.Err()
+}
+
+// Delete removes a capture and its messages
+func (s *SQLiteStore) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM captures WHERE id = ?`, id)
+	return err
+}
+
+// Close closes the database file
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
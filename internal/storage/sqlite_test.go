@@ -0,0 +1,51 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSQLiteStoreDropsStaleDecodes reopens a database written by another decoder
+// version and checks its captures are gone while current ones survive a reopen
+func TestSQLiteStoreDropsStaleDecodes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decodes.db")
+	messages := []ProcessedMessage{{Src_IpAddr: "10.0.0.1", Dst_IpAddr: "10.0.0.2", Protocol: "sip",
+		Frame_Number: 1, Time_Stamp: "2024-01-01T00:00:00Z", Message: map[string]string{"method": "INVITE"}}}
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(Capture{ID: "current", Created: time.Now()}, messages); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// A reopen by the same version keeps the decode
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Load("current"); err != nil || len(got) != 1 {
+		t.Fatalf("Load after reopen = %d messages, %v", len(got), err)
+	}
+	if _, err := store.db.Exec(`PRAGMA user_version = 0`); err != nil { // As left by an older version
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Load("current"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load of stale decode: err = %v, want ErrNotFound", err)
+	}
+	var rows int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&rows); err != nil || rows != 0 {
+		t.Errorf("%d stale message rows left, %v", rows, err)
+	}
+}
@@ -0,0 +1,156 @@
+// store.go
+// This file defines where decoded captures are kept between analyses.
+// Core functionalities:
+// - Store interface shared by the in-memory and SQLite backends
+// - Capture identification by file content and decode options
+// - Filtered queries over stored messages
+//
+// Example scenarios:
+// 1. Re-uploading a capture:
+//    The capture ID matches a stored capture, so its messages are
+//    loaded without decoding the pcap again
+//
+// 2. Restarting the server:
+//    With -db set, decoded captures survive in the SQLite file
+
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DecoderVersion identifies the output of the dissectors
+// Increase it whenever a decoder change alters the messages decoded from the same
+// capture, so stored decodes made by older versions are not reused
+const DecoderVersion = 6
+
+// ErrNotFound is returned when a capture is not in the store
+var ErrNotFound = errors.New("capture not found")
+
+// Capture describes a stored capture set
+type Capture struct {
+	ID       string    // Content hash of the capture files and decode options
+	Files    []string  // Capture file paths when decoded
+	Messages int       // Number of decoded messages
+	Created  time.Time // When the capture was decoded
+}
+
+// Filter selects messages of a stored capture
+// Zero values match everything
+// Example: Filter{Protocol: "sip", IP: "10.0.0.1"} returns SIP messages to or from 10.0.0.1
+type Filter struct {
+	Protocol  string    // Protocol identifier (e.g. "sip", "http")
+	IP        string    // Source or destination IP address
+	FromFrame uint64    // First frame number
+	ToFrame   uint64    // Last frame number
+	From      time.Time // Earliest packet timestamp
+	To        time.Time // Latest packet timestamp
+	Limit     int       // Maximum number of messages
+}
+
+// Store keeps decoded messages per capture
+type Store interface {
+	// Save stores the messages of a capture, replacing any previous copy
+	Save(capture Capture, messages []ProcessedMessage) error
+
+	// Load returns all messages of a capture in frame order
+	// Returns ErrNotFound for unknown captures
+	Load(id string) ([]ProcessedMessage, error)
+
+	// Query returns the messages of a capture matching the filter in frame order
+	Query(id string, filter Filter) ([]ProcessedMessage, error)
+
+	// Captures lists the stored captures, newest first
+	Captures() ([]Capture, error)
+
+	// Delete removes a capture and its messages
+	Delete(id string) error
+
+	// Close releases the backend
+	Close() error
+}
+
+// Default is the store used by the analyzer
+// Replaced by Open when a database file is configured
+var Default Store = NewMemoryStore()
+
+// Open selects the store used by the analyzer
+// Parameters:
+//   - path: SQLite database file, in-memory store when empty
+func Open(path string) error {
+	if path == "" {
+		Default = NewMemoryStore()
+		return nil
+	}
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		return err
+	}
+	Default = store
+	return nil
+}
+
+// CaptureID identifies a capture set by content
+// Parameters:
+//   - files: Capture files in decode order
+//   - options: Settings that change the decoded result (e.g. time window)
+//
+// Returns: Hex SHA-256 over the decoder version, file contents and options
+func CaptureID(files []string, options ...string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "decoder=%d", DecoderVersion)
+	h.Write([]byte{0})
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0}) // Separate files
+	}
+	for _, option := range options {
+		h.Write([]byte(option))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Matches reports whether a message passes the filter
+// Used by the in-memory store and the AI tools; the SQLite store filters in SQL
+func (f Filter) Matches(m ProcessedMessage) bool {
+	if f.Protocol != "" && m.Protocol != f.Protocol {
+		return false
+	}
+	if f.IP != "" && m.Src_IpAddr != f.IP && m.Dst_IpAddr != f.IP {
+		return false
+	}
+	if f.FromFrame != 0 && m.Frame_Number < f.FromFrame {
+		return false
+	}
+	if f.ToFrame != 0 && m.Frame_Number > f.ToFrame {
+		return false
+	}
+	if !f.From.IsZero() || !f.To.IsZero() {
+		ts, err := time.Parse(time.RFC3339, m.Time_Stamp)
+		if err != nil {
+			return false
+		}
+		if !f.From.IsZero() && ts.Before(f.From) {
+			return false
+		}
+		if !f.To.IsZero() && ts.After(f.To) {
+			return false
+		}
+	}
+	return true
+}
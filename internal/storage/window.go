@@ -0,0 +1,85 @@
+// window.go
+// This file keeps the most recent decoded messages of a live capture.
+// Core functionalities:
+// - Bounded by message count and by age relative to the newest message
+// - Safe for one decoding goroutine and many readers
+//
+// Example scenario:
+//    -live eth0 -window 5000 -window-age 5m keeps at most 5000 messages
+//    from the last five minutes of traffic for the AI to analyse
+
+package database
+
+import (
+	"sync"
+	"time"
+)
+
+// Window is a rolling window of decoded messages
+type Window struct {
+	mu       sync.Mutex
+	limit    int           // Maximum number of messages, unbounded when 0
+	maxAge   time.Duration // Maximum age relative to the newest message, unbounded when 0
+	messages []ProcessedMessage
+	total    uint64 // Messages added since the window was created
+}
+
+// NewWindow creates an empty rolling window
+// Parameters:
+//   - limit: Maximum number of messages kept, 0 for no limit
+//   - maxAge: Maximum age of kept messages, 0 for no limit
+func NewWindow(limit int, maxAge time.Duration) *Window {
+	return &Window{limit: limit, maxAge: maxAge}
+}
+
+// Add appends decoded messages and evicts the ones outside the window
+func (w *Window) Add(messages ...ProcessedMessage) {
+	if len(messages) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.messages = append(w.messages, messages...)
+	w.total += uint64(len(messages))
+
+	// Drop the oldest messages beyond the count limit
+	drop := 0
+	if w.limit > 0 && len(w.messages) > w.limit {
+		drop = len(w.messages) - w.limit
+	}
+
+	// Drop messages older than maxAge before the newest one
+	// Capture timestamps are used so replayed files behave like live traffic
+	if w.maxAge > 0 {
+		newest, err := time.Parse(time.RFC3339, w.messages[len(w.messages)-1].Time_Stamp)
+		if err == nil {
+			oldest := newest.Add(-w.maxAge)
+			for drop < len(w.messages) {
+				ts, err := time.Parse(time.RFC3339, w.messages[drop].Time_Stamp)
+				if err != nil || !ts.Before(oldest) {
+					break
+				}
+				drop++
+			}
+		}
+	}
+
+	// Evicted messages are released when append next grows the slice
+	w.messages = w.messages[drop:]
+}
+
+// Messages returns a copy of the messages currently in the window
+func (w *Window) Messages() []ProcessedMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]ProcessedMessage(nil), w.messages...)
+}
+
+// Total returns the number of messages added since the window was created
+func (w *Window) Total() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.total
+}
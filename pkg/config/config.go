@@ -6,11 +6,29 @@
 // - Handles compressed pcap files
 //
 // Example configurations:
-// 1. Time Range Analysis:
-//    --start "15:04:05" --end "15:05:00"
-//    Analyzes packets within specified timeframe
+// 1. Batch Analysis:
+//    -i capture.pcap -p "List failed registrations" -llm Ollama -m llama3
+//    Decodes the capture, asks the AI once and prints the answer
 //
-// 2. Compressed File Processing:
+// 2. Time Range Analysis:
+//    -start-time "15:04:05" -end-time "15:05:00"
+//    Analyzes packets within specified timeframe of the capture day
+//    -start-time "2024-03-20T15:04:05Z" -end-time "2024-03-20T15:06:05Z"
+//    Analyzes packets within an absolute RFC3339 window
+//
+// 3. Persistent Storage:
+//    -db deeppacketai.db
+//    Keeps decoded captures across restarts, unchanged captures are not decoded again
+//
+// 4. Live Capture:
+//    -live eth0 -bpf "udp port 5060" -duration 60s -p "Any failed calls?"
+//    Captures for a minute, then asks the AI about the rolling window
+//
+// 5. Protocols on other ports:
+//    -ports "http2:8080,29500-29599;diameter:3869"
+//    Decodes those ports as HTTP/2 and Diameter instead of guessing from the content
+//
+// 6. Compressed File Processing:
 //    input.pcap.gz -> input.pcap
 //    Automatically extracts compressed captures
 
@@ -23,6 +41,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -33,7 +52,31 @@ type UserInput struct {
 	EndTime   time.Time
 	Prompt    string
 	Url       string
+	OpenAIUrl string
+	LLM       string
 	Model     string
+	Database  string
+	Window    int
+	Embed     string
+	Ports     []PortMapping // Protocols on other than their well-known ports
+
+	// Live capture
+	Live        string        // Network interface to capture from
+	Replay      string        // Capture file fed through live mode
+	BPF         string        // BPF filter for live capture
+	Snaplen     int           // Bytes captured per packet
+	Promiscuous bool          // Capture in promiscuous mode
+	Duration    time.Duration // Live capture duration, 0 for no limit
+	PacketLimit uint64        // Live capture packet limit, 0 for no limit
+	WindowSize  int           // Decoded messages kept from a live capture
+	WindowAge   time.Duration // Maximum age of kept messages, 0 for no limit
+}
+
+// PortMapping assigns a range of UDP, TCP or SCTP ports to a protocol decoder
+// Example: {Protocol: "http2", First: 29500, Last: 29599}
+type PortMapping struct {
+	Protocol    string // Decoder name, e.g. "sip", "http2", "diameter"
+	First, Last uint16 // Port range, both included
 }
 
 var Input UserInput
@@ -41,11 +84,27 @@ var Input UserInput
 func HandleUserInput() {
 	filesArg := flag.String("i", "", "Comma-separated list of pcap files to process")
 	dirArg := flag.String("d", "", "Directory containing pcap files")
-	startTime := flag.String("start-time", "", "Start time in HH:MM or HH:MM:SS format")
-	endTime := flag.String("end-time", "", "Start time in HH:MM or HH:MM:SS format")
+	startTime := flag.String("start-time", "", "Start time in HH:MM, HH:MM:SS or RFC3339 format")
+	endTime := flag.String("end-time", "", "End time in HH:MM, HH:MM:SS or RFC3339 format")
 	flag.StringVar(&Input.Prompt, "p", "", "Prompt string")
-	flag.StringVar(&Input.Url, "u", "", "Url where AI model is running e.g., https://ollama.run.app/api/chat or http://localhost:11434/api/chat")
-	flag.StringVar(&Input.Model, "m", "", "Name of Ollama AI Model e.g., gemma2:2b, mistral etc.")
+	flag.StringVar(&Input.Url, "u", "", "Url where the Ollama model is running e.g., https://ollama.run.app/api/chat or http://localhost:11434/api/chat")
+	flag.StringVar(&Input.OpenAIUrl, "openai-url", "", "Base URL of an OpenAI-compatible server used with -llm ChatGPT, e.g. http://localhost:8000/v1")
+	flag.StringVar(&Input.LLM, "llm", "Ollama", "AI provider used for batch analysis: ChatGPT, Ollama or Gemini")
+	flag.StringVar(&Input.Model, "m", "", "Name of AI Model e.g., gpt-4o, gemma2:2b, mistral, gemini-1.5-flash etc.")
+	flag.IntVar(&Input.Window, "ctx", 0, "Context window of the AI model in tokens (estimated from the model name when 0)")
+	flag.StringVar(&Input.Embed, "embed-model", "", "Ollama embedding model used to find messages relevant to a question, e.g. nomic-embed-text")
+	portsArg := flag.String("ports", "", "Decode ports as a protocol, e.g. \"http2:8080,29500-29599;diameter:3869;rtp:10000-20000\" (RTP with dynamic payload types and no captured SDP needs a mapping)")
+	flag.StringVar(&Input.Database, "db", "", "SQLite database file for decoded captures (kept in memory when empty)")
+
+	flag.StringVar(&Input.Live, "live", "", "Network interface to capture from, e.g. eth0")
+	flag.StringVar(&Input.Replay, "replay", "", "Capture file fed through live mode instead of an interface (for testing)")
+	flag.StringVar(&Input.BPF, "bpf", "", "BPF filter for live capture, e.g. \"udp port 5060 or tcp port 8080\"")
+	flag.IntVar(&Input.Snaplen, "snaplen", 65535, "Bytes captured per packet in live mode")
+	flag.BoolVar(&Input.Promiscuous, "promisc", true, "Capture in promiscuous mode")
+	flag.DurationVar(&Input.Duration, "duration", 0, "Stop live capture after this long, e.g. 30s or 5m")
+	flag.Uint64Var(&Input.PacketLimit, "count", 0, "Stop live capture after this many packets")
+	flag.IntVar(&Input.WindowSize, "window", 10000, "Decoded messages kept from a live capture")
+	flag.DurationVar(&Input.WindowAge, "window-age", 0, "Drop live messages older than this, e.g. 10m")
 
 	flag.Parse()
 
@@ -58,50 +117,111 @@ func HandleUserInput() {
 
 	// Process -d option (directory)
 	if *dirArg != "" {
-		files, err := os.ReadDir(*dirArg)
+		files, err := ListPcapFiles(*dirArg)
 		if err != nil {
 			log.Fatalf("Error reading directory: %v", err)
 		}
-		for _, file := range files {
-			if !file.IsDir() && (strings.HasSuffix(file.Name(), ".pcap") || strings.HasSuffix(file.Name(), ".pcapng")) {
-				Input.Files = append(Input.Files, filepath.Join(*dirArg, file.Name()))
-			}
-		}
+		Input.Files = append(Input.Files, files...)
 	}
 
 	validateTime(startTime, endTime)
-}
 
-func SaveDirectoryFiles(dirArg *string) {
-	if *dirArg != "" {
-		files, err := os.ReadDir(*dirArg)
+	// Process -ports option
+	if *portsArg != "" {
+		ports, err := ParsePorts(*portsArg)
 		if err != nil {
-			log.Fatalf("Error reading directory: %v", err)
+			fmt.Println("Invalid -ports option:", err)
+			os.Exit(1)
 		}
-		for _, file := range files {
-			if !file.IsDir() && (strings.HasSuffix(file.Name(), ".pcap") || strings.HasSuffix(file.Name(), ".pcapng")) {
-				Input.Files = append(Input.Files, filepath.Join(*dirArg, file.Name()))
+		Input.Ports = ports
+	}
+}
+
+// ParsePorts reads protocol port mappings
+// Parameters:
+// - text: Mappings separated by ";", each "protocol:ports" with a comma-separated
+// list of ports and port ranges, e.g. "http2:8080,29500-29599;diameter:3869"
+// Returns:
+// - One mapping per port or range, in the order given
+// - Error if a mapping cannot be read
+func ParsePorts(text string) ([]PortMapping, error) {
+	var mappings []PortMapping
+	for _, entry := range strings.Split(text, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		protocol, list, found := strings.Cut(entry, ":")
+		protocol = strings.ToLower(strings.TrimSpace(protocol))
+		if !found || protocol == "" {
+			return nil, fmt.Errorf("%q is not protocol:ports", entry)
+		}
+		for _, ports := range strings.Split(list, ",") {
+			first, last, isRange := strings.Cut(strings.TrimSpace(ports), "-")
+			if !isRange {
+				last = first
 			}
+			from, err := parsePort(first)
+			if err != nil {
+				return nil, err
+			}
+			to, err := parsePort(last)
+			if err != nil {
+				return nil, err
+			}
+			if to < from {
+				return nil, fmt.Errorf("port range %q ends before it starts", ports)
+			}
+			mappings = append(mappings, PortMapping{Protocol: protocol, First: from, Last: to})
 		}
 	}
+	return mappings, nil
+}
+
+// parsePort reads one port number between 1 and 65535
+func parsePort(text string) (uint16, error) {
+	port, err := strconv.ParseUint(strings.TrimSpace(text), 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("invalid port %q", text)
+	}
+	return uint16(port), nil
+}
+
+// ListPcapFiles returns the .pcap and .pcapng files of a directory
+// Parameters:
+// - dir: Directory to scan (not recursive)
+// Returns:
+// - Paths of the capture files
+// - Error if the directory cannot be read
+func ListPcapFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range entries {
+		if !file.IsDir() && (strings.HasSuffix(file.Name(), ".pcap") || strings.HasSuffix(file.Name(), ".pcapng")) {
+			files = append(files, filepath.Join(dir, file.Name()))
+		}
+	}
+	return files, nil
 }
 
 // validateTime ensures time parameters are properly formatted
 // Parameters:
-// - startTime: Beginning of analysis period (HH:MM:SS)
-// - endTime: End of analysis period (HH:MM:SS)
+// - startTime: Beginning of analysis period (HH:MM:SS or RFC3339)
+// - endTime: End of analysis period (HH:MM:SS or RFC3339)
 func validateTime(startTime, endTime *string) {
 	// Validate start time format if provided
-	// Accepts HH:MM or HH:MM:SS
+	// Accepts HH:MM, HH:MM:SS or RFC3339
 	if *startTime != "" && !isTimeValid(*startTime) {
-		fmt.Println("Invalid start time format. Please use HH:MM or HH:MM:SS.")
+		fmt.Println("Invalid start time format. Please use HH:MM, HH:MM:SS or RFC3339.")
 		os.Exit(1)
 	}
 
 	// Validate end time format if provided
-	// Accepts HH:MM or HH:MM:SS
+	// Accepts HH:MM, HH:MM:SS or RFC3339
 	if *endTime != "" && !isTimeValid(*endTime) {
-		fmt.Println("Invalid start time format. Please use HH:MM or HH:MM:SS.")
+		fmt.Println("Invalid end time format. Please use HH:MM, HH:MM:SS or RFC3339.")
 		os.Exit(1)
 	}
 
@@ -125,6 +245,62 @@ func validateTime(startTime, endTime *string) {
 			os.Exit(1)
 		}
 	}
+
+	// Absolute windows must not end before they start
+	// Clock-only windows may wrap around midnight
+	if !isTimeOfDay(Input.StartTime) && !isTimeOfDay(Input.EndTime) &&
+		!Input.StartTime.IsZero() && !Input.EndTime.IsZero() &&
+		Input.EndTime.Before(Input.StartTime) {
+		fmt.Println("End time is before start time.")
+		os.Exit(1)
+	}
+}
+
+// InTimeWindow reports whether a packet timestamp falls inside the
+// configured -start-time/-end-time window
+// Parameters:
+// - ts: Capture timestamp of the packet
+//
+// HH:MM[:SS] bounds are applied to the day of the packet in its own
+// time zone, RFC3339 bounds are compared as absolute instants.
+// Example: start 23:50 and end 00:10 keeps packets around midnight
+func (u UserInput) InTimeWindow(ts time.Time) bool {
+	// No window configured, keep every packet
+	if u.StartTime.IsZero() && u.EndTime.IsZero() {
+		return true
+	}
+
+	afterStart := u.StartTime.IsZero() || !ts.Before(windowBound(u.StartTime, ts))
+	beforeEnd := u.EndTime.IsZero() || !ts.After(windowBound(u.EndTime, ts))
+
+	// Clock-only window wrapping midnight (e.g. 23:50 to 00:10)
+	if isTimeOfDay(u.StartTime) && isTimeOfDay(u.EndTime) &&
+		windowBound(u.EndTime, ts).Before(windowBound(u.StartTime, ts)) {
+		return afterStart || beforeEnd
+	}
+
+	return afterStart && beforeEnd
+}
+
+// isTimeOfDay reports whether t was parsed from HH:MM or HH:MM:SS
+// Clock-only layouts parse to year 0, RFC3339 always carries a date
+func isTimeOfDay(t time.Time) bool {
+	return !t.IsZero() && t.Year() == 0
+}
+
+// windowBound resolves a window bound for the given packet timestamp
+// Parameters:
+// - bound: Parsed start or end time
+// - ts: Packet timestamp providing the capture day
+//
+// Returns: bound unchanged when absolute, otherwise the same clock time
+// on the packet's day
+func windowBound(bound, ts time.Time) time.Time {
+	if !isTimeOfDay(bound) {
+		return bound
+	}
+	return time.Date(ts.Year(), ts.Month(), ts.Day(),
+		bound.Hour(), bound.Minute(), bound.Second(), 0, ts.Location())
 }
 
 // parseTime converts string time to time.Time
@@ -156,9 +332,14 @@ func parseTime(timeStr string) (time.Time, error) {
 // Parameters:
 // - timeStr: Time string to validate
 // Returns:
-// - true if format is valid (HH:MM or HH:MM:SS)
+// - true if format is valid (HH:MM, HH:MM:SS or RFC3339)
 // - false otherwise
 func isTimeValid(timeStr string) bool {
+	// Full timestamps such as 2024-03-20T15:04:05Z
+	if _, err := time.Parse(time.RFC3339, timeStr); err == nil {
+		return true
+	}
+
 	// Regular expression for time format validation
 	// Matches both HH:MM and HH:MM:SS
 	timeRegex := regexp.MustCompile(`^([0-1][0-9]|2[0-3]):[0-5][0-9](:[0-5][0-9])?$`)
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestInTimeWindow checks -start-time/-end-time windows given as clock times and as RFC3339 instants
+func TestInTimeWindow(t *testing.T) {
+	plus2 := time.FixedZone("UTC+2", 2*60*60)
+	at := func(text string) time.Time {
+		ts, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		name       string
+		start, end string // Flag values, empty when not given
+		ts         time.Time
+		want       bool
+	}{
+		{"no window", "", "", at("2026-01-01T12:00:00Z"), true},
+
+		// HH:MM[:SS] on the day of the packet
+		{"inside a clock window", "09:00", "17:00", at("2026-01-01T12:00:00Z"), true},
+		{"before a clock window", "09:00", "17:00", at("2026-01-01T08:59:59Z"), false},
+		{"at the start of a clock window", "09:00", "17:00", at("2026-01-01T09:00:00Z"), true},
+		{"at the end of a clock window", "09:00", "17:00", at("2026-01-01T17:00:00Z"), true},
+		{"after a clock window", "09:00", "17:00", at("2026-01-01T17:00:00.5Z"), false},
+		{"clock window with seconds", "09:00:30", "09:00:45", at("2026-01-01T09:00:40Z"), true},
+		{"clock window on another day", "09:00", "17:00", at("2031-07-15T12:00:00Z"), true},
+		{"clock start only", "09:00", "", at("2026-01-01T23:59:59Z"), true},
+		{"clock end only", "", "17:00", at("2026-01-01T17:01:00Z"), false},
+		{"clock window in the packet's time zone", "09:00", "10:00", at("2026-01-01T09:30:00+05:00"), true},
+
+		// Clock window wrapping midnight
+		{"before midnight", "23:50", "00:10", at("2026-01-01T23:55:00Z"), true},
+		{"after midnight", "23:50", "00:10", at("2026-01-02T00:05:00Z"), true},
+		{"at midnight", "23:50", "00:10", at("2026-01-02T00:00:00Z"), true},
+		{"midday outside a window wrapping midnight", "23:50", "00:10", at("2026-01-01T12:00:00Z"), false},
+		{"just before a window wrapping midnight", "23:50", "00:10", at("2026-01-01T23:49:59Z"), false},
+		{"just after a window wrapping midnight", "23:50", "00:10", at("2026-01-02T00:10:01Z"), false},
+
+		// RFC3339 instants
+		{"inside an RFC3339 window", "2026-01-01T09:00:00Z", "2026-01-01T17:00:00Z", at("2026-01-01T12:00:00Z"), true},
+		{"before an RFC3339 window", "2026-01-01T09:00:00Z", "2026-01-01T17:00:00Z", at("2026-01-01T08:00:00Z"), false},
+		{"same clock time on another day", "2026-01-01T09:00:00Z", "2026-01-01T17:00:00Z", at("2026-01-02T12:00:00Z"), false},
+		{"RFC3339 start only", "2026-01-01T09:00:00Z", "", at("2031-07-15T00:00:00Z"), true},
+		{"RFC3339 bound in another time zone", "2026-01-01T10:00:00+02:00", "2026-01-01T11:00:00+02:00", at("2026-01-01T08:30:00Z"), true},
+		{"RFC3339 window crossing midnight", "2026-01-01T23:50:00Z", "2026-01-02T00:10:00Z", at("2026-01-02T00:05:00Z"), true},
+		{"RFC3339 window crossing midnight, day before", "2026-01-01T23:50:00Z", "2026-01-02T00:10:00Z", at("2026-01-01T00:05:00Z"), false},
+		{"RFC3339 window crossing midnight, day after", "2026-01-01T23:50:00Z", "2026-01-02T00:10:00Z", at("2026-01-02T23:55:00Z"), false},
+		{"RFC3339 window crossing local midnight", "2026-01-01T23:50:00+02:00", "2026-01-02T00:10:00+02:00", time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC).In(plus2), true},
+	}
+	for _, tt := range tests {
+		var u UserInput
+		var err error
+		if tt.start != "" {
+			if u.StartTime, err = parseTime(tt.start); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		if tt.end != "" {
+			if u.EndTime, err = parseTime(tt.end); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		if got := u.InTimeWindow(tt.ts); got != tt.want {
+			t.Errorf("%s: InTimeWindow(%s) = %v, want %v", tt.name, tt.ts.Format(time.RFC3339Nano), got, tt.want)
+		}
+	}
+}